@@ -0,0 +1,329 @@
+// Package alerts raises missing-child alerts for students who left home but
+// were not confirmed at school by their grade's cutoff, and escalates alerts
+// nobody acknowledges.
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/mailer"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultCutoff applies to grades without a configured cutoff unless
+	// ALERT_DEFAULT_CUTOFF overrides it.
+	DefaultCutoff = "09:00"
+	// DefaultEscalateAfterMinutes applies to grades without a configured cutoff.
+	DefaultEscalateAfterMinutes = 15
+	// defaultCheckInterval is how often the scheduler looks for overdue
+	// students unless ALERT_CHECK_INTERVAL overrides it.
+	defaultCheckInterval = time.Minute
+)
+
+// Scheduler periodically raises, escalates and resolves alerts. Several
+// instances may run at once: every change is a conditional update, so each
+// alert is raised and escalated exactly once.
+type Scheduler struct {
+	db            *gorm.DB
+	mail          mailer.Mailer
+	interval      time.Duration
+	defaultCutoff string
+}
+
+// contact is a parent or staff member an alert is sent to.
+type contact struct {
+	ID    uuid.UUID
+	Email string
+	Type  string
+}
+
+// overdueStudent is a student who left home today and is not at school yet.
+type overdueStudent struct {
+	StudentID uuid.UUID
+	FirstName string
+	LastName  string
+	Grade     string
+	Cutoff    *string
+}
+
+// NewScheduler creates a scheduler configured from ALERT_DEFAULT_CUTOFF
+// (HH:MM) and ALERT_CHECK_INTERVAL (a Go duration such as "1m").
+func NewScheduler(db *gorm.DB, mail mailer.Mailer) (*Scheduler, error) {
+	s := &Scheduler{db: db, mail: mail, interval: defaultCheckInterval, defaultCutoff: DefaultCutoff}
+	if cutoff := os.Getenv("ALERT_DEFAULT_CUTOFF"); cutoff != "" {
+		if _, err := ParseCutoff(cutoff); err != nil {
+			return nil, fmt.Errorf("ALERT_DEFAULT_CUTOFF: %w", err)
+		}
+		s.defaultCutoff = cutoff
+	}
+	if interval := os.Getenv("ALERT_CHECK_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("ALERT_CHECK_INTERVAL: invalid duration %q", interval)
+		}
+		s.interval = d
+	}
+	return s, nil
+}
+
+// ConfiguredDefaultCutoff returns ALERT_DEFAULT_CUTOFF, or DefaultCutoff when
+// it is unset or invalid.
+func ConfiguredDefaultCutoff() string {
+	cutoff := os.Getenv("ALERT_DEFAULT_CUTOFF")
+	if _, err := ParseCutoff(cutoff); err != nil {
+		return DefaultCutoff
+	}
+	return cutoff
+}
+
+// ParseCutoff parses an HH:MM cutoff into the time elapsed since midnight.
+func ParseCutoff(cutoff string) (time.Duration, error) {
+	t, err := time.Parse("15:04", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cutoff %q, expected HH:MM", cutoff)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Start runs the scheduler in the background until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			if err := s.Run(ctx, time.Now()); err != nil {
+				log.Printf("Error checking missing-child alerts: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Run performs one check at now: alerts of students who have been found, at
+// school or back home, are resolved, overdue students get new alerts, and alerts left
+// unacknowledged too long are escalated.
+func (s *Scheduler) Run(ctx context.Context, now time.Time) error {
+	if err := s.resolveFound(ctx, now); err != nil {
+		return err
+	}
+	if err := s.raiseAlerts(ctx, now); err != nil {
+		return err
+	}
+	return s.escalateAlerts(ctx, now)
+}
+
+// raiseAlerts creates an alert for every student past their grade's cutoff
+// who left home today but has not arrived at school.
+func (s *Scheduler) raiseAlerts(ctx context.Context, now time.Time) error {
+	now = now.In(helpers.SchoolLocation)
+	date := now.Format(helpers.DateLayout)
+	var students []overdueStudent
+	err := s.db.Raw(`SELECT ad.student_id, s.first_name, s.last_name, s.grade, gc.cutoff
+		FROM attendance_days ad
+		JOIN students s ON s.id = ad.student_id
+		LEFT JOIN grade_cutoffs gc ON gc.grade = s.grade
+		WHERE ad.date = ? AND ad.state = ?
+		AND NOT EXISTS (SELECT 1 FROM missing_child_alerts a WHERE a.student_id = ad.student_id AND a.date = ad.date)`,
+		date, models.AttendanceLeftHome).Scan(&students).Error
+	if err != nil {
+		return err
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, student := range students {
+		cutoff := s.defaultCutoff
+		if student.Cutoff != nil {
+			cutoff = *student.Cutoff
+		}
+		offset, err := ParseCutoff(cutoff)
+		if err != nil {
+			log.Printf("Skipping grade %s with %v", student.Grade, err)
+			continue
+		}
+		if now.Before(midnight.Add(offset)) {
+			continue
+		}
+		if err := s.raise(ctx, student, date); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// raise creates the alert of one student and notifies the student's guardians
+// and the staff supervising the grade.
+func (s *Scheduler) raise(ctx context.Context, student overdueStudent, date string) error {
+	var recipients []contact
+	raised := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		alert := models.MissingChildAlert{StudentID: student.StudentID, Date: date, Grade: student.Grade, Status: models.AlertOpen}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&alert)
+		if result.Error != nil || result.RowsAffected == 0 {
+			// Another instance raised it first
+			return result.Error
+		}
+		raised = true
+
+		var guardians, staff []contact
+		if err := tx.Raw(`SELECT p.id, p.email, ? AS type FROM parents p
+			JOIN guardianships g ON g.parent_id = p.id WHERE g.student_id = ?`,
+			"parent", student.StudentID).Scan(&guardians).Error; err != nil {
+			return err
+		}
+		if err := tx.Raw(`SELECT id, email, ? AS type FROM staffs WHERE supervise_grade = ? AND supervise_grade <> ''`,
+			"staff", student.Grade).Scan(&staff).Error; err != nil {
+			return err
+		}
+		recipients = append(guardians, staff...)
+		return addRecipients(tx, alert.ID, recipients, false)
+	})
+	if err != nil || !raised {
+		return err
+	}
+
+	name := student.FirstName + " " + student.LastName
+	log.Printf("Missing-child alert raised for student %s (%s)", student.StudentID, name)
+	s.notify(ctx, recipients, "Missing child alert: "+name,
+		fmt.Sprintf("%s left home today but has not been confirmed at school (grade %s). Please check on them and acknowledge the alert in guardApi.", name, student.Grade))
+	return nil
+}
+
+// escalateAlerts adds the admins to every open alert nobody acknowledged in
+// time.
+func (s *Scheduler) escalateAlerts(ctx context.Context, now time.Time) error {
+	var alerts []models.MissingChildAlert
+	err := s.db.Preload("Student").
+		Joins("LEFT JOIN grade_cutoffs gc ON gc.grade = missing_child_alerts.grade").
+		Where("missing_child_alerts.status = ?", models.AlertOpen).
+		Where("missing_child_alerts.created_at + make_interval(mins => COALESCE(gc.escalate_after_minutes, ?)) <= ?",
+			DefaultEscalateAfterMinutes, now).
+		Find(&alerts).Error
+	if err != nil {
+		return err
+	}
+
+	for _, alert := range alerts {
+		var admins []contact
+		escalated := false
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&models.MissingChildAlert{}).
+				Where("id = ? AND status = ?", alert.ID, models.AlertOpen).
+				Updates(map[string]interface{}{"status": models.AlertEscalated, "escalated_at": now})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			escalated = true
+
+			if err := tx.Raw(`SELECT id, email, ? AS type FROM staffs WHERE lower(position) = ?`,
+				"staff", "admin").Scan(&admins).Error; err != nil {
+				return err
+			}
+			return addRecipients(tx, alert.ID, admins, true)
+		})
+		if err != nil {
+			return err
+		}
+		if !escalated {
+			continue
+		}
+
+		name := alert.StudentID.String()
+		if alert.Student != nil {
+			name = alert.Student.FirstName + " " + alert.Student.LastName
+		}
+		log.Printf("Missing-child alert %s escalated", alert.ID)
+		s.notify(ctx, admins, "Escalated missing child alert: "+name,
+			fmt.Sprintf("Nobody has acknowledged the missing child alert for %s (grade %s) raised at %s. Please follow up.",
+				name, alert.Grade, alert.CreatedAt.Format("15:04")))
+	}
+	return nil
+}
+
+// resolveFound resolves the alerts of students whose attendance has moved on
+// from leaving home, whatever the new state, and tells the recipients.
+func (s *Scheduler) resolveFound(ctx context.Context, now time.Time) error {
+	var alerts []models.MissingChildAlert
+	err := s.db.Preload("Student").
+		Joins("JOIN attendance_days ad ON ad.student_id = missing_child_alerts.student_id AND ad.date = missing_child_alerts.date").
+		Where("missing_child_alerts.status <> ? AND ad.state <> ?", models.AlertResolved, models.AttendanceLeftHome).
+		Find(&alerts).Error
+	if err != nil {
+		return err
+	}
+
+	for _, alert := range alerts {
+		result := s.db.Model(&models.MissingChildAlert{}).
+			Where("id = ? AND status <> ?", alert.ID, models.AlertResolved).
+			Updates(map[string]interface{}{"status": models.AlertResolved, "resolved_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		var recipients []contact
+		if err := s.db.Raw(`SELECT r.recipient_id AS id, COALESCE(p.email, st.email) AS email, r.recipient_type AS type
+			FROM alert_recipients r
+			LEFT JOIN parents p ON r.recipient_type = 'parent' AND p.id = r.recipient_id
+			LEFT JOIN staffs st ON r.recipient_type = 'staff' AND st.id = r.recipient_id
+			WHERE r.alert_id = ?`, alert.ID).Scan(&recipients).Error; err != nil {
+			return err
+		}
+		name := alert.StudentID.String()
+		if alert.Student != nil {
+			name = alert.Student.FirstName + " " + alert.Student.LastName
+		}
+		var state string
+		if err := s.db.Model(&models.AttendanceDay{}).Where("student_id = ? AND date = ?", alert.StudentID, alert.Date).
+			Select("state").Scan(&state).Error; err != nil {
+			return err
+		}
+		if state == models.AttendanceArrivedHome {
+			s.notify(ctx, recipients, "Resolved: "+name+" is back home",
+				fmt.Sprintf("%s has been confirmed back home. The missing child alert is resolved.", name))
+		} else {
+			s.notify(ctx, recipients, "Resolved: "+name+" has arrived",
+				fmt.Sprintf("%s has now been confirmed at school. The missing child alert is resolved.", name))
+		}
+	}
+	return nil
+}
+
+// addRecipients records who an alert was sent to.
+func addRecipients(tx *gorm.DB, alertID uuid.UUID, contacts []contact, escalation bool) error {
+	if len(contacts) == 0 {
+		return nil
+	}
+	recipients := make([]models.AlertRecipient, len(contacts))
+	for i, c := range contacts {
+		recipients[i] = models.AlertRecipient{AlertID: alertID, RecipientID: c.ID, RecipientType: c.Type, Escalation: escalation}
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&recipients).Error
+}
+
+// notify mails every contact; failures are logged so one bad address does not
+// stop the others.
+func (s *Scheduler) notify(ctx context.Context, contacts []contact, subject, body string) {
+	for _, c := range contacts {
+		if c.Email == "" {
+			continue
+		}
+		if err := s.mail.Send(ctx, mailer.Message{To: c.Email, Subject: subject, Body: body}); err != nil {
+			log.Printf("Error mailing alert to %s %s: %v", c.Type, c.ID, err)
+		}
+	}
+}
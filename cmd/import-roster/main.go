@@ -0,0 +1,62 @@
+// Command import-roster imports a roster CSV of students and their parents,
+// like POST /import/roster. It prints the import report as JSON and only
+// writes to the database with -apply.
+//
+//	import-roster [-apply] roster.csv
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/mineracail/guardApi/database"
+	"github.com/mineracail/guardApi/resolvers"
+)
+
+func main() {
+	apply := flag.Bool("apply", false, "write the changes instead of doing a dry run")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: import-roster [-apply] roster.csv|-")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var in io.Reader = os.Stdin
+	if path := flag.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatal("Error opening roster:", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	rows, rowErrors, err := resolvers.ParseRoster(in)
+	if err != nil {
+		log.Fatal("Error reading roster:", err)
+	}
+
+	db := database.ConnectDB()
+	database.AutoMigrate(db)
+	report, err := resolvers.ImportRoster(db, rows, rowErrors, *apply)
+	if err != nil {
+		log.Fatal("Error importing roster:", err)
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	if err := out.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
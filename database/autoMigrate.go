@@ -17,10 +17,42 @@ func AutoMigrate(db *gorm.DB) {
 		&models.HomeArrival{},
 		&models.SchoolArrival{},
 		&models.Parent{},
+		&models.Conversation{},
 		&models.Message{},
+		&models.Attachment{},
+		&models.User{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.OneTimeToken{},
+		&models.AuditEvent{},
+		&models.LoginThrottle{},
+		&models.RecoveryCode{},
+		&models.Guardianship{},
+		&models.AuthorizedPickup{},
+		&models.PickupCode{},
+		&models.Dismissal{},
+		&models.AttendanceDay{},
+		&models.AttendanceEvent{},
+		&models.GradeCutoff{},
+		&models.MissingChildAlert{},
+		&models.AlertRecipient{},
+		&models.Geofence{},
+		&models.DeviceToken{},
+		&models.NotificationPreference{},
+		&models.Broadcast{},
+		&models.BroadcastRecipient{},
+		&models.MessageTemplate{},
+		&models.ScheduledMessage{},
 	)
 	if err != nil {
 		log.Fatal("Error migrating schema:", err)
 	}
 
+	// Move legacy credentials from staffs and parents to users
+	MigrateUsers(db)
+	// Move the parents' supervise arrays to guardianships
+	MigrateGuardianships(db)
+	// Group messages sent before conversations existed into them
+	MigrateConversations(db)
+
 }
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"log"
+
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// MigrateConversations puts messages that have no conversation into the
+// conversation of their sender and receiver, creating it when needed, and
+// stamps read messages with a read time. It only runs while such messages
+// exist.
+func MigrateConversations(db *gorm.DB) {
+	var pending int64
+	if err := db.Unscoped().Model(&models.Message{}).Where("conversation_id IS NULL").Count(&pending).Error; err != nil {
+		log.Fatal("Error counting messages without conversation:", err)
+	}
+	if pending == 0 {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO conversations (id, participant_a, participant_b, last_message_at, created_at, updated_at)
+			SELECT uuid_generate_v4(), pair.a, pair.b, pair.last_at, pair.first_at, now()
+			FROM (
+				SELECT LEAST(sender_id, receiver_id) AS a, GREATEST(sender_id, receiver_id) AS b,
+					min(created_at) AS first_at, max(created_at) AS last_at
+				FROM messages WHERE conversation_id IS NULL
+				GROUP BY 1, 2
+			) pair
+			ON CONFLICT (participant_a, participant_b)
+			DO UPDATE SET last_message_at = GREATEST(conversations.last_message_at, EXCLUDED.last_message_at)`).Error; err != nil {
+			return err
+		}
+
+		result := tx.Exec(`UPDATE messages m SET conversation_id = c.id,
+				read_at = CASE WHEN m.status = ? THEN COALESCE(m.read_at, m.updated_at) END
+			FROM conversations c
+			WHERE m.conversation_id IS NULL
+				AND c.participant_a = LEAST(m.sender_id, m.receiver_id)
+				AND c.participant_b = GREATEST(m.sender_id, m.receiver_id)`, models.MessageRead)
+		if result.Error != nil {
+			return result.Error
+		}
+		log.Printf("Moved %d messages into conversations", result.RowsAffected)
+
+		// Messages marked deleted by hand become soft deleted, and anything
+		// else that is not read is unread now that status is no longer free text
+		if err := tx.Exec(`UPDATE messages SET deleted_at = now() WHERE status = 'deleted' AND deleted_at IS NULL`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`UPDATE messages SET status = ? WHERE status IS DISTINCT FROM ? AND read_at IS NULL`,
+			models.MessageUnread, models.MessageUnread).Error
+	})
+	if err != nil {
+		log.Fatal("Error migrating conversations:", err)
+	}
+}
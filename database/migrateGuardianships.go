@@ -0,0 +1,47 @@
+package database
+
+import (
+	"log"
+
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// MigrateGuardianships turns the student IDs in the legacy parents.supervise
+// array into guardianships, then drops the column. IDs that do not belong to
+// a student are logged and skipped. It only runs while the column exists.
+func MigrateGuardianships(db *gorm.DB) {
+	if !db.Migrator().HasColumn(&models.Parent{}, "supervise") {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var dangling int64
+		if err := tx.Raw(`SELECT count(*) FROM parents p CROSS JOIN LATERAL unnest(p.supervise) AS sid
+			WHERE NOT EXISTS (SELECT 1 FROM students s WHERE s.id::text = sid)`).Scan(&dangling).Error; err != nil {
+			return err
+		}
+		if dangling > 0 {
+			log.Printf("Skipping %d supervised student IDs that match no student", dangling)
+		}
+
+		// Existing links keep the access they had: custody and pickup
+		result := tx.Exec(`INSERT INTO guardianships (id, parent_id, student_id, relation, has_custody, can_pickup, created_at, updated_at)
+			SELECT uuid_generate_v4(), pair.parent_id, pair.student_id, ?, true, true, now(), now()
+			FROM (
+				SELECT DISTINCT p.id AS parent_id, s.id AS student_id
+				FROM parents p CROSS JOIN LATERAL unnest(p.supervise) AS sid
+				JOIN students s ON s.id::text = sid
+			) pair
+			ON CONFLICT (parent_id, student_id) DO NOTHING`, models.RelationGuardian)
+		if result.Error != nil {
+			return result.Error
+		}
+		log.Printf("Migrated %d supervised students to guardianships", result.RowsAffected)
+
+		return tx.Migrator().DropColumn(&models.Parent{}, "supervise")
+	})
+	if err != nil {
+		log.Fatal("Error migrating guardianships:", err)
+	}
+}
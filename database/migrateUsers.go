@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// legacyCredential is a password row read from the staffs or parents table
+// before credentials moved to users.
+type legacyCredential struct {
+	ID       uuid.UUID
+	Email    string
+	Password string
+}
+
+// MigrateUsers moves the login credentials stored on staffs and parents into
+// the users table, linking a staff and a parent profile that share an email
+// to one user, then drops the old password columns. It only runs while those
+// columns still exist.
+func MigrateUsers(db *gorm.DB) {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(&models.Staff{}, "password") && !migrator.HasColumn(&models.Parent{}, "password") {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Staff first, so a staff password wins when the same email has two
+		for _, table := range []string{"staffs", "parents"} {
+			if !tx.Migrator().HasColumn(table, "password") {
+				continue
+			}
+
+			var rows []legacyCredential
+			if err := tx.Table(table).Select("id, email, password").Order("created_at").Scan(&rows).Error; err != nil {
+				return err
+			}
+			for _, row := range rows {
+				if err := linkLegacyCredential(tx, table, row); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Point outstanding tokens at users instead of profiles
+		if err := tx.Exec(`UPDATE refresh_tokens t SET profile_id = t.user_id, user_id = u.id::text
+			FROM users u
+			WHERE (t.profile_id IS NULL OR t.profile_id = '')
+			AND (u.staff_id::text = t.user_id OR u.parent_id::text = t.user_id)`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`UPDATE one_time_tokens t SET user_id = u.id::text
+			FROM users u
+			WHERE (t.user_type = 'staff' AND u.staff_id::text = t.user_id)
+			OR (t.user_type = 'parent' AND u.parent_id::text = t.user_id)`).Error; err != nil {
+			return err
+		}
+
+		for _, table := range []string{"staffs", "parents"} {
+			if tx.Migrator().HasColumn(table, "password") {
+				if err := tx.Migrator().DropColumn(table, "password"); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal("Error migrating credentials to users:", err)
+	}
+	log.Println("Migrated staff and parent credentials to users")
+}
+
+// linkLegacyCredential links one staff or parent row to the user owning its email.
+func linkLegacyCredential(tx *gorm.DB, table string, row legacyCredential) error {
+	email := models.NormalizeEmail(row.Email)
+	if email == "" {
+		log.Printf("Skipping %s %s without an email", table, row.ID)
+		return nil
+	}
+
+	var user models.User
+	err := tx.Where("lower(email) = ?", email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		user = models.User{Email: email, Password: row.Password}
+	} else if err != nil {
+		return err
+	} else if user.Password == "" {
+		user.Password = row.Password
+	} else if row.Password != "" && row.Password != user.Password {
+		log.Printf("Email %s has different passwords in staffs and parents, keeping the staff password", email)
+	}
+
+	id := row.ID
+	if table == "staffs" {
+		if user.StaffID != nil {
+			log.Printf("Email %s belongs to several staff rows, leaving staff %s without a login", email, row.ID)
+			return nil
+		}
+		user.StaffID = &id
+	} else {
+		if user.ParentID != nil {
+			log.Printf("Email %s belongs to several parent rows, leaving parent %s without a login", email, row.ID)
+			return nil
+		}
+		user.ParentID = &id
+	}
+	return tx.Save(&user).Error
+}
@@ -0,0 +1,158 @@
+// Package events is an in-process publish/subscribe hub for arrival events.
+// It keeps a bounded history so a client that reconnects with the ID of the
+// last event it saw receives what it missed.
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	HomeArrivalCreated     = "home_arrival.created"
+	HomeArrivalConfirmed   = "home_arrival.confirmed"
+	SchoolArrivalCreated   = "school_arrival.created"
+	SchoolArrivalConfirmed = "school_arrival.confirmed"
+)
+
+const (
+	// DefaultHistorySize is how many events the default hub keeps for resume.
+	DefaultHistorySize = 1000
+	// subscriberBuffer is how many events may be queued for one subscriber
+	// before it is dropped as too slow.
+	subscriberBuffer = 64
+)
+
+// Default is the hub the API publishes to.
+var Default = NewHub(DefaultHistorySize)
+
+// Event is something that happened to a student.
+type Event struct {
+	ID        string      `json:"id"` // "<hub epoch>-<sequence>"
+	Type      string      `json:"type"`
+	StudentID string      `json:"studentId"`
+	Grade     string      `json:"grade"`
+	Time      time.Time   `json:"time"`
+	Data      interface{} `json:"data"`
+	seq       uint64
+}
+
+// Hub fans events out to subscribers. Event IDs include the hub's start time,
+// so IDs handed out before a restart are recognised as stale.
+type Hub struct {
+	mu          sync.Mutex
+	epoch       string
+	seq         uint64
+	history     []Event
+	historySize int
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the events matching its filter until it is closed.
+// The hub closes the channel when the subscriber falls too far behind.
+type Subscription struct {
+	hub    *Hub
+	filter func(Event) bool
+	events chan Event
+}
+
+// NewHub returns a hub keeping the last historySize events.
+func NewHub(historySize int) *Hub {
+	return &Hub{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		historySize: historySize,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns the event its ID and delivers it to every matching
+// subscriber. It never blocks: subscribers whose queue is full are dropped
+// and resume on reconnect.
+func (h *Hub) Publish(e Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e.seq = h.seq
+	e.ID = fmt.Sprintf("%s-%d", h.epoch, h.seq)
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.history = append(h.history, e)
+	if len(h.history) > h.historySize {
+		h.history = h.history[len(h.history)-h.historySize:]
+	}
+
+	for sub := range h.subscribers {
+		if !sub.filter(e) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			h.remove(sub)
+		}
+	}
+	return e
+}
+
+// Subscribe registers a subscriber for the events matching filter. When
+// lastEventID is set, the matching events published after it are returned for
+// replay; resumed is false when some of them are no longer in the history, or
+// the ID is from before a restart, and the client should reload its state.
+func (h *Hub) Subscribe(filter func(Event) bool, lastEventID string) (sub *Subscription, replay []Event, resumed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub = &Subscription{hub: h, filter: filter, events: make(chan Event, subscriberBuffer)}
+	h.subscribers[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+	seq, ok := h.parseID(lastEventID)
+	if !ok || seq > h.seq {
+		return sub, nil, false
+	}
+	resumed = len(h.history) == 0 || h.history[0].seq <= seq+1
+	for _, e := range h.history {
+		if e.seq > seq && filter(e) {
+			replay = append(replay, e)
+		}
+	}
+	return sub, replay, resumed
+}
+
+// Events returns the channel the subscription's events are delivered on.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close unregisters the subscription.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// remove unregisters sub and closes its channel. h.mu must be held.
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// parseID returns the sequence number of an event ID from this hub.
+func (h *Hub) parseID(id string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != h.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,76 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message is an outbound email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers outbound email. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogMailer writes every message to the application log instead of sending it.
+type LogMailer struct {
+	From string
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("Mail from %s to %s: %s\n%s", m.From, msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer writes every message as an .eml file into Dir, for inspecting
+// mail during local development.
+type FileMailer struct {
+	From string
+	Dir  string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102T150405"), uuid.New().String())
+	content := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n",
+		m.From, msg.To, msg.Subject, now.Format(time.RFC1123Z), msg.Body)
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(content), 0o644)
+}
+
+// FromEnv builds the mailer selected by MAILER ("log", the default, or
+// "file"). MAILER_FROM sets the sender and MAILER_DIR the file mailer's
+// output directory.
+func FromEnv() (Mailer, error) {
+	from := os.Getenv("MAILER_FROM")
+	if from == "" {
+		from = "no-reply@guardapi.local"
+	}
+
+	switch kind := os.Getenv("MAILER"); kind {
+	case "", "log":
+		return &LogMailer{From: from}, nil
+	case "file":
+		dir := os.Getenv("MAILER_DIR")
+		if dir == "" {
+			dir = "tmp/mail"
+		}
+		return &FileMailer{From: from, Dir: dir}, nil
+	default:
+		return nil, fmt.Errorf("unsupported MAILER %q", kind)
+	}
+}
@@ -1,32 +1,114 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/alerts"
 	"github.com/mineracail/guardApi/database"
+	"github.com/mineracail/guardApi/mailer"
+	"github.com/mineracail/guardApi/messaging"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/notify"
+	"github.com/mineracail/guardApi/storage"
 
 	"github.com/mineracail/guardApi/router"
 )
 
 func main() {
 	r := chi.NewRouter()
-	// Apply the middleware to all routes
-	//r.Use(middleware.Middleware)
-	
 
 	db := database.ConnectDB()
 	// Migrate the schema	
 	database.AutoMigrate(db)
 
-	// Define routes for CRUD operations
-	router.StudentRoute(db,r)
-	router.StaffRoute(db,r)
-	router.CalendarRoute(db,r)	
-	router.ParentRoute(db,r)	
-	router.LocationRoute(db,r)	
-	router.MessageRoute(db,r)	
+	// Days and weeks follow the school's time zone
+	if err := helpers.LoadSchoolLocation(); err != nil {
+		log.Fatal("Error loading school time zone:", err)
+	}
+
+	// Load the token signing keys
+	keys, err := middleware.LoadKeyRing()
+	if err != nil {
+		log.Fatal("Error loading JWT signing keys:", err)
+	}
+	middleware.Keys = keys
+
+	// Revoked tokens are checked on every authenticated request
+	middleware.Revocations = middleware.NewRevocationList(db)
+	middleware.Revocations.Start(context.Background())
+
+	// Outbound mail for invitations and password resets
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal("Error configuring mailer:", err)
+	}
+
+	// Alert guardians and staff about children who never reached school
+	scheduler, err := alerts.NewScheduler(db, mail)
+	if err != nil {
+		log.Fatal("Error configuring alert scheduler:", err)
+	}
+	scheduler.Start(context.Background())
+
+	// Push notifications to parents' and staff's phones
+	notifier, err := notify.FromEnv()
+	if err != nil {
+		log.Fatal("Error configuring notifier:", err)
+	}
+	notify.Default = notify.NewDispatcher(db, notifier)
+	notify.Default.Start(context.Background())
+
+	// Send scheduled messages and deliver broadcasts in batches
+	messaging.Default = messaging.NewWorker(db)
+	messaging.Default.Start(context.Background())
+
+	// Message attachments and their download links
+	blobs, err := storage.FromEnv()
+	if err != nil {
+		log.Fatal("Error configuring blob store:", err)
+	}
+	storage.Blobs = blobs
+	links, err := storage.SignerFromEnv()
+	if err != nil {
+		log.Fatal("Error configuring download links:", err)
+	}
+	storage.Links = links
+
+	// Public routes
+	router.AuthRoute(db, mail, r)
+	router.DownloadRoute(db, r)
+
+	// Every other route requires a valid token
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Middleware)
+
+		router.SessionRoute(db, r)
+
+		// Define routes for CRUD operations
+		router.StudentRoute(db, r)
+		router.StaffRoute(db, r)
+		router.CalendarRoute(db, r)
+		router.ParentRoute(db, r)
+		router.LocationRoute(db, r)
+		router.PickupRoute(db, r)
+		router.AlertRoute(db, r)
+		router.EventRoute(db, r)
+		router.NotificationRoute(db, r)
+		router.ReportRoute(db, r)
+		router.ExportRoute(db, r)
+		router.ImportRoute(db, r)
+		router.MessageRoute(db, r)
+		router.BroadcastRoute(db, r)
+		router.AttachmentRoute(db, r)
+		router.TemplateRoute(db, r)
+		router.ScheduledMessageRoute(db, r)
+		router.InvitationRoute(db, mail, r)
+		router.LockoutRoute(db, r)
+	})
 	
 	log.Println("Starting server on http://localhost:8080")
 	http.ListenAndServe(":8080", r)
@@ -0,0 +1,54 @@
+package messaging
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// insertBatch bounds the recipient rows inserted per statement.
+const insertBatch = 500
+
+// ErrNoRecipients is returned for broadcasts whose audience resolves to
+// nobody.
+var ErrNoRecipients = errors.New("the audience has no recipients")
+
+// CreateBroadcast resolves the audience of broadcast and stores it with one
+// pending row per recipient for the worker to deliver. Placeholders in its
+// content are rendered for each recipient, with date filling {{date}}.
+func CreateBroadcast(tx *gorm.DB, broadcast *models.Broadcast, date time.Time) error {
+	recipients, err := ResolveAudience(tx, broadcast)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return ErrNoRecipients
+	}
+
+	var values map[uuid.UUID]TemplateValues
+	if HasPlaceholders(broadcast.Content) {
+		if values, err = recipientValues(tx, broadcast, recipients, date); err != nil {
+			return err
+		}
+	}
+
+	broadcast.Status = models.DeliveryPending
+	broadcast.RecipientCount = len(recipients)
+	if err := tx.Create(broadcast).Error; err != nil {
+		return err
+	}
+	rows := make([]models.BroadcastRecipient, len(recipients))
+	for i, id := range recipients {
+		rows[i] = models.BroadcastRecipient{BroadcastID: broadcast.ID, RecipientID: id, Status: models.DeliveryPending}
+		if values != nil {
+			// Only content that differs from the broadcast's is stored
+			if content := Render(broadcast.Content, values[id]); content != broadcast.Content {
+				rows[i].Content = content
+			}
+		}
+	}
+	return tx.CreateInBatches(rows, insertBatch).Error
+}
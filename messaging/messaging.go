@@ -0,0 +1,97 @@
+// Package messaging stores messages in their conversations, delivers
+// broadcasts to the audiences they were addressed to and sends scheduled
+// messages when they are due, filling in template placeholders.
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidAudience is returned, wrapped, for audiences missing what they
+// are resolved from.
+var ErrInvalidAudience = errors.New("invalid audience")
+
+// Send stores a message from sender to receiver in their conversation,
+// creating the conversation for their first message and moving it to the top
+// of both inboxes.
+func Send(tx *gorm.DB, senderID, receiverID uuid.UUID, content string) (*models.Message, error) {
+	now := time.Now()
+	conversation := models.NewConversation(senderID, receiverID)
+	conversation.LastMessageAt = now
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "participant_a"}, {Name: "participant_b"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_message_at", "updated_at"}),
+	}).Create(&conversation).Error
+	if err != nil {
+		return nil, err
+	}
+	// The ID generated above is not the stored one when the conversation existed
+	err = tx.Where("participant_a = ? AND participant_b = ?", conversation.ParticipantA, conversation.ParticipantB).
+		First(&conversation).Error
+	if err != nil {
+		return nil, err
+	}
+
+	message := models.Message{
+		ConversationID: &conversation.ID,
+		Content:        content,
+		SenderID:       senderID,
+		ReceiverID:     receiverID,
+		Status:         models.MessageUnread,
+		CreatedAt:      now,
+	}
+	if err := tx.Create(&message).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// ResolveAudience returns the staff or parent profiles the broadcast is
+// addressed to, leaving out its sender.
+func ResolveAudience(db *gorm.DB, broadcast *models.Broadcast) ([]uuid.UUID, error) {
+	var recipients []uuid.UUID
+	var err error
+	switch broadcast.Audience {
+	case models.AudienceGradeParents:
+		if broadcast.Grade == "" {
+			return nil, fmt.Errorf("%w: grade is required", ErrInvalidAudience)
+		}
+		err = db.Model(&models.Guardianship{}).Distinct("guardianships.parent_id").
+			Joins("JOIN students ON students.id = guardianships.student_id").
+			Where("students.grade = ?", broadcast.Grade).
+			Pluck("guardianships.parent_id", &recipients).Error
+	case models.AudienceStaffPosition:
+		if broadcast.Position == "" {
+			return nil, fmt.Errorf("%w: position is required", ErrInvalidAudience)
+		}
+		err = db.Model(&models.Staff{}).Where("lower(position) = lower(?)", broadcast.Position).
+			Pluck("id", &recipients).Error
+	case models.AudienceStudentGuardians:
+		if len(broadcast.StudentIDs) == 0 {
+			return nil, fmt.Errorf("%w: student_ids is required", ErrInvalidAudience)
+		}
+		err = db.Model(&models.Guardianship{}).Distinct("parent_id").Where("student_id IN ?", broadcast.StudentIDs).
+			Pluck("parent_id", &recipients).Error
+	default:
+		return nil, fmt.Errorf("%w: audience must be %s, %s or %s", ErrInvalidAudience,
+			models.AudienceGradeParents, models.AudienceStaffPosition, models.AudienceStudentGuardians)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	audience := recipients[:0]
+	for _, id := range recipients {
+		if id != broadcast.SenderID {
+			audience = append(audience, id)
+		}
+	}
+	return audience, nil
+}
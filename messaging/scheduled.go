@@ -0,0 +1,136 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"github.com/mineracail/guardApi/notify"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// scheduledBatch bounds the scheduled messages sent per transaction.
+const scheduledBatch = 50
+
+// ScheduledDate returns the day {{date}} stands for in a scheduled message:
+// its Date, or the day it is sent.
+func ScheduledDate(scheduled *models.ScheduledMessage) (time.Time, error) {
+	if scheduled.Date == "" {
+		return helpers.DayRange(scheduled.SendAt).From, nil
+	}
+	return helpers.ParseDate(scheduled.Date)
+}
+
+// dispatchDue sends up to scheduledBatch scheduled messages that are due at
+// now, returning how many it claimed. A scheduled message is marked sent in
+// the transaction that sends it, so it goes out exactly once even when the
+// server stops halfway or several workers run. A failed send is retried with
+// the same backoff as broadcast deliveries.
+func (w *Worker) dispatchDue(now time.Time) (int, error) {
+	var due []models.ScheduledMessage
+	var sent []*models.Message
+
+	err := w.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND send_at <= ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)",
+				models.ScheduledPending, now, now).
+			Order("send_at, id").Limit(scheduledBatch).Find(&due).Error
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		for i := range due {
+			scheduled := &due[i]
+			// A failed send only undoes its own scheduled message
+			if err := tx.SavePoint("scheduled").Error; err != nil {
+				return err
+			}
+			message, broadcast, err := sendScheduled(tx, scheduled)
+			updates := map[string]interface{}{"attempts": scheduled.Attempts + 1}
+			if err != nil {
+				if err := tx.RollbackTo("scheduled").Error; err != nil {
+					return err
+				}
+				updates["last_error"] = err.Error()
+				if permanentFailure(err) || scheduled.Attempts+1 >= maxDeliveryAttempts {
+					updates["status"] = models.ScheduledFailed
+				} else {
+					updates["next_attempt_at"] = now.Add(retryDelay(scheduled.Attempts + 1))
+				}
+			} else {
+				updates["status"] = models.ScheduledSent
+				updates["sent_at"] = now
+				updates["last_error"] = ""
+				if message != nil {
+					updates["message_id"] = message.ID
+					sent = append(sent, message)
+				}
+				if broadcast != nil {
+					updates["broadcast_id"] = broadcast.ID
+				}
+			}
+			err = tx.Model(&models.ScheduledMessage{}).
+				Where("id = ? AND status = ?", scheduled.ID, models.ScheduledPending).
+				Updates(updates).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, message := range sent {
+		notify.Default.NotifyProfiles([]uuid.UUID{message.ReceiverID}, notify.CategoryMessages, "New message",
+			notify.Preview(message.Content), map[string]string{"type": "message", "messageId": message.ID.String()})
+	}
+	return len(due), nil
+}
+
+// sendScheduled sends a scheduled message, or stores its broadcast for
+// delivery, with its placeholders rendered.
+func sendScheduled(tx *gorm.DB, scheduled *models.ScheduledMessage) (*models.Message, *models.Broadcast, error) {
+	date, err := ScheduledDate(scheduled)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch scheduled.Kind {
+	case models.ScheduledDirect:
+		if scheduled.ReceiverID == nil {
+			return nil, nil, errors.New("scheduled message has no receiver")
+		}
+		values, err := StudentValues(tx, scheduled.StudentID, date)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading student: %w", err)
+		}
+		message, err := Send(tx, scheduled.SenderID, *scheduled.ReceiverID, Render(scheduled.Content, values))
+		return message, nil, err
+	case models.ScheduledBroadcast:
+		broadcast := &models.Broadcast{
+			SenderID:   scheduled.SenderID,
+			Content:    scheduled.Content,
+			TemplateID: scheduled.TemplateID,
+			Audience:   scheduled.Audience,
+			Grade:      scheduled.Grade,
+			Position:   scheduled.Position,
+			StudentIDs: scheduled.StudentIDs,
+		}
+		if err := CreateBroadcast(tx, broadcast, date); err != nil {
+			return nil, nil, err
+		}
+		return nil, broadcast, nil
+	}
+	return nil, nil, fmt.Errorf("unknown scheduled message kind %q", scheduled.Kind)
+}
+
+// permanentFailure reports whether retrying a failed send cannot help.
+func permanentFailure(err error) bool {
+	return errors.Is(err, ErrNoRecipients) || errors.Is(err, ErrInvalidAudience) || errors.Is(err, gorm.ErrRecordNotFound)
+}
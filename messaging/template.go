@@ -0,0 +1,161 @@
+package messaging
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// Placeholders templates may use, written as {{name}}.
+const (
+	PlaceholderStudentFirstName = "student_first_name"
+	PlaceholderGrade            = "grade"
+	PlaceholderDate             = "date"
+)
+
+// templateDateLayout is how {{date}} is written in messages.
+const templateDateLayout = "Monday, 2 January 2006"
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_]+)\s*\}\}`)
+
+// TemplateValues are what placeholders are replaced with for one recipient.
+type TemplateValues struct {
+	StudentFirstName string
+	Grade            string
+	Date             time.Time
+}
+
+// ValidateTemplate returns an error naming the first placeholder in body
+// that is not known.
+func ValidateTemplate(body string) error {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(body, -1) {
+		switch strings.ToLower(match[1]) {
+		case PlaceholderStudentFirstName, PlaceholderGrade, PlaceholderDate:
+		default:
+			return fmt.Errorf("unknown placeholder %s, use {{%s}}, {{%s}} or {{%s}}", match[0],
+				PlaceholderStudentFirstName, PlaceholderGrade, PlaceholderDate)
+		}
+	}
+	return nil
+}
+
+// HasPlaceholders reports whether body has anything to render.
+func HasPlaceholders(body string) bool {
+	return placeholderPattern.MatchString(body)
+}
+
+// UsesStudentPlaceholders reports whether body needs a student to render:
+// {{student_first_name}} or {{grade}}.
+func UsesStudentPlaceholders(body string) bool {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(body, -1) {
+		switch strings.ToLower(match[1]) {
+		case PlaceholderStudentFirstName, PlaceholderGrade:
+			return true
+		}
+	}
+	return false
+}
+
+// Render replaces the placeholders in body with values.
+func Render(body string, values TemplateValues) string {
+	return placeholderPattern.ReplaceAllStringFunc(body, func(placeholder string) string {
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		switch strings.ToLower(name) {
+		case PlaceholderStudentFirstName:
+			return values.StudentFirstName
+		case PlaceholderGrade:
+			return values.Grade
+		case PlaceholderDate:
+			return values.Date.In(helpers.SchoolLocation).Format(templateDateLayout)
+		}
+		return placeholder
+	})
+}
+
+// StudentValues returns the values for a message about one student, or only
+// the date when studentID is nil.
+func StudentValues(db *gorm.DB, studentID *uuid.UUID, date time.Time) (TemplateValues, error) {
+	values := TemplateValues{Date: date}
+	if studentID == nil {
+		return values, nil
+	}
+	var student models.Student
+	if err := db.Where("id = ?", *studentID).First(&student).Error; err != nil {
+		return values, err
+	}
+	values.StudentFirstName, values.Grade = student.FirstName, student.Grade
+	return values, nil
+}
+
+// recipientValues returns the values for each recipient of a broadcast:
+// guardians get the first names of their children in the audience, staff
+// only the broadcast's grade.
+func recipientValues(db *gorm.DB, broadcast *models.Broadcast, recipients []uuid.UUID, date time.Time) (map[uuid.UUID]TemplateValues, error) {
+	values := make(map[uuid.UUID]TemplateValues, len(recipients))
+	for _, id := range recipients {
+		values[id] = TemplateValues{Grade: broadcast.Grade, Date: date}
+	}
+
+	var children []struct {
+		ParentID  uuid.UUID
+		FirstName string
+		Grade     string
+	}
+	query := db.Model(&models.Guardianship{}).
+		Select("guardianships.parent_id, students.first_name, students.grade").
+		Joins("JOIN students ON students.id = guardianships.student_id").
+		Where("guardianships.parent_id IN ?", recipients).
+		Order("students.first_name")
+	switch broadcast.Audience {
+	case models.AudienceGradeParents:
+		query = query.Where("students.grade = ?", broadcast.Grade)
+	case models.AudienceStudentGuardians:
+		query = query.Where("students.id IN ?", broadcast.StudentIDs)
+	default:
+		return values, nil
+	}
+	if err := query.Scan(&children).Error; err != nil {
+		return nil, err
+	}
+
+	names := map[uuid.UUID][]string{}
+	grades := map[uuid.UUID]map[string]bool{}
+	for _, child := range children {
+		names[child.ParentID] = append(names[child.ParentID], child.FirstName)
+		if grades[child.ParentID] == nil {
+			grades[child.ParentID] = map[string]bool{}
+		}
+		grades[child.ParentID][child.Grade] = true
+	}
+	for id, childNames := range names {
+		v := values[id]
+		v.StudentFirstName = joinNames(childNames)
+		v.Grade = joinNames(sortedKeys(grades[id]))
+		values[id] = v
+	}
+	return values, nil
+}
+
+// joinNames lists names as "A", "A and B" or "A, B and C".
+func joinNames(names []string) string {
+	if len(names) <= 1 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
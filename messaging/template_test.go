@@ -0,0 +1,21 @@
+package messaging
+
+import "testing"
+
+func TestUsesStudentPlaceholders(t *testing.T) {
+	cases := []struct {
+		body string
+		want bool
+	}{
+		{"No school on {{date}}", false},
+		{"Plain text", false},
+		{"{{student_first_name}} left early", true},
+		{"Grade {{ GRADE }} trip", true},
+		{"{{unknown}}", false},
+	}
+	for _, c := range cases {
+		if got := UsesStudentPlaceholders(c.body); got != c.want {
+			t.Errorf("UsesStudentPlaceholders(%q) = %v, want %v", c.body, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package messaging
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/models"
+	"github.com/mineracail/guardApi/notify"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultBatchSize is how many recipients are delivered per transaction.
+	DefaultBatchSize = 200
+	// maxDeliveryAttempts is how often a recipient is tried before the
+	// delivery is marked failed.
+	maxDeliveryAttempts = 3
+	// retryBaseDelay is the wait before the first retry; it doubles with
+	// every further attempt.
+	retryBaseDelay = time.Minute
+	// pollInterval is how often the worker looks for scheduled messages that
+	// became due and pending recipients it was not woken for, such as those
+	// left by a restart.
+	pollInterval = 15 * time.Second
+)
+
+// Default is the worker new broadcasts wake. It is nil until main sets it;
+// broadcasts are then delivered on the next poll of any running worker.
+var Default *Worker
+
+// Worker sends scheduled messages when they are due and delivers broadcasts
+// in batches in the background. Several instances may run at once: work is
+// claimed with SKIP LOCKED, so everything is sent by one of them.
+type Worker struct {
+	db        *gorm.DB
+	batchSize int
+	wake      chan struct{}
+}
+
+// NewWorker returns a worker delivering DefaultBatchSize recipients at a time.
+func NewWorker(db *gorm.DB) *Worker {
+	return &Worker{db: db, batchSize: DefaultBatchSize, wake: make(chan struct{}, 1)}
+}
+
+// Start runs the worker in the background until ctx is done.
+func (w *Worker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			if err := w.Run(ctx); err != nil {
+				log.Printf("Error delivering broadcasts: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-w.wake:
+			}
+		}
+	}()
+}
+
+// Wake makes the worker deliver pending broadcasts now instead of on its next
+// poll.
+func (w *Worker) Wake() {
+	if w == nil {
+		return
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends the scheduled messages that are due, delivers batches until no
+// recipient is due, then completes the broadcasts nobody is pending for.
+// Failed deliveries are retried by a later run, once their backoff is over.
+func (w *Worker) Run(ctx context.Context) error {
+	now := time.Now()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dispatched, err := w.dispatchDue(now)
+		if err != nil {
+			return err
+		}
+		if dispatched == 0 {
+			break
+		}
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		delivered, err := w.deliverBatch(now)
+		if err != nil {
+			return err
+		}
+		if delivered == 0 {
+			break
+		}
+	}
+	return w.complete()
+}
+
+// deliverBatch claims up to batchSize pending recipients due at now and
+// sends them their message, returning how many it claimed.
+func (w *Worker) deliverBatch(now time.Time) (int, error) {
+	// Recipients are notified together when they got the same content
+	type notification struct {
+		BroadcastID uuid.UUID
+		Content     string
+	}
+	var batch []models.BroadcastRecipient
+	sent := map[notification][]uuid.UUID{}
+	broadcasts := map[uuid.UUID]*models.Broadcast{}
+
+	err := w.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", models.DeliveryPending, now).
+			Order("broadcast_id").Limit(w.batchSize).Find(&batch).Error
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+
+		for i := range batch {
+			recipient := &batch[i]
+			broadcast, ok := broadcasts[recipient.BroadcastID]
+			if !ok {
+				broadcast = &models.Broadcast{}
+				if err := tx.Where("id = ?", recipient.BroadcastID).First(broadcast).Error; err != nil {
+					return err
+				}
+				broadcasts[broadcast.ID] = broadcast
+			}
+
+			// A failed send only undoes its own recipient
+			if err := tx.SavePoint("recipient").Error; err != nil {
+				return err
+			}
+			content := broadcast.Content
+			if recipient.Content != "" {
+				content = recipient.Content
+			}
+			message, err := Send(tx, broadcast.SenderID, recipient.RecipientID, content)
+			updates := map[string]interface{}{"attempts": recipient.Attempts + 1}
+			if err != nil {
+				if err := tx.RollbackTo("recipient").Error; err != nil {
+					return err
+				}
+				updates["last_error"] = err.Error()
+				if recipient.Attempts+1 >= maxDeliveryAttempts {
+					updates["status"] = models.DeliveryFailed
+				} else {
+					updates["next_attempt_at"] = now.Add(retryDelay(recipient.Attempts + 1))
+				}
+			} else {
+				updates["status"] = models.DeliverySent
+				updates["message_id"] = message.ID
+				updates["sent_at"] = message.CreatedAt
+				updates["last_error"] = ""
+				key := notification{BroadcastID: broadcast.ID, Content: content}
+				sent[key] = append(sent[key], recipient.RecipientID)
+			}
+			err = tx.Model(&models.BroadcastRecipient{}).
+				Where("broadcast_id = ? AND recipient_id = ?", recipient.BroadcastID, recipient.RecipientID).
+				Updates(updates).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for key, recipients := range sent {
+		notify.Default.NotifyProfiles(recipients, notify.CategoryMessages, "New message", notify.Preview(key.Content),
+			map[string]string{"type": "broadcast", "broadcastId": key.BroadcastID.String()})
+	}
+	return len(batch), nil
+}
+
+// complete finishes the broadcasts without pending recipients: they are
+// sent when every recipient got the message, failed when nobody did and
+// partial otherwise.
+func (w *Worker) complete() error {
+	return w.db.Exec(`UPDATE broadcasts b SET completed_at = now(), updated_at = now(), sent_count = c.sent,
+			status = CASE WHEN c.sent = 0 THEN @failed WHEN c.failed > 0 THEN @partial ELSE @sent END
+		FROM (
+			SELECT broadcast_id,
+				count(*) FILTER (WHERE status = @sent) AS sent,
+				count(*) FILTER (WHERE status = @failed) AS failed,
+				count(*) FILTER (WHERE status = @pending) AS pending
+			FROM broadcast_recipients
+			WHERE broadcast_id IN (SELECT id FROM broadcasts WHERE status = @pending)
+			GROUP BY broadcast_id
+		) c
+		WHERE c.broadcast_id = b.id AND b.status = @pending AND c.pending = 0`,
+		map[string]interface{}{
+			"pending": models.DeliveryPending,
+			"sent":    models.DeliverySent,
+			"partial": models.DeliveryPartial,
+			"failed":  models.DeliveryFailed,
+		}).Error
+}
+
+// retryDelay returns how long to wait before retrying a delivery that failed
+// attempts times.
+func retryDelay(attempts int) time.Duration {
+	return retryBaseDelay << (attempts - 1)
+}
@@ -0,0 +1,22 @@
+package messaging
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+	}
+	for _, c := range cases {
+		if got := retryDelay(c.attempts); got != c.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", c.attempts, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+// DateLayout is the YYYY-MM-DD layout of dates in URLs and stored day keys.
+const DateLayout = "2006-01-02"
+
+// maxDateRangeDays bounds ?from=&to= ranges so one request cannot scan years
+// of records.
+const maxDateRangeDays = 366
+
+// SchoolLocation is the school's time zone. Days and weeks start at midnight
+// in this zone, whatever the server's own zone is. It is set at startup by
+// LoadSchoolLocation.
+var SchoolLocation = time.UTC
+
+// LoadSchoolLocation sets SchoolLocation from SCHOOL_TIMEZONE, an IANA zone
+// name such as "Africa/Accra". When it is unset it falls back to TZ, then
+// UTC, so Go and the SQL in SchoolTimeZoneName always use the same zone.
+func LoadSchoolLocation() error {
+	name, variable := os.Getenv("SCHOOL_TIMEZONE"), "SCHOOL_TIMEZONE"
+	if name == "" {
+		name, variable = os.Getenv("TZ"), "TZ"
+	}
+	if name == "" {
+		SchoolLocation = time.UTC
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", variable, err)
+	}
+	if loc.String() == "Local" {
+		return fmt.Errorf("%s: %q is not an IANA time zone name", variable, name)
+	}
+	SchoolLocation = loc
+	return nil
+}
+
+// SchoolTimeZoneName returns the IANA name of SchoolLocation for use in SQL.
+func SchoolTimeZoneName() string {
+	return SchoolLocation.String()
+}
+
+// SchoolNow returns the current time in the school's time zone.
+func SchoolNow() time.Time {
+	return time.Now().In(SchoolLocation)
+}
+
+// Today returns the school's current date as YYYY-MM-DD.
+func Today() string {
+	return SchoolNow().Format(DateLayout)
+}
+
+// DateRange is the half-open interval [From, To) of instants covering whole
+// school days.
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// ParseDate parses a YYYY-MM-DD date as midnight in the school's time zone.
+func ParseDate(date string) (time.Time, error) {
+	return time.ParseInLocation(DateLayout, date, SchoolLocation)
+}
+
+// DayRange returns the range covering the school day containing t.
+func DayRange(t time.Time) DateRange {
+	t = t.In(SchoolLocation)
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, SchoolLocation)
+	return DateRange{From: start, To: start.AddDate(0, 0, 1)}
+}
+
+// WeekRange returns the range covering the Monday-to-Sunday school week
+// containing t.
+func WeekRange(t time.Time) DateRange {
+	day := DayRange(t)
+	// Weekday counts from Sunday; shift so Monday is 0 and Sunday is 6
+	offset := (int(day.From.Weekday()) + 6) % 7
+	start := day.From.AddDate(0, 0, -offset)
+	return DateRange{From: start, To: start.AddDate(0, 0, 7)}
+}
+
+// ParseDateRange reads ?date=YYYY-MM-DD for one day, or ?from=&to= for an
+// inclusive range of days, from the query. Without either it returns def.
+func ParseDateRange(query url.Values, def DateRange) (DateRange, error) {
+	date, from, to := query.Get("date"), query.Get("from"), query.Get("to")
+	switch {
+	case date != "" && (from != "" || to != ""):
+		return DateRange{}, errors.New("use either date or from and to, not both")
+	case date != "":
+		day, err := ParseDate(date)
+		if err != nil {
+			return DateRange{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", date)
+		}
+		return DayRange(day), nil
+	case from != "" || to != "":
+		if from == "" || to == "" {
+			return DateRange{}, errors.New("from and to must be given together")
+		}
+		start, err := ParseDate(from)
+		if err != nil {
+			return DateRange{}, fmt.Errorf("invalid from %q, expected YYYY-MM-DD", from)
+		}
+		end, err := ParseDate(to)
+		if err != nil {
+			return DateRange{}, fmt.Errorf("invalid to %q, expected YYYY-MM-DD", to)
+		}
+		if end.Before(start) {
+			return DateRange{}, errors.New("to must not be before from")
+		}
+		if end.Sub(start) > maxDateRangeDays*24*time.Hour {
+			return DateRange{}, fmt.Errorf("date ranges are limited to %d days", maxDateRangeDays)
+		}
+		return DateRange{From: start, To: DayRange(end).To}, nil
+	}
+	return def, nil
+}
@@ -0,0 +1,40 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenerateOpaqueToken returns a URL-safe random token carrying 256 bits of entropy.
+func GenerateOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken returns the hex SHA-256 of an opaque token. Only this hash is stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// shortCodeAlphabet leaves out characters that are easily confused when read
+// aloud or typed, such as 0/O and 1/I.
+const shortCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// GenerateShortCode returns a random human-typeable code of the given length.
+func GenerateShortCode(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		// 256 is a multiple of the alphabet size, so every character is equally likely
+		buf[i] = shortCodeAlphabet[int(b)%len(shortCodeAlphabet)]
+	}
+	return string(buf), nil
+}
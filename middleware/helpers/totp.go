@@ -0,0 +1,133 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPPeriod is the lifetime of one TOTP code (RFC 6238 default).
+	TOTPPeriod = 30 * time.Second
+	// TOTPDigits is the number of digits in a TOTP code.
+	TOTPDigits = 6
+	// totpSkew is how many periods before and after the current one are
+	// accepted, to tolerate clock drift on the user's phone.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new base32 encoded 160-bit TOTP secret.
+func GenerateTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// TOTPProvisioningURI returns the otpauth:// URI authenticator apps read from
+// a QR code.
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TOTPDigits))
+	params.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// TOTPStep returns the time step a moment falls in.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// TOTPCode returns the code of the secret for a time step.
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod), nil
+}
+
+// VerifyTOTP checks a code against the secret around time t and returns the
+// time step it matched. Callers must reject steps they have already accepted
+// so a code cannot be replayed.
+func VerifyTOTP(secret, code string, t time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+	current := TOTPStep(t)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// AcceptTOTP is VerifyTOTP for a user whose last accepted time step is
+// lastStep: codes of that step or an earlier one are refused, so a code cannot
+// be used twice.
+func AcceptTOTP(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	step, ok := VerifyTOTP(secret, code, t)
+	if !ok || step <= lastStep {
+		return 0, false
+	}
+	return step, true
+}
+
+// GenerateRecoveryCodes returns n single-use recovery codes formatted as
+// xxxxx-xxxxx.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	buf := make([]byte, 7)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(buf))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode lowercases a recovery code and restores its dash so
+// codes typed in any form hash the same.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
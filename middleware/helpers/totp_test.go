@@ -0,0 +1,136 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 seed of the RFC 6238 test vectors, base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, truncated to six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode at %d: %v", tt.unix, err)
+		}
+		if got != tt.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestVerifyTOTPWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := TOTPStep(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"two steps behind", -2, false},
+		{"one step behind", -1, true},
+		{"current step", 0, true},
+		{"one step ahead", 1, true},
+		{"two steps ahead", 2, false},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(rfc6238Secret, current+tt.offset)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		step, ok := VerifyTOTP(rfc6238Secret, code, now)
+		if ok != tt.ok {
+			t.Errorf("%s: VerifyTOTP ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && step != current+tt.offset {
+			t.Errorf("%s: VerifyTOTP step = %d, want %d", tt.name, step, current+tt.offset)
+		}
+	}
+}
+
+func TestVerifyTOTPRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870822", "abcdef"} {
+		if _, ok := VerifyTOTP(rfc6238Secret, code, now); ok {
+			t.Errorf("VerifyTOTP(%q) accepted a malformed code", code)
+		}
+	}
+	if _, ok := VerifyTOTP(rfc6238Secret, " 287 082 ", now); !ok {
+		t.Error("VerifyTOTP rejected a code with spaces")
+	}
+	if _, ok := VerifyTOTP("not base32!", "287082", now); ok {
+		t.Error("VerifyTOTP accepted a code for an invalid secret")
+	}
+}
+
+func TestAcceptTOTPRejectsReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := TOTPStep(now)
+	code, err := TOTPCode(rfc6238Secret, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := AcceptTOTP(rfc6238Secret, code, now, 0)
+	if !ok || step != current {
+		t.Fatalf("AcceptTOTP = %d, %v, want %d, true", step, ok, current)
+	}
+	if _, ok := AcceptTOTP(rfc6238Secret, code, now, step); ok {
+		t.Error("AcceptTOTP accepted the same code twice")
+	}
+
+	// An older code still inside the window must not be accepted after a newer one
+	previous, err := TOTPCode(rfc6238Secret, current-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := AcceptTOTP(rfc6238Secret, previous, now, step); ok {
+		t.Error("AcceptTOTP accepted a code older than the last accepted one")
+	}
+
+	next, err := TOTPCode(rfc6238Secret, current+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := AcceptTOTP(rfc6238Secret, next, now, step); !ok || got != current+1 {
+		t.Errorf("AcceptTOTP of the next step = %d, %v, want %d, true", got, ok, current+1)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("recovery code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		if NormalizeRecoveryCode(code) != code {
+			t.Errorf("NormalizeRecoveryCode(%q) changed a well-formed code", code)
+		}
+		if seen[code] {
+			t.Errorf("recovery code %q generated twice", code)
+		}
+		seen[code] = true
+	}
+
+	if got := NormalizeRecoveryCode("ABCDE FGHIJ"); got != "abcde-fghij" {
+		t.Errorf("NormalizeRecoveryCode = %q, want abcde-fghij", got)
+	}
+}
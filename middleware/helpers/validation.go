@@ -1,15 +1,15 @@
 package helpers
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
-	"math/rand"
+	"math/big"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-var JwtSecret = []byte("happytime") // Replace this with your own secret key
-
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 10)
 	if err != nil {
@@ -18,6 +18,13 @@ func HashPassword(password string) (string, error) {
 	return string(bytes), nil
 }
 
+// IsHashedPassword reports whether the stored value is a bcrypt hash rather than
+// a legacy plaintext password.
+func IsHashedPassword(storedPassword string) bool {
+	_, err := bcrypt.Cost([]byte(storedPassword))
+	return err == nil
+}
+
 func VerifyPassword(hashedPassword string, inputPassword string) error {
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(inputPassword))
 	if err != nil {
@@ -26,14 +33,44 @@ func VerifyPassword(hashedPassword string, inputPassword string) error {
 	return nil
 }
 
+// MinPasswordLength is the shortest password accepted by ValidatePassword.
+const MinPasswordLength = 8
+
+// ErrPasswordTooShort is returned by ValidatePassword for short passwords.
+var ErrPasswordTooShort = fmt.Errorf("password must be at least %d characters long", MinPasswordLength)
+
+// ValidatePassword checks a user-chosen password against the password policy.
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	return nil
+}
+
+// PlaceholderSecret is the value .env.example gives every secret. The server
+// refuses to start with it.
+const PlaceholderSecret = "change-me"
+
+// IsPlaceholderSecret reports whether a configured secret is missing or
+// still the placeholder from .env.example.
+func IsPlaceholderSecret(secret string) bool {
+	secret = strings.TrimSpace(secret)
+	return secret == "" || strings.HasPrefix(secret, PlaceholderSecret)
+}
+
 func GenerateRandomPassword(length int) string {
 	// Define a character set for the password
 	charset := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	password := make([]byte, length)
 
-	// Generate the password
+	// Generate the password from a cryptographically secure source
+	max := big.NewInt(int64(len(charset)))
 	for i := range password {
-		password[i] = charset[rand.Intn(len(charset))]
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		password[i] = charset[n.Int64()]
 	}
 
 	return string(password)
@@ -0,0 +1,293 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mineracail/guardApi/middleware/helpers"
+)
+
+// SigningKey is a key tokens are signed or verified with. Verify-only keys
+// (previous keys kept during rotation) have a nil SignKey.
+type SigningKey struct {
+	ID        string // Sent as the "kid" token header
+	Method    jwt.SigningMethod
+	SignKey   interface{} // []byte, *rsa.PrivateKey or ed25519.PrivateKey
+	VerifyKey interface{} // []byte, *rsa.PublicKey or ed25519.PublicKey
+}
+
+// KeyRing holds the active signing key plus the previous keys that are still
+// accepted for verification during a rotation.
+type KeyRing struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// Keys is the key ring used to sign and verify tokens. It is set at startup.
+var Keys *KeyRing
+
+// LoadKeyRing builds the key ring from the environment:
+//
+//	JWT_ALG               HS256 (default), RS256 or EdDSA
+//	JWT_KEY_ID            kid of the active key (derived from the key when empty)
+//	JWT_SECRET            HS256 secret, or JWT_SECRET_FILE to read it from a file
+//	JWT_PRIVATE_KEY_FILE  PEM private key for RS256 and EdDSA
+//	JWT_PREVIOUS_KEYS     comma-separated kid=path entries of keys still accepted
+//	                      for verification; each file holds a PEM public or
+//	                      private key, or a raw HS256 secret
+func LoadKeyRing() (*KeyRing, error) {
+	ring := &KeyRing{keys: map[string]*SigningKey{}}
+
+	active, err := loadActiveKey()
+	if err != nil {
+		return nil, err
+	}
+	ring.active = active
+	ring.keys[active.ID] = active
+
+	for _, entry := range strings.Split(os.Getenv("JWT_PREVIOUS_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(entry, "=")
+		if !ok || kid == "" || path == "" {
+			return nil, fmt.Errorf("invalid JWT_PREVIOUS_KEYS entry %q, expected kid=path", entry)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading previous key %s: %w", kid, err)
+		}
+		key, err := parseVerifyKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing previous key %s: %w", kid, err)
+		}
+		if _, exists := ring.keys[kid]; exists {
+			return nil, fmt.Errorf("duplicate signing key id %q", kid)
+		}
+		ring.keys[kid] = key
+	}
+
+	return ring, nil
+}
+
+// Active returns the key new tokens are signed with.
+func (k *KeyRing) Active() *SigningKey {
+	return k.active
+}
+
+// Lookup returns the key with the given kid.
+func (k *KeyRing) Lookup(kid string) (*SigningKey, bool) {
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// keyFunc resolves the verification key of a token from its kid header and
+// refuses tokens whose alg does not match that key.
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	if Keys == nil {
+		return nil, fmt.Errorf("signing keys are not loaded")
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := Keys.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.VerifyKey, nil
+}
+
+// JWKS serves the public keys of the key ring as a JSON Web Key Set so other
+// services can verify tokens offline. HS256 secrets are never published.
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	keys := []map[string]string{}
+	if Keys != nil {
+		for _, key := range Keys.keys {
+			if jwk := publicJWK(key); jwk != nil {
+				keys = append(keys, jwk)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+// publicJWK encodes an asymmetric verification key as a JWK.
+func publicJWK(key *SigningKey) map[string]string {
+	switch pub := key.VerifyKey.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"kid": key.ID,
+			"use": "sig",
+			"alg": key.Method.Alg(),
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"kid": key.ID,
+			"use": "sig",
+			"alg": key.Method.Alg(),
+			"x":   base64.RawURLEncoding.EncodeToString(pub),
+		}
+	}
+	return nil
+}
+
+// loadActiveKey reads the signing key selected by JWT_ALG.
+func loadActiveKey() (*SigningKey, error) {
+	alg := os.Getenv("JWT_ALG")
+	if alg == "" {
+		alg = jwt.SigningMethodHS256.Alg()
+	}
+
+	var key *SigningKey
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		secret, err := readSecret()
+		if err != nil {
+			return nil, err
+		}
+		key = &SigningKey{Method: jwt.SigningMethodHS256, SignKey: secret, VerifyKey: secret}
+	case jwt.SigningMethodRS256.Alg(), SigningMethodEd25519.Alg():
+		path := os.Getenv("JWT_PRIVATE_KEY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE is required for %s", alg)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT private key: %w", err)
+		}
+		key, err = parsePrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		if key.Method.Alg() != alg {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE holds a %s key but JWT_ALG is %s", key.Method.Alg(), alg)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported JWT_ALG %q", alg)
+	}
+
+	key.ID = os.Getenv("JWT_KEY_ID")
+	if key.ID == "" {
+		key.ID = deriveKeyID(key)
+	}
+	return key, nil
+}
+
+// readSecret reads the HS256 secret. A missing or placeholder secret is an
+// error: anyone who knows it can sign tokens.
+func readSecret() ([]byte, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if path := os.Getenv("JWT_SECRET_FILE"); secret == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT secret: %w", err)
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+	if helpers.IsPlaceholderSecret(secret) {
+		return nil, errors.New("JWT_SECRET or JWT_SECRET_FILE must be set to a secret of your own")
+	}
+	return []byte(secret), nil
+}
+
+// parsePrivateKey parses a PEM encoded RSA or Ed25519 private key.
+func parsePrivateKey(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in private key")
+	}
+
+	var parsed interface{}
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{Method: jwt.SigningMethodRS256, SignKey: private, VerifyKey: &private.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{Method: SigningMethodEd25519, SignKey: private, VerifyKey: private.Public().(ed25519.PublicKey)}, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", parsed)
+}
+
+// parseVerifyKey parses a previous key. PEM private keys are accepted so an
+// old key file can be kept as is, but they are only used for verification.
+func parseVerifyKey(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		secret := []byte(strings.TrimSpace(string(data)))
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodHS256, VerifyKey: secret}, nil
+	}
+
+	if strings.Contains(block.Type, "PRIVATE KEY") {
+		key, err := parsePrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		key.ID = kid
+		key.SignKey = nil
+		return key, nil
+	}
+
+	var parsed interface{}
+	var err error
+	if block.Type == "RSA PUBLIC KEY" {
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+
+	switch public := parsed.(type) {
+	case *rsa.PublicKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, VerifyKey: public}, nil
+	case ed25519.PublicKey:
+		return &SigningKey{ID: kid, Method: SigningMethodEd25519, VerifyKey: public}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", parsed)
+}
+
+// deriveKeyID derives a stable kid from the verification key.
+func deriveKeyID(key *SigningKey) string {
+	var material []byte
+	switch verify := key.VerifyKey.(type) {
+	case []byte:
+		material = verify
+	default:
+		material, _ = x509.MarshalPKIXPublicKey(verify)
+	}
+	sum := sha256.Sum256(material)
+	return hex.EncodeToString(sum[:8])
+}
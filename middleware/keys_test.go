@@ -0,0 +1,221 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// testKeys returns one signing key of every supported algorithm.
+func testKeys(t *testing.T) []*SigningKey {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("a-test-secret-that-is-long-enough")
+	return []*SigningKey{
+		{ID: "hs", Method: jwt.SigningMethodHS256, SignKey: secret, VerifyKey: secret},
+		{ID: "rs", Method: jwt.SigningMethodRS256, SignKey: rsaKey, VerifyKey: &rsaKey.PublicKey},
+		{ID: "ed", Method: SigningMethodEd25519, SignKey: edPrivate, VerifyKey: edPublic},
+	}
+}
+
+// useKeyRing installs a key ring signing with active for the duration of the test.
+func useKeyRing(t *testing.T, active *SigningKey, others ...*SigningKey) {
+	t.Helper()
+	ring := &KeyRing{active: active, keys: map[string]*SigningKey{active.ID: active}}
+	for _, key := range others {
+		ring.keys[key.ID] = key
+	}
+	previous := Keys
+	Keys = ring
+	t.Cleanup(func() { Keys = previous })
+}
+
+func TestGenerateAndValidateToken(t *testing.T) {
+	for _, key := range testKeys(t) {
+		t.Run(key.Method.Alg(), func(t *testing.T) {
+			useKeyRing(t, key)
+			signed, err := GenerateToken(UserTypeStaff, "profile-1", jwt.MapClaims{
+				"uid":      "user-1",
+				"position": "teacher",
+				"jti":      "token-1",
+				"fam":      "family-1",
+			})
+			if err != nil {
+				t.Fatalf("GenerateToken: %v", err)
+			}
+
+			claims, err := ValidateTokens(signed)
+			if err != nil {
+				t.Fatalf("ValidateTokens: %v", err)
+			}
+			if claims.ID != "profile-1" || claims.UserID != "user-1" || claims.Type != UserTypeStaff ||
+				claims.Position != "teacher" || claims.Id != "token-1" || claims.Family != "family-1" {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+
+			parsed, _ := jwt.Parse(signed, keyFunc)
+			if parsed == nil || parsed.Header["kid"] != key.ID || parsed.Header["alg"] != key.Method.Alg() {
+				t.Errorf("token header = %v, want kid %s and alg %s", parsed.Header, key.ID, key.Method.Alg())
+			}
+		})
+	}
+}
+
+func TestValidateTokenAfterRotation(t *testing.T) {
+	keys := testKeys(t)
+	hs, rs, ed := keys[0], keys[1], keys[2]
+
+	useKeyRing(t, rs)
+	signed, err := GenerateToken(UserTypeParent, "parent-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The old key is still accepted while it is listed as a previous key
+	previous := &SigningKey{ID: rs.ID, Method: rs.Method, VerifyKey: rs.VerifyKey}
+	useKeyRing(t, ed, previous, hs)
+	if _, err := ValidateTokens(signed); err != nil {
+		t.Errorf("token signed with a previous key was rejected: %v", err)
+	}
+
+	// Once the old key is dropped its tokens are rejected
+	useKeyRing(t, ed, hs)
+	if _, err := ValidateTokens(signed); err == nil {
+		t.Error("token signed with a removed key was accepted")
+	}
+}
+
+func TestValidateTokenRejectsAlgorithmMismatch(t *testing.T) {
+	keys := testKeys(t)
+	rs := keys[1]
+	useKeyRing(t, rs)
+
+	// Sign an HS256 token with the RSA public key as the secret and the RSA kid
+	publicDER, err := x509.MarshalPKIXPublicKey(rs.VerifyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"type": UserTypeAdmin, "id": "x", "exp": 4102444800})
+	token.Header["kid"] = rs.ID
+	signed, err := token.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateTokens(signed); err == nil {
+		t.Error("HS256 token with an RSA kid was accepted")
+	}
+
+	// Tokens without a kid or with an unknown kid are rejected
+	for _, kid := range []interface{}{nil, "unknown"} {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"type": UserTypeAdmin, "id": "x", "exp": 4102444800})
+		if kid != nil {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(rs.SignKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ValidateTokens(signed); err == nil {
+			t.Errorf("token with kid %v was accepted", kid)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	keys := testKeys(t)
+	hs, rs, ed := keys[0], keys[1], keys[2]
+	useKeyRing(t, rs, ed, hs)
+
+	rec := httptest.NewRecorder()
+	JWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("JWKS status = %d", rec.Code)
+	}
+
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&set); err != nil {
+		t.Fatal(err)
+	}
+	byID := map[string]map[string]string{}
+	for _, jwk := range set.Keys {
+		byID[jwk["kid"]] = jwk
+	}
+	if len(byID) != 2 {
+		t.Fatalf("JWKS published %d keys, want the RSA and Ed25519 keys only: %v", len(byID), set.Keys)
+	}
+	if _, ok := byID[hs.ID]; ok {
+		t.Error("JWKS published the HS256 secret")
+	}
+
+	rsaJWK := byID[rs.ID]
+	rsaPublic := rs.VerifyKey.(*rsa.PublicKey)
+	if rsaJWK["kty"] != "RSA" || rsaJWK["alg"] != "RS256" || rsaJWK["use"] != "sig" {
+		t.Errorf("unexpected RSA JWK %v", rsaJWK)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(rsaJWK["n"])
+	e, _ := base64.RawURLEncoding.DecodeString(rsaJWK["e"])
+	if new(big.Int).SetBytes(n).Cmp(rsaPublic.N) != 0 || new(big.Int).SetBytes(e).Int64() != int64(rsaPublic.E) {
+		t.Error("RSA JWK does not match the public key")
+	}
+
+	edJWK := byID[ed.ID]
+	x, _ := base64.RawURLEncoding.DecodeString(edJWK["x"])
+	if edJWK["kty"] != "OKP" || edJWK["crv"] != "Ed25519" || edJWK["alg"] != "EdDSA" ||
+		!ed25519.PublicKey(x).Equal(ed.VerifyKey.(ed25519.PublicKey)) {
+		t.Errorf("unexpected Ed25519 JWK %v", edJWK)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys := testKeys(t)
+	for _, key := range keys[1:] {
+		der, err := x509.MarshalPKCS8PrivateKey(key.SignKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		parsed, err := parsePrivateKey(privatePEM)
+		if err != nil {
+			t.Fatalf("parsePrivateKey %s: %v", key.Method.Alg(), err)
+		}
+		if parsed.Method.Alg() != key.Method.Alg() || deriveKeyID(parsed) != deriveKeyID(key) {
+			t.Errorf("parsePrivateKey %s returned a different key", key.Method.Alg())
+		}
+
+		// A previous private key is only used for verification
+		previous, err := parseVerifyKey("old", privatePEM)
+		if err != nil {
+			t.Fatalf("parseVerifyKey %s: %v", key.Method.Alg(), err)
+		}
+		if previous.ID != "old" || previous.SignKey != nil || deriveKeyID(previous) != deriveKeyID(key) {
+			t.Errorf("parseVerifyKey %s = %+v", key.Method.Alg(), previous)
+		}
+	}
+
+	secret, err := parseVerifyKey("old", []byte("old-secret\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Method != jwt.SigningMethodHS256 || string(secret.VerifyKey.([]byte)) != "old-secret" {
+		t.Errorf("parseVerifyKey of a raw secret = %+v", secret)
+	}
+}
@@ -0,0 +1,465 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// MFAChallengeTTL is how long a login has to complete the second factor.
+	MFAChallengeTTL = 5 * time.Minute
+	// totpIssuer names the account in authenticator apps.
+	totpIssuer = "guardApi"
+	// recoveryCodeCount is how many recovery codes are issued at a time.
+	recoveryCodeCount = 10
+)
+
+var (
+	errInvalidChallenge = errors.New("invalid or expired challenge")
+	errInvalidCode      = errors.New("invalid code")
+)
+
+type MFAChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code,omitempty"`
+}
+
+type TOTPCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// requiresSecondFactor reports whether signing in as p needs a TOTP code.
+// It is optional for staff and mandatory for admins.
+func requiresSecondFactor(user *models.User, p principal) bool {
+	return user.TOTPEnabled || p.Type == UserTypeAdmin
+}
+
+// sendMFAChallenge answers a correct password with a challenge token instead
+// of tokens. Admins who have not enrolled yet must enroll with it first.
+func sendMFAChallenge(w http.ResponseWriter, db *gorm.DB, user *models.User, p principal) {
+	token, err := IssueOneTimeToken(db, p.Type, user.ID.String(), models.TokenPurposeMFAChallenge, MFAChallengeTTL)
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error creating challenge")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"mfa_required":        true,
+		"challenge_token":     token,
+		"expires_in":          int(MFAChallengeTTL.Seconds()),
+		"enrollment_required": !user.TOTPEnabled,
+	})
+}
+
+// VerifyMFAChallenge completes a login with the challenge token from Login
+// and a TOTP or recovery code. For a pending enrollment the code also
+// confirms the authenticator and the response carries the recovery codes.
+func VerifyMFAChallenge(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var req MFAChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	challenge, user, err := loadChallenge(db, req.ChallengeToken)
+	if err != nil {
+		writeChallengeError(w, err)
+		return
+	}
+
+	// Guessing codes is throttled like guessing passwords
+	ip := clientIP(r)
+	emailKey, ipKey := EmailThrottleKey(user.Email), ipThrottleKey(ip)
+	wait, err := checkLoginThrottle(db, emailKey, ipKey)
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error checking login attempts")
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		WriteJSONError(w, http.StatusTooManyRequests, "too many failed login attempts, try again later")
+		return
+	}
+
+	// The challenge is claimed before the code is consumed, in one
+	// transaction: a concurrent request with the same challenge waits for
+	// this one and then finds it used, and a wrong code gives both back
+	enrolling := !user.TOTPEnabled
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.OneTimeToken{}).
+			Where("id = ? AND used_at IS NULL AND expires_at > ?", challenge.ID, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidChallenge
+		}
+
+		var ok bool
+		var err error
+		if enrolling {
+			ok, err = confirmTOTP(tx, user, req.Code)
+		} else {
+			ok, err = checkSecondFactor(tx, user, req.Code, req.RecoveryCode, ip)
+		}
+		if err == nil && !ok {
+			err = errInvalidCode
+		}
+		return err
+	})
+	switch {
+	case errors.Is(err, errInvalidCode):
+		recordLoginFailure(db, emailKey, emailThrottlePolicy, ip)
+		recordLoginFailure(db, ipKey, ipThrottlePolicy, ip)
+		WriteJSONError(w, http.StatusUnauthorized, err.Error())
+		return
+	case errors.Is(err, errInvalidChallenge):
+		writeChallengeError(w, err)
+		return
+	case err != nil:
+		WriteJSONError(w, http.StatusInternalServerError, "error verifying code")
+		return
+	}
+	ResetLoginThrottle(db, emailKey)
+
+	p, err := resolveContext(db, user, challenge.UserType)
+	if err != nil {
+		WriteJSONError(w, http.StatusNotFound, "account no longer exists")
+		return
+	}
+	resp, err := tokenResponse(db, p, uuid.New())
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+	if enrolling {
+		codes, err := replaceRecoveryCodes(db, user.ID)
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, "error creating recovery codes")
+			return
+		}
+		RecordAudit(db, models.AuditTOTPEnabled, user.Email, user.ID.String(), ip, "enrolled during login")
+		resp["recovery_codes"] = codes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// EnrollChallengeTOTP starts TOTP enrollment for an admin whose login is
+// waiting on a challenge. The challenge stays valid for VerifyMFAChallenge.
+func EnrollChallengeTOTP(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var req MFAChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	_, user, err := loadChallenge(db, req.ChallengeToken)
+	if err != nil {
+		writeChallengeError(w, err)
+		return
+	}
+	startEnrollment(w, db, user)
+}
+
+// EnrollTOTP starts TOTP enrollment for the signed-in user. The returned
+// secret is only used for logins after ConfirmTOTP.
+func EnrollTOTP(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(db, w, r)
+	if !ok {
+		return
+	}
+	startEnrollment(w, db, user)
+}
+
+// ConfirmTOTP enables TOTP with the first code from the authenticator and
+// returns a fresh set of recovery codes.
+func ConfirmTOTP(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	user, ok := currentUser(db, w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		WriteJSONError(w, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	ok, err := confirmTOTP(db, user, req.Code)
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error verifying code")
+		return
+	}
+	if !ok {
+		WriteJSONError(w, http.StatusBadRequest, "invalid code")
+		return
+	}
+	codes, err := replaceRecoveryCodes(db, user.ID)
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error creating recovery codes")
+		return
+	}
+	RecordAudit(db, models.AuditTOTPEnabled, user.Email, user.ID.String(), clientIP(r), "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// DisableTOTP turns two-factor authentication off after checking a current
+// code. Admins cannot disable it.
+func DisableTOTP(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	user, ok := currentUser(db, w, r)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		WriteJSONError(w, http.StatusConflict, "two-factor authentication is not enabled")
+		return
+	}
+	admin, err := isAdminUser(db, user)
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error loading profile")
+		return
+	}
+	if admin {
+		WriteJSONError(w, http.StatusForbidden, "two-factor authentication is required for admins")
+		return
+	}
+
+	ip := clientIP(r)
+	ok, err = checkSecondFactor(db, user, req.Code, req.RecoveryCode, ip)
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error verifying code")
+		return
+	}
+	if !ok {
+		WriteJSONError(w, http.StatusBadRequest, "invalid code")
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{"totp_secret": "", "totp_enabled": false}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error disabling two-factor authentication")
+		return
+	}
+	RecordAudit(db, models.AuditTOTPDisabled, user.Email, user.ID.String(), ip, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the signed-in user
+// after checking a current TOTP code.
+func RegenerateRecoveryCodes(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	user, ok := currentUser(db, w, r)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		WriteJSONError(w, http.StatusConflict, "two-factor authentication is not enabled")
+		return
+	}
+
+	ok, err := checkSecondFactor(db, user, req.Code, "", clientIP(r))
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error verifying code")
+		return
+	}
+	if !ok {
+		WriteJSONError(w, http.StatusBadRequest, "invalid code")
+		return
+	}
+	codes, err := replaceRecoveryCodes(db, user.ID)
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error creating recovery codes")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// startEnrollment stores a new pending TOTP secret and returns it with its
+// provisioning URI for the QR code.
+func startEnrollment(w http.ResponseWriter, db *gorm.DB, user *models.User) {
+	if user.TOTPEnabled {
+		WriteJSONError(w, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+	secret, err := helpers.GenerateTOTPSecret()
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error generating secret")
+		return
+	}
+	if err := db.Model(user).Update("totp_secret", secret).Error; err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error saving secret")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_url": helpers.TOTPProvisioningURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// confirmTOTP checks the first code of a pending enrollment and enables TOTP.
+func confirmTOTP(db *gorm.DB, user *models.User, code string) (bool, error) {
+	if user.TOTPSecret == "" {
+		return false, nil
+	}
+	step, ok := helpers.VerifyTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	result := db.Model(&models.User{}).
+		Where("id = ? AND totp_secret = ? AND totp_enabled = ?", user.ID, user.TOTPSecret, false).
+		Updates(map[string]interface{}{"totp_enabled": true, "totp_last_step": step})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// checkSecondFactor verifies a TOTP code or, when given, a recovery code.
+// Each TOTP time step and each recovery code is accepted only once.
+func checkSecondFactor(db *gorm.DB, user *models.User, code, recoveryCode, ip string) (bool, error) {
+	if recoveryCode != "" {
+		result := db.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, helpers.HashToken(helpers.NormalizeRecoveryCode(recoveryCode))).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return false, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return false, nil
+		}
+		RecordAudit(db, models.AuditRecoveryCodeUsed, user.Email, user.ID.String(), ip, "")
+		return true, nil
+	}
+
+	step, ok := helpers.AcceptTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return false, nil
+	}
+	// A concurrent login may have accepted the same step since user was read
+	result := db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores a new set.
+func replaceRecoveryCodes(db *gorm.DB, userID uuid.UUID) ([]string, error) {
+	codes, err := helpers.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		stored := make([]models.RecoveryCode, len(codes))
+		for i, code := range codes {
+			stored[i] = models.RecoveryCode{UserID: userID, CodeHash: helpers.HashToken(code)}
+		}
+		return tx.Create(&stored).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// loadChallenge returns the pending challenge token and its user.
+func loadChallenge(db *gorm.DB, token string) (*models.OneTimeToken, *models.User, error) {
+	var challenge models.OneTimeToken
+	if err := db.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?",
+		helpers.HashToken(token), models.TokenPurposeMFAChallenge, time.Now()).First(&challenge).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errInvalidChallenge
+		}
+		return nil, nil, err
+	}
+
+	var user models.User
+	if err := db.Where("id = ?", challenge.UserID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errInvalidChallenge
+		}
+		return nil, nil, err
+	}
+	return &challenge, &user, nil
+}
+
+func writeChallengeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidChallenge) {
+		WriteJSONError(w, http.StatusUnauthorized, err.Error())
+	} else {
+		WriteJSONError(w, http.StatusInternalServerError, "error loading challenge")
+	}
+}
+
+// currentUser loads the user of the request's token, writing a 401 if it is
+// gone.
+func currentUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	var user models.User
+	if err := db.Where("id = ?", GetUserIDFromContext(r.Context())).First(&user).Error; err != nil {
+		WriteJSONError(w, http.StatusUnauthorized, "account no longer exists")
+		return nil, false
+	}
+	return &user, true
+}
+
+// isAdminUser reports whether the user's staff profile is an admin.
+func isAdminUser(db *gorm.DB, user *models.User) (bool, error) {
+	if user.StaffID == nil {
+		return false, nil
+	}
+	var staff models.Staff
+	if err := db.Where("id = ?", *user.StaffID).First(&staff).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return StaffUserType(&staff) == UserTypeAdmin, nil
+}
@@ -1,10 +1,17 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware/helpers"
 	"github.com/mineracail/guardApi/models"
 	"gorm.io/gorm"
 )
@@ -12,8 +19,23 @@ import (
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Context  string `json:"context,omitempty"` // Optional profile to sign in as: staff or parent
 }
 
+type SwitchContextRequest struct {
+	Context string `json:"context"`
+}
+
+// principal is the identity a token pair is issued for.
+type principal struct {
+	UserID    string // User UUID, the "uid" claim
+	ProfileID string // Staff or Parent UUID, the "id" claim
+	Type      string // staff, parent or admin
+	Position  string // Staff position, empty for parents
+}
+
+var errNoSuchContext = errors.New("this account has no profile for the requested context")
+
 func Login(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -21,32 +43,145 @@ func Login(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Try to authenticate as Staff first
-	var staffUser models.Staff
-	if err := db.Where("email = ? AND password = ?", req.Email, req.Password).First(&staffUser).Error; err == nil {
-		// Generate JWT token for Staff
-		sendTokenResponse(w, staffUser.Email, staffUser.ID.String())
+	// Refuse attempts while the email or client IP is backing off or locked
+	ip := clientIP(r)
+	emailKey, ipKey := EmailThrottleKey(req.Email), ipThrottleKey(ip)
+	wait, err := checkLoginThrottle(db, emailKey, ipKey)
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error checking login attempts")
 		return
 	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		WriteJSONError(w, http.StatusTooManyRequests, "too many failed login attempts, try again later")
+		return
+	}
+
+	// One user per email, whether it signs in as staff, parent or both
+	var user models.User
+	if err := db.Where("lower(email) = ?", models.NormalizeEmail(req.Email)).First(&user).Error; err == nil &&
+		verifyAndUpgradePassword(db, &user, user.Password, req.Password) {
+		p, err := resolveContext(db, &user, req.Context)
+		if err != nil {
+			if errors.Is(err, errNoSuchContext) {
+				WriteJSONError(w, http.StatusBadRequest, err.Error())
+			} else {
+				WriteJSONError(w, http.StatusInternalServerError, "error loading profile")
+			}
+			return
+		}
+		// The throttle is only reset once the second factor is verified
+		if requiresSecondFactor(&user, p) {
+			sendMFAChallenge(w, db, &user, p)
+			return
+		}
+		ResetLoginThrottle(db, emailKey)
 
-	// If not found as Staff, try to authenticate as Parent
-	var parentUser models.Parent
-	if err := db.Where("email = ? AND password = ?", req.Email, req.Password).First(&parentUser).Error; err == nil {
-		// Generate JWT token for Parent
-		sendTokenResponse(w, parentUser.Email, parentUser.ID.String())
+		// Generate JWT tokens for the selected profile
+		sendTokenResponse(w, db, p, uuid.New())
 		return
 	}
 
-	// If  found as Parent or log the user found
-	// Log that neither Staff nor Parent was found
+	// Log that no user matched the credentials
 	log.Printf("Failed login attempt with email: %s", req.Email)
-	// If neither Staff nor Parent were found, return unauthorized
+	recordLoginFailure(db, emailKey, emailThrottlePolicy, ip)
+	recordLoginFailure(db, ipKey, ipThrottlePolicy, ip)
+	// If no user matched, return unauthorized
 	http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 }
 
-// Helper function to send the token response
-func sendTokenResponse(w http.ResponseWriter, email string, userID string) {
-	token, err := GenerateToken(email, userID)
+// SwitchContext issues tokens for the caller's other profile, e.g. a teacher
+// who is also a parent switching to the parent view.
+func SwitchContext(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var req SwitchContextRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Context == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := currentUser(db, w, r)
+	if !ok {
+		return
+	}
+
+	p, err := resolveContext(db, user, req.Context)
+	if err != nil {
+		if errors.Is(err, errNoSuchContext) {
+			WriteJSONError(w, http.StatusForbidden, err.Error())
+		} else {
+			WriteJSONError(w, http.StatusInternalServerError, "error loading profile")
+		}
+		return
+	}
+	// Switching must not skip the second factor an admin login requires
+	if requiresSecondFactor(user, p) && !user.TOTPEnabled {
+		WriteJSONError(w, http.StatusForbidden, "two-factor authentication is required, sign in again to enroll")
+		return
+	}
+	sendTokenResponse(w, db, p, uuid.New())
+}
+
+// resolveContext picks the profile a user signs in as. Without an explicit
+// context the staff profile is preferred.
+func resolveContext(db *gorm.DB, user *models.User, context string) (principal, error) {
+	switch strings.ToLower(context) {
+	case "":
+		if user.StaffID != nil {
+			return loadPrincipal(db, user.ID.String(), UserTypeStaff, user.StaffID.String())
+		}
+		if user.ParentID != nil {
+			return loadPrincipal(db, user.ID.String(), UserTypeParent, user.ParentID.String())
+		}
+	case UserTypeStaff, UserTypeAdmin:
+		if user.StaffID != nil {
+			return loadPrincipal(db, user.ID.String(), UserTypeStaff, user.StaffID.String())
+		}
+	case UserTypeParent:
+		if user.ParentID != nil {
+			return loadPrincipal(db, user.ID.String(), UserTypeParent, user.ParentID.String())
+		}
+	}
+	return principal{}, errNoSuchContext
+}
+
+// verifyAndUpgradePassword checks the input against the stored password. Rows
+// still holding a legacy plaintext password are rehashed with bcrypt on the
+// first successful login.
+func verifyAndUpgradePassword(db *gorm.DB, user interface{}, storedPassword, inputPassword string) bool {
+	if storedPassword == "" {
+		return false
+	}
+	if helpers.IsHashedPassword(storedPassword) {
+		return helpers.VerifyPassword(storedPassword, inputPassword) == nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(storedPassword), []byte(inputPassword)) != 1 {
+		return false
+	}
+	hashed, err := helpers.HashPassword(inputPassword)
+	if err != nil {
+		log.Printf("Error hashing legacy password: %v", err)
+		return true
+	}
+	if err := db.Model(user).Update("password", hashed).Error; err != nil {
+		log.Printf("Error rehashing legacy password: %v", err)
+	}
+	return true
+}
+
+// StaffUserType returns the principal type for a staff member: staff whose
+// position is admin are issued admin tokens.
+func StaffUserType(staff *models.Staff) string {
+	if strings.EqualFold(staff.Position, PositionAdmin) {
+		return UserTypeAdmin
+	}
+	return UserTypeStaff
+}
+
+// Helper function to send the token response. A new login starts a new
+// refresh token family; refreshes keep the family of the rotated token.
+func sendTokenResponse(w http.ResponseWriter, db *gorm.DB, p principal, familyID uuid.UUID) {
+	resp, err := tokenResponse(db, p, familyID)
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
@@ -55,5 +190,20 @@ func sendTokenResponse(w http.ResponseWriter, email string, userID string) {
 	// Send the token as a response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK) // Set status code to 200 OK
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	json.NewEncoder(w).Encode(resp)
+}
+
+// tokenResponse issues a token pair and returns the body of a token response.
+func tokenResponse(db *gorm.DB, p principal, familyID uuid.UUID) (map[string]interface{}, error) {
+	token, refreshToken, err := issueTokens(db, p, familyID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(AccessTokenTTL.Seconds()),
+		"context":       p.Type,
+	}, nil
 }
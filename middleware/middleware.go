@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,50 +11,84 @@ import (
 	"github.com/dgrijalva/jwt-go"
 )
 
-// JwtSecret is the secret key used for signing JWT tokens.
-// Replace this with your own secret key and keep it secure.
-var JwtSecret = []byte("SecretInHere")
-
 // TokenStruct defines the structure of the JWT token claims.
 type TokenStruct struct {
-	ID    string `json:"id"`
-	Type  string `json:"type"`
+	ID       string `json:"id"`            // Staff or Parent UUID of the active profile
+	UserID   string `json:"uid,omitempty"` // User UUID shared by the person's profiles
+	Type     string `json:"type"`
+	Position string `json:"position,omitempty"`
+	Family   string `json:"fam,omitempty"` // Refresh token family the token was issued from
 	jwt.StandardClaims
 }
 
+// Principal types carried in the "type" claim.
+const (
+	UserTypeStaff  = "staff"
+	UserTypeParent = "parent"
+	UserTypeAdmin  = "admin"
+)
+
+// Staff positions carried in the "position" claim.
+const (
+	PositionTeacher     = "teacher"
+	PositionAdmin       = "admin"
+	PositionMaintenance = "maintenance"
+)
+
 // Define context keys as custom types to avoid conflicts.
 type contextKey string
 
 const (
 	IDContextKey       contextKey = "ID"
 	UserTypeContextKey contextKey = "userType"
+	PositionContextKey contextKey = "position"
+	UserIDContextKey   contextKey = "uid"
+	ClaimsContextKey   contextKey = "claims"
 )
 
 // Middleware function for handling authentication and setting context values.
+// Requests without a valid bearer token are rejected with 401.
 func Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-
 		authHeader := r.Header.Get("Authorization")
-		if strings.HasPrefix(authHeader, "Bearer ") {
-			tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-			userID, userType, _, err := ParseToken(tokenStr)
-			if err == nil {
-				ctx = context.WithValue(ctx, IDContextKey, userID)
-				ctx = context.WithValue(ctx, UserTypeContextKey, userType)
-			}
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			WriteJSONError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+
+		claims, err := ValidateTokens(strings.TrimPrefix(authHeader, "Bearer "))
+		if err != nil {
+			WriteJSONError(w, http.StatusUnauthorized, "token expired or invalid, try logging in again")
+			return
 		}
 
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, IDContextKey, claims.ID)
+		ctx = context.WithValue(ctx, UserTypeContextKey, claims.Type)
+		ctx = context.WithValue(ctx, PositionContextKey, claims.Position)
+		ctx = context.WithValue(ctx, UserIDContextKey, claims.UserID)
+		ctx = context.WithValue(ctx, ClaimsContextKey, claims)
+
 		// Pass the request to the next handler with the updated context
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// WriteJSONError sends an error response with a JSON body of the form {"error": message}.
+func WriteJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// WriteForbidden sends the 403 JSON error body used for every access denial.
+func WriteForbidden(w http.ResponseWriter) {
+	WriteJSONError(w, http.StatusForbidden, "you don't have permission to access this resource")
+}
+
 // ParseToken parses the provided JWT token and returns the claims if valid.
 func ParseToken(tokenStr string) (string, string, map[string]interface{}, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return JwtSecret, nil
-	})
+	token, err := jwt.Parse(tokenStr, keyFunc)
 	if err != nil {
 		return "", "", nil, err
 	}
@@ -98,26 +133,50 @@ func ExtractCtxInfoForAllAccess(ctx context.Context) error {
 	userType = strings.ToLower(userType) // Convert userType to lowercase
 
 	// Check if the userType is not one of the allowed values.
-	if userType != "staff" && userType != "student" {
+	if userType != UserTypeStaff && userType != UserTypeParent && userType != UserTypeAdmin {
 		return fmt.Errorf("%s is not allowed", userType)
 	}
 
 	return nil
 }
 
+// HasRole reports whether the caller holds one of the given roles. A caller's
+// roles are its principal type plus, for staff, its position; admins hold
+// every role.
+func HasRole(ctx context.Context, roles ...string) bool {
+	if ExtractCtxInfoForAllAccess(ctx) != nil {
+		return false
+	}
+	userType, _ := GetUserTypeFromContext(ctx)
+	userType = strings.ToLower(userType)
+	if userType == UserTypeAdmin {
+		return true
+	}
+
+	position := strings.ToLower(GetPositionFromContext(ctx))
+	for _, role := range roles {
+		role = strings.ToLower(role)
+		if role == userType || (position != "" && role == position) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the caller is an admin.
+func IsAdmin(ctx context.Context) bool {
+	userType, _ := GetUserTypeFromContext(ctx)
+	return strings.EqualFold(userType, UserTypeAdmin)
+}
+
 // ValidateTokens validates the provided JWT token and returns the claims if the token is valid.
 func ValidateTokens(signedToken string) (*TokenStruct, error) {
 	// Parse the JWT token with claims and validation function.
 	token, err := jwt.ParseWithClaims(
 		signedToken,
 		&TokenStruct{},
-		func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method used in the token.
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return JwtSecret, nil
-		},
+		// Resolve the key from the kid header and validate the signing method.
+		keyFunc,
 	)
 
 	if err != nil {
@@ -140,14 +199,25 @@ func ValidateTokens(signedToken string) (*TokenStruct, error) {
 		return nil, fmt.Errorf("token has expired")
 	}
 
+	// Verify the token or its refresh token family has not been revoked.
+	if Revocations != nil && Revocations.IsRevoked(claims.Id, claims.Family) {
+		return nil, fmt.Errorf("token has been revoked")
+	}
+
 	// Token is valid, return the claims.
 	return claims, nil
 }
 
 // GenerateToken creates a new JWT token with the specified type, ID, and optional claims.
 func GenerateToken(userType string, ID string, optionalClaims ...jwt.MapClaims) (string, error) {
-	// Create a new JWT token with the signing method HS256 (HMAC SHA-256)
-	token := jwt.New(jwt.SigningMethodHS256)
+	if Keys == nil {
+		return "", fmt.Errorf("signing keys are not loaded")
+	}
+
+	// Create a new JWT token with the active key's signing method
+	key := Keys.Active()
+	token := jwt.New(key.Method)
+	token.Header["kid"] = key.ID
 
 	// Convert the token's claims to a map
 	claims := token.Claims.(jwt.MapClaims)
@@ -156,8 +226,8 @@ func GenerateToken(userType string, ID string, optionalClaims ...jwt.MapClaims)
 	claims["type"] = userType
 	claims["id"] = ID
 
-	// Set the "exp" (expiration) claim to the current time + 24 hours
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
+	// Set the "exp" (expiration) claim to the current time + AccessTokenTTL
+	claims["exp"] = time.Now().Add(AccessTokenTTL).Unix()
 
 	// Set optional claims if provided
 	for _, optionalClaim := range optionalClaims {
@@ -166,8 +236,8 @@ func GenerateToken(userType string, ID string, optionalClaims ...jwt.MapClaims)
 		}
 	}
 
-	// Sign the token using a secret key
-	tokenString, err := token.SignedString(JwtSecret)
+	// Sign the token using the active key
+	tokenString, err := token.SignedString(key.SignKey)
 	if err != nil {
 		return "", err
 	}
@@ -204,3 +274,32 @@ func GetIDFromContext(ctx context.Context) (string, error) {
 	}
 	return id, nil
 }
+
+// GetUserTypeFromContext retrieves the principal type (staff, parent or admin) from the context.
+func GetUserTypeFromContext(ctx context.Context) (string, error) {
+	userType, ok := ctx.Value(UserTypeContextKey).(string)
+	if !ok {
+		return "", fmt.Errorf("userType not found in context")
+	}
+	return userType, nil
+}
+
+// GetPositionFromContext retrieves the staff position from the context.
+// It is empty for parents.
+func GetPositionFromContext(ctx context.Context) string {
+	position, _ := ctx.Value(PositionContextKey).(string)
+	return position
+}
+
+// GetUserIDFromContext retrieves the User UUID shared by the caller's profiles.
+func GetUserIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(UserIDContextKey).(string)
+	return userID
+}
+
+// GetClaimsFromContext retrieves the claims of the caller's access token, or
+// nil outside Middleware.
+func GetClaimsFromContext(ctx context.Context) *TokenStruct {
+	claims, _ := ctx.Value(ClaimsContextKey).(*TokenStruct)
+	return claims
+}
@@ -0,0 +1,218 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/mailer"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// InvitationTTL is how long an invitation link stays valid.
+	InvitationTTL = 7 * 24 * time.Hour
+	// PasswordResetTTL is how long a password reset link stays valid.
+	PasswordResetTTL = time.Hour
+)
+
+var errInvalidOneTimeToken = errors.New("invalid or expired token")
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type SetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPassword mails a password reset link to the account with the given
+// email. The response is the same whether or not the account exists.
+func ForgotPassword(db *gorm.DB, mail mailer.Mailer, w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := db.Where("lower(email) = ?", models.NormalizeEmail(req.Email)).First(&user).Error; err == nil {
+		userID := user.ID.String()
+		// Only the latest reset link is usable
+		db.Model(&models.OneTimeToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, models.TokenPurposePasswordReset).
+			Update("used_at", time.Now())
+
+		token, err := IssueOneTimeToken(db, "", userID, models.TokenPurposePasswordReset, PasswordResetTTL)
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, "error creating reset token")
+			return
+		}
+		err = mail.Send(r.Context(), mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Use the link below to choose a new password. It expires in %s.\n\n%s/reset-password?token=%s",
+				PasswordResetTTL, AppBaseURL(), token),
+		})
+		if err != nil {
+			log.Printf("Error sending password reset mail: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account exists for this email, a reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password using a password reset token and signs
+// the account out of every session.
+func ResetPassword(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	token, ok := redeemPasswordToken(db, models.TokenPurposePasswordReset, w, r)
+	if !ok {
+		return
+	}
+
+	revokeUserSessions(db, token.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvitation sets the password of an invited account and logs it in,
+// through the second factor challenge when the account requires one.
+func AcceptInvitation(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	token, ok := redeemPasswordToken(db, models.TokenPurposeInvitation, w, r)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	if err := db.Where("id = ?", token.UserID).First(&user).Error; err != nil {
+		WriteJSONError(w, http.StatusNotFound, "account no longer exists")
+		return
+	}
+	p, err := resolveContext(db, &user, token.UserType)
+	if err != nil {
+		WriteJSONError(w, http.StatusNotFound, "account no longer exists")
+		return
+	}
+	// An invited admin still has to enroll in or pass the second factor
+	if requiresSecondFactor(&user, p) {
+		sendMFAChallenge(w, db, &user, p)
+		return
+	}
+	sendTokenResponse(w, db, p, uuid.New())
+}
+
+// IssueOneTimeToken stores a new single-use token for the user and returns it.
+func IssueOneTimeToken(db *gorm.DB, userType, userID, purpose string, ttl time.Duration) (string, error) {
+	token, err := helpers.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	stored := models.OneTimeToken{
+		UserID:    userID,
+		UserType:  userType,
+		Purpose:   purpose,
+		TokenHash: helpers.HashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := db.Create(&stored).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// AppBaseURL is the base URL of the web app used in mailed links.
+func AppBaseURL() string {
+	if url := os.Getenv("APP_BASE_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:8080"
+}
+
+// redeemPasswordToken consumes a one-time token of the given purpose and sets
+// the password from the request. It writes the error response itself.
+func redeemPasswordToken(db *gorm.DB, purpose string, w http.ResponseWriter, r *http.Request) (*models.OneTimeToken, bool) {
+	var req SetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return nil, false
+	}
+	if err := helpers.ValidatePassword(req.Password); err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	hashed, err := helpers.HashPassword(req.Password)
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error hashing password")
+		return nil, false
+	}
+
+	var token models.OneTimeToken
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token_hash = ? AND purpose = ?", helpers.HashToken(req.Token), purpose).First(&token).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errInvalidOneTimeToken
+			}
+			return err
+		}
+
+		// Consume the token; a concurrent redemption leaves nothing to update
+		now := time.Now()
+		result := tx.Model(&models.OneTimeToken{}).
+			Where("id = ? AND used_at IS NULL AND expires_at > ?", token.ID, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidOneTimeToken
+		}
+
+		return setPassword(tx, token.UserID, hashed)
+	})
+	if err != nil {
+		if errors.Is(err, errInvalidOneTimeToken) {
+			WriteJSONError(w, http.StatusBadRequest, err.Error())
+		} else {
+			WriteJSONError(w, http.StatusInternalServerError, "error setting password")
+		}
+		return nil, false
+	}
+	return &token, true
+}
+
+// setPassword stores an already hashed password on the user.
+func setPassword(db *gorm.DB, userID, hashed string) error {
+	result := db.Model(&models.User{}).Where("id = ?", userID).Update("password", hashed)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// revokeUserSessions revokes every refresh token family of the user.
+func revokeUserSessions(db *gorm.DB, userID string) {
+	var families []uuid.UUID
+	if err := db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Distinct().Pluck("family_id", &families).Error; err != nil {
+		log.Printf("Error listing sessions of user %s: %v", userID, err)
+		return
+	}
+	for _, family := range families {
+		revokeFamily(db, family)
+	}
+}
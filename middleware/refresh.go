@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// AccessTokenTTL is the lifetime of the bearer tokens checked by Middleware.
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is the lifetime of a refresh token before it must be rotated.
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh rotates a refresh token: the presented token is marked used and a
+// new access/refresh pair from the same family is returned. Presenting a
+// token that was already rotated or revoked revokes the whole family.
+func Refresh(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	var stored models.RefreshToken
+	if err := db.Where("token_hash = ?", helpers.HashToken(req.RefreshToken)).First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			WriteJSONError(w, http.StatusUnauthorized, "invalid refresh token")
+		} else {
+			WriteJSONError(w, http.StatusInternalServerError, "error looking up refresh token")
+		}
+		return
+	}
+
+	if stored.RevokedAt != nil || stored.UsedAt != nil {
+		log.Printf("Refresh token reuse detected for family %s, revoking it", stored.FamilyID)
+		revokeFamily(db, stored.FamilyID)
+		WriteJSONError(w, http.StatusUnauthorized, "refresh token has been revoked")
+		return
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		WriteJSONError(w, http.StatusUnauthorized, "refresh token has expired")
+		return
+	}
+
+	// Mark the token used; losing this race to a concurrent refresh counts as reuse
+	result := db.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", stored.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "error rotating refresh token")
+		return
+	}
+	if result.RowsAffected == 0 {
+		revokeFamily(db, stored.FamilyID)
+		WriteJSONError(w, http.StatusUnauthorized, "refresh token has been revoked")
+		return
+	}
+
+	// Re-read the principal so role or position changes apply on refresh
+	p, err := loadPrincipal(db, stored.UserID, stored.UserType, stored.ProfileID)
+	if err != nil {
+		revokeFamily(db, stored.FamilyID)
+		WriteJSONError(w, http.StatusUnauthorized, "account no longer exists")
+		return
+	}
+	// Sessions of staff promoted to admin end until they enroll in 2FA
+	if p.Type == UserTypeAdmin {
+		var user models.User
+		if err := db.Where("id = ?", p.UserID).First(&user).Error; err != nil || !user.TOTPEnabled {
+			revokeFamily(db, stored.FamilyID)
+			WriteJSONError(w, http.StatusUnauthorized, "two-factor authentication is required, sign in again")
+			return
+		}
+	}
+
+	sendTokenResponse(w, db, p, stored.FamilyID)
+}
+
+// Logout revokes the refresh token family of the presented refresh token and,
+// when the request carries a bearer token, that access token as well.
+func Logout(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	var stored models.RefreshToken
+	if err := db.Where("token_hash = ?", helpers.HashToken(req.RefreshToken)).First(&stored).Error; err == nil {
+		if err := revokeFamily(db, stored.FamilyID); err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, "error revoking refresh token")
+			return
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		WriteJSONError(w, http.StatusInternalServerError, "error looking up refresh token")
+		return
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") && Revocations != nil {
+		if claims, err := ValidateTokens(strings.TrimPrefix(authHeader, "Bearer ")); err == nil && claims.Id != "" {
+			if err := Revocations.Revoke(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+				log.Printf("Error revoking access token: %v", err)
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// issueTokens creates an access token and a refresh token belonging to familyID.
+func issueTokens(db *gorm.DB, p principal, familyID uuid.UUID) (string, string, error) {
+	accessToken, err := GenerateToken(p.Type, p.ProfileID, jwt.MapClaims{
+		"uid":      p.UserID,
+		"position": p.Position,
+		"jti":      uuid.New().String(),
+		"fam":      familyID.String(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := helpers.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	stored := models.RefreshToken{
+		FamilyID:  familyID,
+		UserID:    p.UserID,
+		ProfileID: p.ProfileID,
+		UserType:  p.Type,
+		TokenHash: helpers.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+	if err := db.Create(&stored).Error; err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// revokeFamily revokes every refresh token of the family and blocks the
+// access tokens issued from it.
+func revokeFamily(db *gorm.DB, familyID uuid.UUID) error {
+	now := time.Now()
+	if err := db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error; err != nil {
+		log.Printf("Error revoking refresh token family %s: %v", familyID, err)
+		return err
+	}
+	if Revocations != nil {
+		if err := Revocations.Revoke(familyID.String(), now.Add(AccessTokenTTL)); err != nil {
+			log.Printf("Error revoking access tokens of family %s: %v", familyID, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPrincipal returns the current principal for a user signing in as the
+// given profile, checking the profile is still linked to the user.
+func loadPrincipal(db *gorm.DB, userID, userType, profileID string) (principal, error) {
+	p := principal{UserID: userID, ProfileID: profileID}
+	switch userType {
+	case UserTypeStaff, UserTypeAdmin:
+		var staff models.Staff
+		if err := db.Joins("JOIN users ON users.staff_id = staffs.id").
+			Where("staffs.id = ? AND users.id = ?", profileID, userID).First(&staff).Error; err != nil {
+			return p, err
+		}
+		p.Type, p.Position = StaffUserType(&staff), staff.Position
+		return p, nil
+	case UserTypeParent:
+		var parent models.Parent
+		if err := db.Joins("JOIN users ON users.parent_id = parents.id").
+			Where("parents.id = ? AND users.id = ?", profileID, userID).First(&parent).Error; err != nil {
+			return p, err
+		}
+		p.Type = UserTypeParent
+		return p, nil
+	}
+	return p, errors.New("unknown user type")
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testDB returns a transaction on the database named by TEST_DATABASE_URL
+// that is rolled back when the test ends. Tests needing it are skipped when
+// the variable is not set.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tx := db.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+	if err := tx.AutoMigrate(&models.Staff{}, &models.User{}, &models.RefreshToken{}, &models.RevokedToken{}); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// refresh posts a refresh token to Refresh and returns the response.
+func refresh(db *gorm.DB, refreshToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(RefreshRequest{RefreshToken: refreshToken})
+	rec := httptest.NewRecorder()
+	Refresh(db, rec, httptest.NewRequest(http.MethodPost, "/refresh", strings.NewReader(string(body))))
+	return rec
+}
+
+func TestRefreshRotationAndReuse(t *testing.T) {
+	db := testDB(t)
+	useKeyRing(t, testKeys(t)[0])
+	previous := Revocations
+	Revocations = &RevocationList{db: db, revoked: map[string]time.Time{}}
+	t.Cleanup(func() { Revocations = previous })
+
+	staff := models.Staff{FirstName: "Test", LastName: "Teacher", Position: PositionTeacher}
+	if err := db.Create(&staff).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Email: "teacher-" + uuid.NewString() + "@example.com", StaffID: &staff.ID}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	familyID := uuid.New()
+	p := principal{UserID: user.ID.String(), ProfileID: staff.ID.String(), Type: UserTypeStaff, Position: PositionTeacher}
+	firstAccess, firstRefresh, err := issueTokens(db, p, familyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotating returns a new pair from the same family
+	rec := refresh(db, firstRefresh)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first refresh status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.RefreshToken == "" || resp.RefreshToken == firstRefresh {
+		t.Fatal("refresh did not rotate the refresh token")
+	}
+	claims, err := ValidateTokens(resp.Token)
+	if err != nil {
+		t.Fatalf("rotated access token rejected: %v", err)
+	}
+	if claims.Family != familyID.String() || claims.UserID != p.UserID {
+		t.Errorf("rotated access token claims = %+v", claims)
+	}
+
+	// Presenting the rotated token again revokes the whole family
+	if rec := refresh(db, firstRefresh); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("reused refresh status = %d, want 401", rec.Code)
+	}
+	var active int64
+	db.Model(&models.RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", familyID).Count(&active)
+	if active != 0 {
+		t.Errorf("%d refresh tokens of the family are still active after reuse", active)
+	}
+	if rec := refresh(db, resp.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("refresh with the latest token after reuse status = %d, want 401", rec.Code)
+	}
+	for _, token := range []string{firstAccess, resp.Token} {
+		if _, err := ValidateTokens(token); err == nil {
+			t.Error("access token of a revoked family was accepted")
+		}
+	}
+
+	// Another family of the same user is unaffected
+	_, otherRefresh, err := issueTokens(db, p, uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := refresh(db, otherRefresh); rec.Code != http.StatusOK {
+		t.Errorf("refresh of another family status = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// revocationRefreshInterval is how often the in-memory cache is reloaded from
+// the revoked_tokens table. Revocations made by other instances take effect
+// within this interval.
+const revocationRefreshInterval = 30 * time.Second
+
+// RevocationList is the server-side list of revoked access token IDs and
+// refresh token families, cached in memory.
+type RevocationList struct {
+	db      *gorm.DB
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+// Revocations is consulted by ValidateTokens. It is nil until set at startup.
+var Revocations *RevocationList
+
+// NewRevocationList creates a revocation list backed by the revoked_tokens table.
+func NewRevocationList(db *gorm.DB) *RevocationList {
+	list := &RevocationList{db: db, revoked: map[string]time.Time{}}
+	list.reload()
+	return list
+}
+
+// Start reloads the list in the background every revocationRefreshInterval
+// until ctx is done.
+func (l *RevocationList) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(revocationRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				l.reload()
+			}
+		}
+	}()
+}
+
+// Revoke blocks the token ID or family ID until expiresAt.
+func (l *RevocationList) Revoke(id string, expiresAt time.Time) error {
+	entry := models.RevokedToken{ID: id, ExpiresAt: expiresAt}
+	if err := l.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry).Error; err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.revoked[id] = expiresAt
+	l.mu.Unlock()
+	return nil
+}
+
+// IsRevoked reports whether any of the given token or family IDs is revoked.
+func (l *RevocationList) IsRevoked(ids ...string) bool {
+	now := time.Now()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		if expiresAt, ok := l.revoked[id]; ok && expiresAt.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// reload merges the unexpired rows into the cache, drops expired entries and
+// prunes expired rows. Entries are only ever added, so revocations made here
+// while the rows were read are kept.
+func (l *RevocationList) reload() {
+	now := time.Now()
+	if err := l.db.Where("expires_at <= ?", now).Delete(&models.RevokedToken{}).Error; err != nil {
+		log.Printf("Error pruning revoked tokens: %v", err)
+	}
+
+	var entries []models.RevokedToken
+	if err := l.db.Where("expires_at > ?", now).Find(&entries).Error; err != nil {
+		// Keep serving the current cache and retry on the next tick
+		log.Printf("Error loading revoked tokens: %v", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, expiresAt := range l.revoked {
+		if !expiresAt.After(now) {
+			delete(l.revoked, id)
+		}
+	}
+	for _, entry := range entries {
+		l.revoked[entry.ID] = entry.ExpiresAt
+	}
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// throttlePolicy describes how failed logins for one key are slowed down.
+type throttlePolicy struct {
+	freeAttempts int           // Failures allowed before backoff starts
+	lockAfter    int           // Failures that trigger a lockout
+	lockout      time.Duration // Lockout duration
+	auditAction  string
+}
+
+var (
+	emailThrottlePolicy = throttlePolicy{freeAttempts: 3, lockAfter: 10, lockout: 30 * time.Minute, auditAction: models.AuditAccountLocked}
+	ipThrottlePolicy    = throttlePolicy{freeAttempts: 20, lockAfter: 100, lockout: 30 * time.Minute, auditAction: models.AuditIPLocked}
+)
+
+const (
+	// throttleWindow is how long a failure is remembered; counting restarts
+	// after a quiet period this long.
+	throttleWindow = time.Hour
+	// maxLoginBackoff caps the exponential delay between attempts.
+	maxLoginBackoff = 15 * time.Minute
+)
+
+// penalty returns the delay before the next attempt after failures
+// consecutive failures, or whether the key is locked out instead.
+func (p throttlePolicy) penalty(failures int) (backoff time.Duration, lock bool) {
+	switch {
+	case failures >= p.lockAfter:
+		return 0, true
+	case failures > p.freeAttempts:
+		backoff = time.Second << uint(failures-p.freeAttempts-1)
+		if backoff > maxLoginBackoff || backoff <= 0 {
+			backoff = maxLoginBackoff
+		}
+		return backoff, false
+	}
+	return 0, false
+}
+
+// EmailThrottleKey returns the throttle key of an email address.
+func EmailThrottleKey(email string) string {
+	return "email:" + models.NormalizeEmail(email)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// clientIP returns the IP address of the connection the request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkLoginThrottle returns how long the caller must wait before another
+// login attempt is accepted for any of the keys, or zero.
+func checkLoginThrottle(db *gorm.DB, keys ...string) (time.Duration, error) {
+	var throttles []models.LoginThrottle
+	if err := db.Where("key IN ?", keys).Find(&throttles).Error; err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	for _, throttle := range throttles {
+		for _, until := range []*time.Time{throttle.BlockedUntil, throttle.LockedUntil} {
+			if until != nil && until.Sub(now) > wait {
+				wait = until.Sub(now)
+			}
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed login for the key and applies backoff or
+// a lockout once the policy's thresholds are reached.
+func recordLoginFailure(db *gorm.DB, key string, policy throttlePolicy, ip string) {
+	now := time.Now()
+	var failures int
+	err := db.Raw(`INSERT INTO login_throttles (key, failures, last_failure_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures`, key, now, now.Add(-throttleWindow)).Scan(&failures).Error
+	if err != nil {
+		log.Printf("Error recording failed login for %s: %v", key, err)
+		return
+	}
+
+	backoff, lock := policy.penalty(failures)
+	switch {
+	case lock:
+		// Lock the key and start counting again once the lockout ends
+		lockedUntil := now.Add(policy.lockout)
+		err = db.Model(&models.LoginThrottle{}).Where("key = ?", key).
+			Updates(map[string]interface{}{"failures": 0, "locked_until": lockedUntil, "blocked_until": nil}).Error
+		if err == nil {
+			RecordAudit(db, policy.auditAction, strings.SplitN(key, ":", 2)[1], "", ip,
+				"locked until "+lockedUntil.Format(time.RFC3339)+" after repeated failed logins")
+		}
+	case backoff > 0:
+		err = db.Model(&models.LoginThrottle{}).Where("key = ?", key).Update("blocked_until", now.Add(backoff)).Error
+	}
+	if err != nil {
+		log.Printf("Error throttling logins for %s: %v", key, err)
+	}
+}
+
+// ResetLoginThrottle clears the failure count and any lockout of the key.
+func ResetLoginThrottle(db *gorm.DB, key string) error {
+	return db.Where("key = ?", key).Delete(&models.LoginThrottle{}).Error
+}
+
+// RecordAudit appends an audit event; failures are logged, not returned.
+func RecordAudit(db *gorm.DB, action, subject, actorID, ip, detail string) {
+	event := models.AuditEvent{Action: action, Subject: subject, ActorID: actorID, IP: ip, Detail: detail}
+	if err := db.Create(&event).Error; err != nil {
+		log.Printf("Error recording audit event %s for %s: %v", action, subject, err)
+	}
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottlePenalty(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   throttlePolicy
+		failures int
+		backoff  time.Duration
+		lock     bool
+	}{
+		{"email first failure", emailThrottlePolicy, 1, 0, false},
+		{"email last free attempt", emailThrottlePolicy, 3, 0, false},
+		{"email backoff starts", emailThrottlePolicy, 4, time.Second, false},
+		{"email backoff doubles", emailThrottlePolicy, 5, 2 * time.Second, false},
+		{"email before lockout", emailThrottlePolicy, 9, 32 * time.Second, false},
+		{"email lockout", emailThrottlePolicy, 10, 0, true},
+		{"ip last free attempt", ipThrottlePolicy, 20, 0, false},
+		{"ip backoff starts", ipThrottlePolicy, 21, time.Second, false},
+		{"ip backoff capped", ipThrottlePolicy, 40, maxLoginBackoff, false},
+		{"ip backoff capped on overflow", ipThrottlePolicy, 99, maxLoginBackoff, false},
+		{"ip lockout", ipThrottlePolicy, 100, 0, true},
+	}
+	for _, tt := range tests {
+		backoff, lock := tt.policy.penalty(tt.failures)
+		if backoff != tt.backoff || lock != tt.lock {
+			t.Errorf("%s: penalty(%d) = %v, %v, want %v, %v", tt.name, tt.failures, backoff, lock, tt.backoff, tt.lock)
+		}
+	}
+}
+
+func TestEmailThrottleKeyNormalizesEmail(t *testing.T) {
+	if got := EmailThrottleKey("  Parent@Example.COM "); got != "email:parent@example.com" {
+		t.Errorf("EmailThrottleKey = %q", got)
+	}
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Missing-child alert statuses.
+const (
+	AlertOpen         = "open"
+	AlertAcknowledged = "acknowledged"
+	AlertEscalated    = "escalated"
+	AlertResolved     = "resolved" // The student arrived at school or was confirmed back home
+)
+
+// GradeCutoff is the time of day by which students of a grade who left home
+// must be confirmed at school.
+type GradeCutoff struct {
+	Grade                string    `gorm:"primaryKey" json:"grade"`
+	Cutoff               string    `gorm:"not null" json:"cutoff"`                          // HH:MM, school local time
+	EscalateAfterMinutes int       `gorm:"not null;default:15" json:"escalateAfterMinutes"` // Unacknowledged alerts escalate to admins after this long
+	UpdatedAt            time.Time `json:"updatedAt"`
+}
+
+// MissingChildAlert is raised when a student left home but was not confirmed
+// at school by the grade's cutoff.
+type MissingChildAlert struct {
+	ID                 uuid.UUID        `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	StudentID          uuid.UUID        `gorm:"type:uuid;not null;uniqueIndex:idx_missing_child_alerts_student_date" json:"studentId"`
+	Student            *Student         `gorm:"foreignKey:StudentID;constraint:OnDelete:CASCADE" json:"student,omitempty"`
+	Date               string           `gorm:"not null;uniqueIndex:idx_missing_child_alerts_student_date;index" json:"date"` // YYYY-MM-DD
+	Grade              string           `json:"grade"`
+	Status             string           `gorm:"not null;index" json:"status"`
+	AcknowledgedAt     *time.Time       `json:"acknowledgedAt,omitempty"`
+	AcknowledgedByID   string           `json:"acknowledgedById,omitempty"`
+	AcknowledgedByType string           `json:"acknowledgedByType,omitempty"`
+	EscalatedAt        *time.Time       `json:"escalatedAt,omitempty"`
+	ResolvedAt         *time.Time       `json:"resolvedAt,omitempty"`
+	Recipients         []AlertRecipient `gorm:"foreignKey:AlertID" json:"recipients,omitempty"`
+	CreatedAt          time.Time        `json:"createdAt"`
+	UpdatedAt          time.Time        `json:"updatedAt"`
+}
+
+// AlertRecipient is a parent or staff member notified of an alert.
+type AlertRecipient struct {
+	ID             uuid.UUID          `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	AlertID        uuid.UUID          `gorm:"type:uuid;not null;uniqueIndex:idx_alert_recipients_alert_recipient" json:"alertId"`
+	Alert          *MissingChildAlert `gorm:"foreignKey:AlertID;constraint:OnDelete:CASCADE" json:"-"`
+	RecipientID    uuid.UUID          `gorm:"type:uuid;not null;uniqueIndex:idx_alert_recipients_alert_recipient;index" json:"recipientId"` // Parent or Staff UUID
+	RecipientType  string             `gorm:"not null" json:"recipientType"`                                                                // parent or staff
+	Escalation     bool               `gorm:"not null;default:false" json:"escalation"`                                                     // Added when the alert escalated
+	AcknowledgedAt *time.Time         `json:"acknowledgedAt,omitempty"`
+	CreatedAt      time.Time          `json:"createdAt"`
+}
+
+// BeforeCreate hook to generate a UUID before creating a new alert
+func (a *MissingChildAlert) BeforeCreate(tx *gorm.DB) (err error) {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return
+}
+
+// BeforeCreate hook to generate a UUID before creating a new alert recipient
+func (r *AlertRecipient) BeforeCreate(tx *gorm.DB) (err error) {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return
+}
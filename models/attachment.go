@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Attachment is a file attached to a message. The file itself is kept in
+// the blob store under StorageKey.
+type Attachment struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	MessageID   uuid.UUID `gorm:"type:uuid;not null;index" json:"message_id"`
+	UploaderID  uuid.UUID `gorm:"type:uuid;not null" json:"uploader_id"`
+	FileName    string    `gorm:"not null" json:"file_name"`
+	ContentType string    `gorm:"not null" json:"content_type"`
+	Size        int64     `gorm:"not null" json:"size"`
+	SHA256      string    `gorm:"column:sha256;not null" json:"sha256"`
+	StorageKey  string    `gorm:"not null;uniqueIndex" json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	Message Message `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (attachment *Attachment) BeforeCreate(tx *gorm.DB) error {
+	if attachment.ID == uuid.Nil {
+		attachment.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Attendance states of a student during one school day, in order.
+const (
+	AttendanceNone          = "none"
+	AttendanceLeftHome      = "left_home"
+	AttendanceArrivedSchool = "arrived_school"
+	AttendanceCheckedOut    = "checked_out"
+	AttendancePickedUp      = "picked_up"
+	AttendanceArrivedHome   = "arrived_home"
+)
+
+// attendanceTransitions lists the states each state may move to. A student
+// brought by bus arrives at school without a recorded departure from home.
+var attendanceTransitions = map[string][]string{
+	AttendanceNone:          {AttendanceLeftHome, AttendanceArrivedSchool},
+	AttendanceLeftHome:      {AttendanceArrivedSchool},
+	AttendanceArrivedSchool: {AttendanceCheckedOut},
+	AttendanceCheckedOut:    {AttendancePickedUp},
+	AttendancePickedUp:      {AttendanceArrivedHome},
+}
+
+// ValidAttendanceState reports whether state is a state a student can be moved to.
+func ValidAttendanceState(state string) bool {
+	switch state {
+	case AttendanceLeftHome, AttendanceArrivedSchool, AttendanceCheckedOut, AttendancePickedUp, AttendanceArrivedHome:
+		return true
+	}
+	return false
+}
+
+// CanTransition reports whether a student in state from may move to state to.
+func CanTransition(from, to string) bool {
+	for _, next := range attendanceTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CanTurnBack reports whether a student in state from may go back to state to:
+// a student who left home may be confirmed back home without reaching school.
+// Turning back is only recorded by hand, never derived from other events.
+func CanTurnBack(from, to string) bool {
+	return from == AttendanceLeftHome && to == AttendanceArrivedHome
+}
+
+// AttendanceDay is the current attendance state of one student on one day,
+// with the time each state was reached.
+type AttendanceDay struct {
+	ID              uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	StudentID       uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_attendance_days_student_date" json:"studentId"`
+	Student         *Student   `gorm:"foreignKey:StudentID;constraint:OnDelete:CASCADE" json:"-"`
+	Date            string     `gorm:"not null;uniqueIndex:idx_attendance_days_student_date;index" json:"date"` // YYYY-MM-DD
+	State           string     `gorm:"not null;default:none" json:"state"`
+	LeftHomeAt      *time.Time `json:"leftHomeAt,omitempty"`
+	ArrivedSchoolAt *time.Time `json:"arrivedSchoolAt,omitempty"`
+	CheckedOutAt    *time.Time `json:"checkedOutAt,omitempty"`
+	PickedUpAt      *time.Time `json:"pickedUpAt,omitempty"`
+	ArrivedHomeAt   *time.Time `json:"arrivedHomeAt,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// SetStateTime records when the day reached state.
+func (d *AttendanceDay) SetStateTime(state string, at time.Time) {
+	switch state {
+	case AttendanceLeftHome:
+		d.LeftHomeAt = &at
+	case AttendanceArrivedSchool:
+		d.ArrivedSchoolAt = &at
+	case AttendanceCheckedOut:
+		d.CheckedOutAt = &at
+	case AttendancePickedUp:
+		d.PickedUpAt = &at
+	case AttendanceArrivedHome:
+		d.ArrivedHomeAt = &at
+	}
+}
+
+// AttendanceEvent is one recorded transition of an attendance day.
+type AttendanceEvent struct {
+	ID              uuid.UUID      `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	AttendanceDayID uuid.UUID      `gorm:"type:uuid;index;not null" json:"attendanceDayId"`
+	AttendanceDay   *AttendanceDay `gorm:"foreignKey:AttendanceDayID;constraint:OnDelete:CASCADE" json:"-"`
+	StudentID       uuid.UUID      `gorm:"type:uuid;index;not null" json:"studentId"`
+	FromState       string         `gorm:"not null" json:"fromState"`
+	ToState         string         `gorm:"not null" json:"toState"`
+	ActorID         string         `json:"actorId"`   // Staff or Parent UUID who recorded the transition
+	ActorType       string         `json:"actorType"` // staff, parent or admin
+	Note            string         `json:"note,omitempty"`
+	CreatedAt       time.Time      `json:"createdAt"`
+}
+
+// BeforeCreate hook to generate a UUID before creating a new attendance day
+func (d *AttendanceDay) BeforeCreate(tx *gorm.DB) (err error) {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return
+}
+
+// BeforeCreate hook to generate a UUID before creating a new attendance event
+func (e *AttendanceEvent) BeforeCreate(tx *gorm.DB) (err error) {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return
+}
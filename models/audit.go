@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Audit event actions.
+const (
+	AuditAccountLocked    = "account_locked"
+	AuditAccountUnlocked  = "account_unlocked"
+	AuditIPLocked         = "ip_locked"
+	AuditTOTPEnabled      = "totp_enabled"
+	AuditTOTPDisabled     = "totp_disabled"
+	AuditRecoveryCodeUsed = "recovery_code_used"
+)
+
+// AuditEvent is an append-only record of a security-relevant event.
+type AuditEvent struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	Action    string    `gorm:"index;not null" json:"action"`
+	Subject   string    `gorm:"index" json:"subject"` // Email, IP address or record ID the event is about
+	ActorID   string    `json:"actorId,omitempty"`    // Authenticated user who caused the event, if any
+	IP        string    `json:"ip,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+}
+
+// LoginThrottle counts recent failed logins for one email address or client IP.
+type LoginThrottle struct {
+	Key           string     `gorm:"primaryKey" json:"key"` // "email:<address>" or "ip:<address>"
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt time.Time  `json:"lastFailureAt"`
+	BlockedUntil  *time.Time `json:"blockedUntil,omitempty"` // Backoff after repeated failures
+	LockedUntil   *time.Time `json:"lockedUntil,omitempty"`  // Temporary lockout
+}
+
+// BeforeCreate hook to generate a UUID before creating a new audit event
+func (e *AuditEvent) BeforeCreate(tx *gorm.DB) (err error) {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return
+}
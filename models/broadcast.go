@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Broadcast audiences.
+const (
+	AudienceGradeParents     = "grade_parents"     // Guardians of every student in Grade
+	AudienceStaffPosition    = "staff_position"    // Staff holding Position
+	AudienceStudentGuardians = "student_guardians" // Guardians of StudentIDs
+)
+
+// Broadcast and recipient delivery statuses.
+const (
+	DeliveryPending = "pending"
+	DeliverySent    = "sent"
+	DeliveryPartial = "partial" // Broadcasts only: some recipients failed
+	DeliveryFailed  = "failed"
+)
+
+// Broadcast is one message sent to an audience resolved when it was created.
+// Every recipient gets the message in their conversation with the sender.
+type Broadcast struct {
+	ID             uuid.UUID   `gorm:"type:uuid;primaryKey" json:"id"`
+	SenderID       uuid.UUID   `gorm:"type:uuid;not null;index" json:"sender_id"`
+	Content        string      `gorm:"not null" json:"content"`
+	TemplateID     *uuid.UUID  `gorm:"type:uuid" json:"template_id,omitempty"`
+	Audience       string      `gorm:"not null" json:"audience"`
+	Grade          string      `json:"grade,omitempty"`
+	Position       string      `json:"position,omitempty"`
+	StudentIDs     []uuid.UUID `gorm:"type:jsonb;serializer:json" json:"student_ids,omitempty"`
+	Status         string      `gorm:"not null;default:'pending';index" json:"status"` // pending, sent, partial or failed
+	RecipientCount int         `json:"recipient_count"`
+	SentCount      int         `json:"sent_count"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+	CompletedAt    *time.Time  `json:"completed_at"`
+}
+
+func (broadcast *Broadcast) BeforeCreate(tx *gorm.DB) error {
+	if broadcast.ID == uuid.Nil {
+		broadcast.ID = uuid.New()
+	}
+	return nil
+}
+
+// BroadcastRecipient is the delivery of a broadcast to one staff or parent
+// profile.
+type BroadcastRecipient struct {
+	BroadcastID   uuid.UUID  `gorm:"type:uuid;primaryKey" json:"broadcast_id"`
+	RecipientID   uuid.UUID  `gorm:"type:uuid;primaryKey" json:"recipient_id"`
+	Status        string     `gorm:"not null;default:'pending';index" json:"status"` // pending, sent or failed
+	Content       string     `json:"content,omitempty"`                              // Rendered for this recipient, empty when it is the broadcast's
+	MessageID     *uuid.UUID `gorm:"type:uuid" json:"message_id"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at,omitempty"` // Not retried before then; nil when due
+	LastError     string     `json:"last_error,omitempty"`
+	SentAt        *time.Time `json:"sent_at"`
+
+	Broadcast Broadcast `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Conversation is the thread of messages between two participants, staff or
+// parent profiles. ParticipantA is always the lower of the two IDs so each
+// pair has exactly one conversation.
+type Conversation struct {
+	ID            uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	ParticipantA  uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_conversation_pair" json:"participant_a"`
+	ParticipantB  uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_conversation_pair;index" json:"participant_b"`
+	LastMessageAt time.Time `gorm:"not null;index" json:"last_message_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// NewConversation returns the conversation between a and b, ordering them
+// the way Postgres orders UUIDs.
+func NewConversation(a, b uuid.UUID) Conversation {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return Conversation{ParticipantA: a, ParticipantB: b}
+}
+
+// HasParticipant reports whether id takes part in the conversation.
+func (conversation *Conversation) HasParticipant(id uuid.UUID) bool {
+	return conversation.ParticipantA == id || conversation.ParticipantB == id
+}
+
+// Other returns the participant that is not id.
+func (conversation *Conversation) Other(id uuid.UUID) uuid.UUID {
+	if conversation.ParticipantA == id {
+		return conversation.ParticipantB
+	}
+	return conversation.ParticipantA
+}
+
+func (conversation *Conversation) BeforeCreate(tx *gorm.DB) error {
+	if conversation.ID == uuid.Nil {
+		conversation.ID = uuid.New()
+	}
+	return nil
+}
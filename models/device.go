@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Device platforms.
+const (
+	PlatformAndroid = "android" // Delivered through FCM
+	PlatformIOS     = "ios"     // Delivered through APNs
+)
+
+// DeviceToken is a phone registered by a user to receive push notifications.
+type DeviceToken struct {
+	ID         uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null;index" json:"userId"`
+	User       *User     `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Platform   string    `gorm:"not null" json:"platform"`          // android or ios
+	Token      string    `gorm:"not null;uniqueIndex" json:"token"` // FCM registration token or APNs device token
+	LastSeenAt time.Time `json:"lastSeenAt"`                        // Last time the app registered the token
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// NotificationPreference holds which push notifications a user wants. Users
+// without a row get every notification.
+type NotificationPreference struct {
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey" json:"userId"`
+	User      *User     `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Arrivals  bool      `gorm:"not null;default:true" json:"arrivals"`
+	Messages  bool      `gorm:"not null;default:true" json:"messages"`
+	Calendar  bool      `gorm:"not null;default:true" json:"calendar"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// BeforeCreate hook to generate a UUID before creating a new device token
+func (d *DeviceToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return
+}
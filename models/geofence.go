@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Geofence kinds.
+const (
+	GeofenceSchool = "school"
+	GeofenceHome   = "home"
+)
+
+// Geofence statuses recorded on arrivals.
+const (
+	GeofenceInside        = "inside"
+	GeofenceOutside       = "outside"
+	GeofenceNoLocation    = "no_location" // The device sent no location
+	GeofenceNotConfigured = "no_geofence" // No fence applies to the arrival
+)
+
+// Geofence is a circle around the school or a parent's home in which arrivals
+// are expected to be confirmed.
+type Geofence struct {
+	ID           uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	Kind         string     `gorm:"index;not null" json:"kind"`                      // school or home
+	ParentID     *uuid.UUID `gorm:"type:uuid;uniqueIndex" json:"parentId,omitempty"` // Set for homes; a parent has one home fence
+	Parent       *Parent    `gorm:"foreignKey:ParentID;constraint:OnDelete:CASCADE" json:"-"`
+	Name         string     `json:"name"`
+	Latitude     float64    `gorm:"not null" json:"latitude"`
+	Longitude    float64    `gorm:"not null" json:"longitude"`
+	RadiusMeters float64    `gorm:"not null" json:"radiusMeters"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// BeforeCreate hook to generate a UUID before creating a new geofence
+func (g *Geofence) BeforeCreate(tx *gorm.DB) (err error) {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return
+}
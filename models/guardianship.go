@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Relations a guardian can have to a student.
+const (
+	RelationMother           = "mother"
+	RelationFather           = "father"
+	RelationGuardian         = "guardian"
+	RelationGrandparent      = "grandparent"
+	RelationEmergencyContact = "emergency_contact"
+	RelationOther            = "other"
+)
+
+// ValidRelation reports whether relation is one of the known relations.
+func ValidRelation(relation string) bool {
+	switch relation {
+	case RelationMother, RelationFather, RelationGuardian, RelationGrandparent, RelationEmergencyContact, RelationOther:
+		return true
+	}
+	return false
+}
+
+// Guardianship links a parent to a student they are responsible for.
+type Guardianship struct {
+	ID         uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	ParentID   uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_guardianships_parent_student" json:"parentId"`
+	StudentID  uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_guardianships_parent_student;index" json:"studentId"`
+	Parent     *Parent   `gorm:"foreignKey:ParentID;constraint:OnDelete:CASCADE" json:"parent,omitempty"`
+	Student    *Student  `gorm:"foreignKey:StudentID;constraint:OnDelete:CASCADE" json:"student,omitempty"`
+	Relation   string    `gorm:"not null;default:guardian" json:"relation"` // mother, father, guardian, grandparent, emergency_contact or other
+	HasCustody bool      `gorm:"not null;default:false" json:"hasCustody"`
+	CanPickup  bool      `gorm:"not null;default:false" json:"canPickup"` // May collect the student at dismissal
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// BeforeCreate hook to generate a UUID before creating a new guardianship
+func (g *Guardianship) BeforeCreate(tx *gorm.DB) (err error) {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return
+}
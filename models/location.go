@@ -13,6 +13,11 @@ type HomeArrival struct {
 	StudentID string    `json:"student_id"`  // String reference to Student's UUID
 	ParentID  string    `json:"parent_id"`   // String reference to Parent's UUID
 	Confirmed bool      `gorm:"default:false" json:"confirmed"` // Confirmation by parent
+	Latitude       *float64  `json:"latitude,omitempty"`  // Device location when the arrival was submitted
+	Longitude      *float64  `json:"longitude,omitempty"`
+	Accuracy       *float64  `json:"accuracy,omitempty"` // Meters
+	GeofenceStatus string    `gorm:"index" json:"geofenceStatus,omitempty"` // inside, outside, no_location or no_geofence
+	GeofenceDistance *float64 `json:"geofenceDistance,omitempty"` // Meters from the nearest fence's center
 	CreatedAt time.Time `json:"created_at"`                    // Auto-filled on creation, indicating arrival time
 	UpdatedAt time.Time `json:"updated_at"`                    // Auto-updated on modification
 }
@@ -22,6 +27,11 @@ type SchoolArrival struct {
 	StudentID string    `json:"student_id"`  // String reference to Student's UUID
 	StaffID  string    `json:"staff_id"`   // String reference to Parent's UUID
 	Confirmed bool      `gorm:"default:false" json:"confirmed"` // Confirmation by parent
+	Latitude       *float64  `json:"latitude,omitempty"`  // Device location when the arrival was submitted
+	Longitude      *float64  `json:"longitude,omitempty"`
+	Accuracy       *float64  `json:"accuracy,omitempty"` // Meters
+	GeofenceStatus string    `gorm:"index" json:"geofenceStatus,omitempty"` // inside, outside, no_location or no_geofence
+	GeofenceDistance *float64 `json:"geofenceDistance,omitempty"` // Meters from the nearest fence's center
 	CreatedAt time.Time `json:"created_at"`                    // Auto-filled on creation, indicating arrival time
 	UpdatedAt time.Time `json:"updated_at"`                    // Auto-updated on modification
 }
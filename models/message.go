@@ -7,15 +7,25 @@ import (
 	"gorm.io/gorm"
 )
 
+// Message statuses, from the receiver's point of view.
+const (
+	MessageUnread = "unread"
+	MessageRead   = "read"
+)
+
 type Message struct {
-	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
-	Content    string    `gorm:"not null" json:"content"`
-	SenderID   uuid.UUID `gorm:"type:uuid;not null" json:"sender_id"`
-	ReceiverID uuid.UUID `gorm:"type:uuid;not null" json:"receiver_id"`
-	Status     string    `gorm:"default:'unread'" json:"status"` // unread, read, deleted
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
-	DeletedAt  gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	ID             uuid.UUID      `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	ConversationID *uuid.UUID     `gorm:"type:uuid;index" json:"conversation_id"`
+	Content        string         `gorm:"not null" json:"content"`
+	SenderID       uuid.UUID      `gorm:"type:uuid;not null;index" json:"sender_id"`
+	ReceiverID     uuid.UUID      `gorm:"type:uuid;not null;index" json:"receiver_id"`
+	Status         string         `gorm:"default:'unread'" json:"status"` // unread or read
+	ReadAt         *time.Time     `json:"read_at"`                        // When the receiver first read it
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+
+	Conversation *Conversation `gorm:"foreignKey:ConversationID;constraint:OnDelete:CASCADE" json:"-"`
 }
 
 // BeforeCreate will set a UUID rather than numeric ID.
@@ -24,4 +34,4 @@ func (message *Message) BeforeCreate(tx *gorm.DB) error {
 		message.ID = uuid.New()
 	}
 	return nil
-}
\ No newline at end of file
+}
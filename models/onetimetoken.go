@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// One-time token purposes.
+const (
+	TokenPurposeInvitation    = "invitation"
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeMFAChallenge  = "mfa_challenge"
+)
+
+// OneTimeToken is a single-use, expiring token sent by email for invitations
+// and password resets, or handed out by Login as a two-factor challenge.
+// Only the SHA-256 hash of the token is stored.
+type OneTimeToken struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	UserID    string     `gorm:"index;not null" json:"userId"` // User UUID
+	UserType  string     `gorm:"not null" json:"userType"`     // Profile to sign in as: staff or parent
+	Purpose   string     `gorm:"not null" json:"purpose"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// BeforeCreate hook to generate a UUID before creating a new one-time token
+func (t *OneTimeToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return
+}
@@ -4,7 +4,6 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/lib/pq"
 	"gorm.io/gorm"
 )
 
@@ -20,8 +19,6 @@ type Parent struct {
 	Address         string         `json:"address"`
 	Gender          *string        `json:"gender,omitempty"` // Optional field
 	Position        string    `json:"position"`                // Can be teacher, admin, or maintenance
-	Password        string    	     `json:"password"`
-	Supervise       *pq.StringArray `gorm:"type:text[];column:supervise" json:"supervise"` // Array of children's IDs
 	CreatedAt       time.Time      `json:"createdAt"`         // Auto-filled on creation
 	UpdatedAt       time.Time      `json:"updatedAt"`         // Auto-updated on modification
 }
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AuthorizedPickup is an adult other than a guardian, such as a grandparent
+// or nanny, whom a parent allows to collect a student. The photo lets gate
+// staff recognize them.
+type AuthorizedPickup struct {
+	ID               uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	StudentID        uuid.UUID  `gorm:"type:uuid;index;not null" json:"studentId"`
+	Student          *Student   `gorm:"foreignKey:StudentID;constraint:OnDelete:CASCADE" json:"-"`
+	AddedByParentID  *uuid.UUID `gorm:"type:uuid" json:"addedByParentId,omitempty"` // Empty when added by an admin
+	AddedByParent    *Parent    `gorm:"foreignKey:AddedByParentID;constraint:OnDelete:SET NULL" json:"-"`
+	FirstName        string     `gorm:"not null" json:"firstName"`
+	LastName         string     `gorm:"not null" json:"lastName"`
+	Relation         string     `json:"relation"` // Free text, e.g. grandparent or nanny
+	PhoneNumber      string     `json:"phoneNumber"`
+	Photo            []byte     `gorm:"type:bytea" json:"-"`
+	PhotoContentType string     `json:"photoContentType,omitempty"`
+	ValidFrom        *time.Time `json:"validFrom,omitempty"`  // Open-ended when empty
+	ValidUntil       *time.Time `json:"validUntil,omitempty"` // Open-ended when empty
+	RevokedAt        *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
+}
+
+// ActiveOn reports whether the person may collect the student at t.
+func (p *AuthorizedPickup) ActiveOn(t time.Time) bool {
+	if p.RevokedAt != nil {
+		return false
+	}
+	if p.ValidFrom != nil && t.Before(*p.ValidFrom) {
+		return false
+	}
+	if p.ValidUntil != nil && t.After(*p.ValidUntil) {
+		return false
+	}
+	return true
+}
+
+// PickupCode is a one-time code a parent issues for collecting a student on
+// one day, either themselves or through an authorized pickup person. Only
+// the SHA-256 hash of the code is stored.
+type PickupCode struct {
+	ID                 uuid.UUID         `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	StudentID          uuid.UUID         `gorm:"type:uuid;index;not null" json:"studentId"`
+	Student            *Student          `gorm:"foreignKey:StudentID;constraint:OnDelete:CASCADE" json:"student,omitempty"`
+	IssuedByParentID   uuid.UUID         `gorm:"type:uuid;not null" json:"issuedByParentId"`
+	IssuedByParent     *Parent           `gorm:"foreignKey:IssuedByParentID;constraint:OnDelete:CASCADE" json:"issuedByParent,omitempty"`
+	AuthorizedPickupID *uuid.UUID        `gorm:"type:uuid" json:"authorizedPickupId,omitempty"` // Empty when the parent collects in person
+	AuthorizedPickup   *AuthorizedPickup `gorm:"foreignKey:AuthorizedPickupID;constraint:OnDelete:CASCADE" json:"authorizedPickup,omitempty"`
+	CodeHash           string            `gorm:"uniqueIndex;not null" json:"-"`
+	ValidOn            string            `gorm:"not null" json:"validOn"` // YYYY-MM-DD
+	ExpiresAt          time.Time         `json:"expiresAt"`
+	UsedAt             *time.Time        `json:"usedAt,omitempty"`
+	CreatedAt          time.Time         `json:"createdAt"`
+}
+
+// Dismissal records that a student was handed over at the gate after staff
+// verified a pickup code.
+type Dismissal struct {
+	ID                 uuid.UUID   `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	StudentID          uuid.UUID   `gorm:"type:uuid;index;not null" json:"studentId"`
+	Student            *Student    `gorm:"foreignKey:StudentID;constraint:OnDelete:CASCADE" json:"-"`
+	StaffID            uuid.UUID   `gorm:"type:uuid;not null" json:"staffId"` // Staff who verified the code
+	PickupCodeID       uuid.UUID   `gorm:"type:uuid;uniqueIndex;not null" json:"pickupCodeId"`
+	PickupCode         *PickupCode `gorm:"foreignKey:PickupCodeID;constraint:OnDelete:CASCADE" json:"-"`
+	ParentID           uuid.UUID   `gorm:"type:uuid;not null" json:"parentId"`            // Parent who issued the code
+	AuthorizedPickupID *uuid.UUID  `gorm:"type:uuid" json:"authorizedPickupId,omitempty"` // Person who collected, when not the parent
+	CreatedAt          time.Time   `json:"createdAt"`
+}
+
+// BeforeCreate hook to generate a UUID before creating a new authorized pickup
+func (p *AuthorizedPickup) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// BeforeCreate hook to generate a UUID before creating a new pickup code
+func (c *PickupCode) BeforeCreate(tx *gorm.DB) (err error) {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return
+}
+
+// BeforeCreate hook to generate a UUID before creating a new dismissal
+func (d *Dismissal) BeforeCreate(tx *gorm.DB) (err error) {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return
+}
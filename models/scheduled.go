@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Scheduled message kinds.
+const (
+	ScheduledDirect    = "message"   // To ReceiverID
+	ScheduledBroadcast = "broadcast" // To an audience, like a Broadcast
+)
+
+// Scheduled message statuses.
+const (
+	ScheduledPending  = "pending"
+	ScheduledSent     = "sent"
+	ScheduledCanceled = "canceled"
+	ScheduledFailed   = "failed"
+)
+
+// ScheduledMessage is a message or broadcast to send at SendAt. Its content
+// is rendered when it is sent, so placeholders reflect the roster of that
+// moment.
+type ScheduledMessage struct {
+	ID            uuid.UUID   `gorm:"type:uuid;primaryKey" json:"id"`
+	SenderID      uuid.UUID   `gorm:"type:uuid;not null;index" json:"sender_id"`
+	Kind          string      `gorm:"not null" json:"kind"` // message or broadcast
+	Content       string      `gorm:"not null" json:"content"`
+	TemplateID    *uuid.UUID  `gorm:"type:uuid" json:"template_id,omitempty"`
+	ReceiverID    *uuid.UUID  `gorm:"type:uuid" json:"receiver_id,omitempty"` // For messages
+	StudentID     *uuid.UUID  `gorm:"type:uuid" json:"student_id,omitempty"`  // Fills the placeholders of messages
+	Audience      string      `json:"audience,omitempty"`                     // For broadcasts
+	Grade         string      `json:"grade,omitempty"`
+	Position      string      `json:"position,omitempty"`
+	StudentIDs    []uuid.UUID `gorm:"type:jsonb;serializer:json" json:"student_ids,omitempty"`
+	Date          string      `json:"date,omitempty"` // YYYY-MM-DD for {{date}}, the sending day when empty
+	SendAt        time.Time   `gorm:"not null;index" json:"send_at"`
+	Status        string      `gorm:"not null;default:'pending';index" json:"status"`
+	Attempts      int         `json:"attempts"`
+	NextAttemptAt *time.Time  `gorm:"index" json:"next_attempt_at,omitempty"` // Not retried before then; nil when due
+	LastError     string      `json:"last_error,omitempty"`
+	SentAt        *time.Time  `json:"sent_at"`
+	MessageID     *uuid.UUID  `gorm:"type:uuid" json:"message_id,omitempty"`
+	BroadcastID   *uuid.UUID  `gorm:"type:uuid" json:"broadcast_id,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+}
+
+func (scheduled *ScheduledMessage) BeforeCreate(tx *gorm.DB) error {
+	if scheduled.ID == uuid.Nil {
+		scheduled.ID = uuid.New()
+	}
+	return nil
+}
@@ -17,7 +17,6 @@ type Staff struct {
 	DateOfBirth     string    `json:"dateOfBirth"`
 	Address         string    `json:"address"`
 	Gender          *string   `json:"gender,omitempty"`        // Optional field
-	Password        string    `json:"password"`
 	Position        string    `json:"position"`                // Can be teacher, admin, or maintenance
 	SuperviseGrade  string    `json:"superviseGrade"`           // The grade the staff supervises
 	CreatedAt       time.Time `json:"createdAt"`                // Auto-filled on creation
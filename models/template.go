@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MessageTemplate is reusable message text staff share. Its body may hold
+// placeholders such as {{student_first_name}} that are filled in per
+// recipient when it is sent.
+type MessageTemplate struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	OwnerID   uuid.UUID `gorm:"type:uuid;not null;index" json:"owner_id"` // Staff who created it
+	Name      string    `gorm:"not null" json:"name"`
+	Body      string    `gorm:"not null" json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (template *MessageTemplate) BeforeCreate(tx *gorm.DB) error {
+	if template.ID == uuid.Nil {
+		template.ID = uuid.New()
+	}
+	return nil
+}
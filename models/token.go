@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RefreshToken is a rotating refresh token. Only the SHA-256 hash of the
+// token is stored, and every token obtained by rotation shares the FamilyID
+// of the login that started the chain.
+type RefreshToken struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	FamilyID  uuid.UUID  `gorm:"type:uuid;index;not null" json:"familyId"`
+	UserID    string     `gorm:"index;not null" json:"userId"`  // User UUID
+	ProfileID string     `gorm:"index" json:"profileId"`        // Staff or Parent UUID the token signs in as
+	UserType  string     `gorm:"not null" json:"userType"`      // staff, parent or admin
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the token
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`    // Set once the token has been rotated
+	RevokedAt *time.Time `json:"revokedAt,omitempty"` // Set on logout or reuse detection
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// RevokedToken blocks an access token (by its jti) or every access token of a
+// refresh token family until ExpiresAt.
+type RevokedToken struct {
+	ID        string    `gorm:"primaryKey" json:"id"` // jti or refresh token family ID
+	ExpiresAt time.Time `gorm:"index;not null" json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// BeforeCreate hook to generate a UUID before creating a new refresh token
+func (t *RefreshToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return
+}
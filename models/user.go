@@ -0,0 +1,65 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// User holds the login credentials of a person. A teacher who is also a
+// parent has one User linked to both their Staff and Parent profiles, so one
+// email always has exactly one password.
+type User struct {
+	ID           uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	Email        string     `gorm:"not null;uniqueIndex:idx_users_email_lower,expression:lower(email)" json:"email"` // Stored lowercased
+	Password     string     `json:"-"`                                                                               // bcrypt hash; empty until the user sets one
+	StaffID      *uuid.UUID `gorm:"type:uuid;uniqueIndex" json:"staffId,omitempty"`
+	ParentID     *uuid.UUID `gorm:"type:uuid;uniqueIndex" json:"parentId,omitempty"`
+	Staff        *Staff     `gorm:"foreignKey:StaffID;constraint:OnDelete:SET NULL" json:"-"`
+	Parent       *Parent    `gorm:"foreignKey:ParentID;constraint:OnDelete:SET NULL" json:"-"`
+	TOTPSecret   string     `json:"-"` // Set on enrollment, used once the first code is confirmed
+	TOTPEnabled  bool       `gorm:"not null;default:false" json:"totpEnabled"`
+	TOTPLastStep int64      `gorm:"not null;default:0" json:"-"` // Last accepted time step, so codes cannot be replayed
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// NormalizeEmail lowercases and trims an email so lookups are case-insensitive.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// BeforeCreate hook to generate a UUID before creating a new user
+func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	return
+}
+
+// BeforeSave hook to keep the email normalized
+func (u *User) BeforeSave(tx *gorm.DB) (err error) {
+	u.Email = NormalizeEmail(u.Email)
+	return
+}
+
+// RecoveryCode is a single-use code that replaces a TOTP code when the user
+// has lost their authenticator. Only the SHA-256 hash of the code is stored.
+type RecoveryCode struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;index;not null" json:"userId"`
+	User      *User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	CodeHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// BeforeCreate hook to generate a UUID before creating a new recovery code
+func (c *RecoveryCode) BeforeCreate(tx *gorm.DB) (err error) {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return
+}
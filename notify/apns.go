@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	apnsProduction = "https://api.push.apple.com"
+	apnsSandbox    = "https://api.sandbox.push.apple.com"
+	// apnsTokenLifetime is how long a provider token is reused; Apple
+	// rejects tokens older than an hour.
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNsNotifier sends notifications through APNs with token-based
+// authentication. Go's HTTP client negotiates the HTTP/2 APNs requires.
+type APNsNotifier struct {
+	keyID  string
+	teamID string
+	topic  string // The app's bundle ID
+	host   string
+	key    *ecdsa.PrivateKey
+	client *http.Client
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// NewAPNsNotifier builds an APNs notifier from a .p8 signing key.
+func NewAPNsNotifier(key []byte, keyID, teamID, topic string, sandbox bool) (*APNsNotifier, error) {
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, errors.New("APNs needs APNS_KEY_ID, APNS_TEAM_ID and APNS_TOPIC")
+	}
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("APNs key must be PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing APNs key: %w", err)
+	}
+	ecKey, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("APNs key must be an ECDSA key")
+	}
+
+	host := apnsProduction
+	if sandbox {
+		host = apnsSandbox
+	}
+	return &APNsNotifier{
+		keyID:  keyID,
+		teamID: teamID,
+		topic:  topic,
+		host:   host,
+		key:    ecKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (a *APNsNotifier) Send(ctx context.Context, n Notification) error {
+	token, err := a.providerToken()
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": n.Title, "body": n.Body},
+			"sound": "default",
+		},
+	}
+	for key, value := range n.Data {
+		if key != "aps" {
+			payload[key] = value
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.host+"/3/device/"+url.PathEscape(n.Token), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var reason struct {
+		Reason string `json:"reason"`
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	json.Unmarshal(detail, &reason)
+	switch {
+	case resp.StatusCode == http.StatusGone, reason.Reason == "BadDeviceToken", reason.Reason == "Unregistered":
+		return fmt.Errorf("apns: %w", ErrInvalidToken)
+	case reason.Reason == "ExpiredProviderToken":
+		a.mu.Lock()
+		a.token = ""
+		a.mu.Unlock()
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		// BadTopic, PayloadTooLarge and the like fail the same way every time
+		return fmt.Errorf("apns: %s: %s: %w", resp.Status, reason.Reason, ErrRejected)
+	}
+	return fmt.Errorf("apns: %s: %s", resp.Status, reason.Reason)
+}
+
+// providerToken returns the signed provider token, renewing it before APNs
+// considers it expired.
+func (a *APNsNotifier) providerToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && time.Since(a.issuedAt) < apnsTokenLifetime {
+		return a.token, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.teamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = a.keyID
+	signed, err := token.SignedString(a.key)
+	if err != nil {
+		return "", err
+	}
+	a.token, a.issuedAt = signed, now
+	return signed, nil
+}
@@ -0,0 +1,258 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/events"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultAttempts is how many times a notification is tried.
+	DefaultAttempts = 5
+	// DefaultBaseDelay is the wait after the first failure; it doubles with
+	// every further attempt.
+	DefaultBaseDelay = time.Second
+	// dispatchWorkers is how many notifications are sent concurrently.
+	dispatchWorkers = 4
+	// dispatchQueueSize bounds the notifications waiting to be sent.
+	dispatchQueueSize = 1000
+)
+
+// Default is the dispatcher the API notifies through. It is nil, and
+// notifications are skipped, until main sets it.
+var Default *Dispatcher
+
+// preferenceColumns maps categories to their notification_preferences column.
+var preferenceColumns = map[string]string{
+	CategoryArrivals: "arrivals",
+	CategoryMessages: "messages",
+	CategoryCalendar: "calendar",
+}
+
+// request is a notification for a set of users.
+type request struct {
+	UserIDs    []uuid.UUID // User IDs
+	ProfileIDs []uuid.UUID // Staff or parent IDs, for callers that only have those
+	Everyone   bool
+	Category   string
+	Title      string
+	Body       string
+	Data       map[string]string
+}
+
+// Dispatcher sends notifications to users' devices in the background,
+// respecting their preferences and retrying failed deliveries with backoff.
+type Dispatcher struct {
+	db        *gorm.DB
+	notifier  Notifier
+	attempts  int
+	baseDelay time.Duration
+	requests  chan request
+	sends     chan Notification
+}
+
+// NewDispatcher returns a dispatcher delivering through notifier.
+func NewDispatcher(db *gorm.DB, notifier Notifier) *Dispatcher {
+	return &Dispatcher{
+		db:        db,
+		notifier:  notifier,
+		attempts:  DefaultAttempts,
+		baseDelay: DefaultBaseDelay,
+		requests:  make(chan request, dispatchQueueSize),
+		sends:     make(chan Notification, dispatchQueueSize),
+	}
+}
+
+// Start runs the dispatcher until ctx is done: it sends queued notifications
+// and notifies guardians when their child is confirmed at school.
+func (d *Dispatcher) Start(ctx context.Context) {
+	go d.resolve(ctx)
+	for i := 0; i < dispatchWorkers; i++ {
+		go d.send(ctx)
+	}
+	go d.watchArrivals(ctx)
+}
+
+// NotifyUsers queues a notification in category for the users' devices.
+func (d *Dispatcher) NotifyUsers(userIDs []uuid.UUID, category, title, body string, data map[string]string) {
+	d.enqueue(request{UserIDs: userIDs, Category: category, Title: title, Body: body, Data: data})
+}
+
+// NotifyProfiles is NotifyUsers for the users linked to staff or parent
+// profiles.
+func (d *Dispatcher) NotifyProfiles(profileIDs []uuid.UUID, category, title, body string, data map[string]string) {
+	d.enqueue(request{ProfileIDs: profileIDs, Category: category, Title: title, Body: body, Data: data})
+}
+
+// NotifyEveryone queues a notification in category for every device.
+func (d *Dispatcher) NotifyEveryone(category, title, body string, data map[string]string) {
+	d.enqueue(request{Everyone: true, Category: category, Title: title, Body: body, Data: data})
+}
+
+func (d *Dispatcher) enqueue(req request) {
+	if d == nil {
+		return
+	}
+	select {
+	case d.requests <- req:
+	default:
+		log.Printf("Notification queue full, dropping %q", req.Title)
+	}
+}
+
+// resolve turns requests into one notification per device of the users who
+// want the category.
+func (d *Dispatcher) resolve(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-d.requests:
+			tokens, err := d.devices(req)
+			if err != nil {
+				log.Printf("Error loading devices for notification %q: %v", req.Title, err)
+				continue
+			}
+			for _, token := range tokens {
+				n := Notification{Token: token.Token, Platform: token.Platform, Title: req.Title, Body: req.Body, Data: req.Data}
+				select {
+				case d.sends <- n:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// devices returns the device tokens a request goes to.
+func (d *Dispatcher) devices(req request) ([]models.DeviceToken, error) {
+	column, ok := preferenceColumns[req.Category]
+	if !ok {
+		return nil, fmt.Errorf("unknown notification category %q", req.Category)
+	}
+	query := d.db.Model(&models.DeviceToken{}).
+		Joins("LEFT JOIN notification_preferences p ON p.user_id = device_tokens.user_id").
+		Where("COALESCE(p." + column + ", true)")
+	if !req.Everyone {
+		if len(req.UserIDs) == 0 && len(req.ProfileIDs) == 0 {
+			return nil, nil
+		}
+		users := d.db.Model(&models.User{}).Select("id").Where("id IN ?", req.UserIDs)
+		if len(req.ProfileIDs) > 0 {
+			users = users.Or("staff_id IN ? OR parent_id IN ?", req.ProfileIDs, req.ProfileIDs)
+		}
+		query = query.Where("device_tokens.user_id IN (?)", users)
+	}
+	var tokens []models.DeviceToken
+	err := query.Select("device_tokens.*").Find(&tokens).Error
+	return tokens, err
+}
+
+// send delivers queued notifications, deleting tokens the push service no
+// longer knows.
+func (d *Dispatcher) send(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-d.sends:
+			err := SendWithRetry(ctx, d.notifier, n, d.attempts, d.baseDelay)
+			if errors.Is(err, ErrInvalidToken) {
+				if err := d.db.Where("token = ?", n.Token).Delete(&models.DeviceToken{}).Error; err != nil {
+					log.Printf("Error deleting invalid device token: %v", err)
+				}
+			} else if err != nil {
+				log.Printf("Error sending notification %q: %v", n.Title, err)
+			}
+		}
+	}
+}
+
+// SendWithRetry sends n, retrying failures up to attempts times in total with
+// exponential backoff and jitter. Invalid tokens and rejected requests are not
+// retried.
+func SendWithRetry(ctx context.Context, notifier Notifier, n Notification, attempts int, baseDelay time.Duration) error {
+	var err error
+	delay := baseDelay
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = notifier.Send(ctx, n); err == nil || errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrRejected) {
+			return err
+		}
+		if attempt == attempts {
+			break
+		}
+		// Wait between half and all of the delay so retries spread out
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+	return fmt.Errorf("after %d attempts: %w", attempts, err)
+}
+
+// watchArrivals notifies a student's guardians when staff confirm their
+// arrival at school. It resumes from the last event it handled when the hub
+// drops it for falling behind.
+func (d *Dispatcher) watchArrivals(ctx context.Context) {
+	isConfirmation := func(e events.Event) bool { return e.Type == events.SchoolArrivalConfirmed }
+	lastEventID := ""
+	for {
+		sub, replay, _ := events.Default.Subscribe(isConfirmation, lastEventID)
+		for _, e := range replay {
+			d.arrivalConfirmed(e)
+			lastEventID = e.ID
+		}
+		for open := true; open; {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+				return
+			case e, ok := <-sub.Events():
+				if !ok {
+					open = false
+					break
+				}
+				d.arrivalConfirmed(e)
+				lastEventID = e.ID
+			}
+		}
+	}
+}
+
+// arrivalConfirmed notifies the guardians of the student in e.
+func (d *Dispatcher) arrivalConfirmed(e events.Event) {
+	studentID, err := uuid.Parse(e.StudentID)
+	if err != nil {
+		return
+	}
+	var student models.Student
+	if err := d.db.Where("id = ?", studentID).First(&student).Error; err != nil {
+		log.Printf("Error loading student %s for arrival notification: %v", studentID, err)
+		return
+	}
+	var parentIDs []uuid.UUID
+	if err := d.db.Model(&models.Guardianship{}).Where("student_id = ?", studentID).
+		Pluck("parent_id", &parentIDs).Error; err != nil {
+		log.Printf("Error loading guardians of student %s: %v", studentID, err)
+		return
+	}
+
+	d.NotifyProfiles(parentIDs, CategoryArrivals,
+		fmt.Sprintf("%s arrived at school", student.FirstName),
+		fmt.Sprintf("%s %s was confirmed at school at %s.", student.FirstName, student.LastName,
+			e.Time.In(helpers.SchoolLocation).Format("15:04")),
+		map[string]string{"type": e.Type, "studentId": e.StudentID, "eventId": e.ID})
+}
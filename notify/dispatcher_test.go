@@ -0,0 +1,157 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// waitForSent waits until fake has delivered n notifications.
+func waitForSent(t *testing.T, fake *FakeNotifier, n int) []Notification {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if sent := fake.Sent(); len(sent) >= n {
+			return sent
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("delivered %d notifications, want %d", len(fake.Sent()), n)
+	return nil
+}
+
+func TestDispatcherRetriesQueuedNotifications(t *testing.T) {
+	fake := &FakeNotifier{Fail: failTimes(2, errors.New("push service unavailable"))}
+	d := NewDispatcher(nil, fake)
+	d.baseDelay = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.send(ctx)
+
+	d.sends <- Notification{Token: testFCMToken, Platform: models.PlatformAndroid, Title: "Arrived"}
+	sent := waitForSent(t, fake, 1)
+	if sent[0].Title != "Arrived" {
+		t.Errorf("delivered %+v", sent[0])
+	}
+}
+
+func TestNilDispatcherSkipsNotifications(t *testing.T) {
+	var d *Dispatcher
+	d.NotifyEveryone(CategoryMessages, "title", "body", nil)
+	d.NotifyUsers([]uuid.UUID{uuid.New()}, CategoryMessages, "title", "body", nil)
+}
+
+func TestDispatcherDropsWhenQueueIsFull(t *testing.T) {
+	d := NewDispatcher(nil, &FakeNotifier{})
+	for i := 0; i < dispatchQueueSize+10; i++ {
+		d.NotifyEveryone(CategoryMessages, "title", "body", nil)
+	}
+	if len(d.requests) != dispatchQueueSize {
+		t.Errorf("queued %d requests, want %d", len(d.requests), dispatchQueueSize)
+	}
+}
+
+// testDB returns a transaction on the database named by TEST_DATABASE_URL
+// that is rolled back when the test ends. Tests needing it are skipped when
+// the variable is not set.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tx := db.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+	if err := tx.AutoMigrate(&models.User{}, &models.DeviceToken{}, &models.NotificationPreference{}); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestDispatcherDeliversByPreferenceAndDropsInvalidTokens(t *testing.T) {
+	db := testDB(t)
+
+	wants := models.User{Email: "wants-" + uuid.NewString() + "@example.com"}
+	muted := models.User{Email: "muted-" + uuid.NewString() + "@example.com"}
+	for _, user := range []*models.User{&wants, &muted} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Create(&models.NotificationPreference{UserID: muted.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&models.NotificationPreference{}).Where("user_id = ?", muted.ID).Update("messages", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	devices := []models.DeviceToken{
+		{UserID: wants.ID, Platform: models.PlatformAndroid, Token: testFCMToken, LastSeenAt: time.Now()},
+		{UserID: wants.ID, Platform: models.PlatformIOS, Token: testAPNsToken, LastSeenAt: time.Now()},
+		{UserID: muted.ID, Platform: models.PlatformAndroid, Token: testFCMToken + "-muted", LastSeenAt: time.Now()},
+	}
+	if err := db.Create(&devices).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(db, &FakeNotifier{})
+	tokens, err := d.devices(request{UserIDs: []uuid.UUID{wants.ID, muted.ID}, Category: CategoryMessages})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("resolved %d devices, want the 2 devices of the user who wants messages", len(tokens))
+	}
+	for _, token := range tokens {
+		if token.UserID != wants.ID {
+			t.Errorf("resolved a device of a user who turned messages off")
+		}
+	}
+	if tokens, err := d.devices(request{ProfileIDs: []uuid.UUID{uuid.New()}, Category: CategoryMessages}); err != nil || len(tokens) != 0 {
+		t.Errorf("devices of an unknown profile = %d, %v", len(tokens), err)
+	}
+
+	// The iOS token is no longer known to the push service. One worker sends
+	// in order, so once the last notification is delivered the token is gone.
+	fake := &FakeNotifier{Fail: func(n Notification) error {
+		if n.Platform == models.PlatformIOS {
+			return ErrInvalidToken
+		}
+		return nil
+	}}
+	d = NewDispatcher(db, fake)
+	d.baseDelay = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.send(ctx)
+
+	d.sends <- Notification{Token: testAPNsToken, Platform: models.PlatformIOS, Title: "New message"}
+	d.sends <- Notification{Token: testFCMToken, Platform: models.PlatformAndroid, Title: "New message"}
+	waitForSent(t, fake, 1)
+	cancel()
+
+	var count int64
+	if err := db.Model(&models.DeviceToken{}).Where("token = ?", testAPNsToken).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("invalid device token was not deleted")
+	}
+	if err := db.Model(&models.DeviceToken{}).Where("token = ?", testFCMToken).Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("valid device token was deleted: %d, %v", count, err)
+	}
+}
@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"context"
+	"sync"
+)
+
+// FakeNotifier records notifications in memory instead of sending them, for
+// tests.
+type FakeNotifier struct {
+	// Fail, when set, decides the error returned for each notification,
+	// so tests can simulate push service failures.
+	Fail func(n Notification) error
+
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (f *FakeNotifier) Send(ctx context.Context, n Notification) error {
+	if f.Fail != nil {
+		if err := f.Fail(n); err != nil {
+			return err
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, n)
+	return nil
+}
+
+// Sent returns the notifications delivered so far.
+func (f *FakeNotifier) Sent() []Notification {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Notification(nil), f.sent...)
+}
+
+// Reset forgets the delivered notifications.
+func (f *FakeNotifier) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = nil
+}
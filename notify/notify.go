@@ -0,0 +1,157 @@
+// Package notify sends push notifications to the phones users registered,
+// through Firebase Cloud Messaging for Android and APNs for iOS.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/mineracail/guardApi/models"
+)
+
+// Notification categories users can turn off in their preferences.
+const (
+	CategoryArrivals = "arrivals"
+	CategoryMessages = "messages"
+	CategoryCalendar = "calendar"
+)
+
+// previewRunes bounds message text shown in a notification.
+const previewRunes = 100
+
+// ErrInvalidToken is returned, possibly wrapped, when the push service no
+// longer knows the device token. The token is deleted instead of retried.
+var ErrInvalidToken = errors.New("device token is no longer registered")
+
+// ErrRejected is returned, possibly wrapped, when the push service rejected
+// the request itself, such as a bad topic or argument. Sending it again
+// cannot succeed, so it is not retried.
+var ErrRejected = errors.New("push service rejected the notification")
+
+// Device token formats: APNs tokens are 32 bytes in hex, FCM registration
+// tokens a few hundred URL-safe characters.
+var (
+	apnsTokenPattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+	fcmTokenPattern  = regexp.MustCompile(`^[A-Za-z0-9_:\-]{32,512}$`)
+)
+
+// ValidToken reports whether token has the format of a device token of the
+// platform.
+func ValidToken(platform, token string) bool {
+	switch platform {
+	case models.PlatformIOS:
+		return apnsTokenPattern.MatchString(token)
+	case models.PlatformAndroid:
+		return fcmTokenPattern.MatchString(token)
+	}
+	return false
+}
+
+// Notification is a push notification for one device.
+type Notification struct {
+	Token    string
+	Platform string // models.PlatformAndroid or models.PlatformIOS
+	Title    string
+	Body     string
+	Data     map[string]string
+}
+
+// Notifier delivers push notifications. Implementations must be safe for
+// concurrent use.
+type Notifier interface {
+	Send(ctx context.Context, n Notification) error
+}
+
+// LogNotifier writes every notification to the application log instead of
+// sending it. Device tokens are shortened so the log cannot be used to push
+// to the devices.
+type LogNotifier struct{}
+
+func (LogNotifier) Send(ctx context.Context, n Notification) error {
+	log.Printf("Push to %s device %s: %s\n%s %v", n.Platform, MaskToken(n.Token), n.Title, n.Body, n.Data)
+	return nil
+}
+
+// MaskToken returns the last few characters of a device token, enough to
+// tell devices apart in logs.
+func MaskToken(token string) string {
+	const visible = 6
+	if len(token) <= visible {
+		return "…"
+	}
+	return "…" + token[len(token)-visible:]
+}
+
+// PlatformNotifier sends each notification through the notifier of its
+// device's platform.
+type PlatformNotifier struct {
+	Android Notifier
+	IOS     Notifier
+}
+
+func (p *PlatformNotifier) Send(ctx context.Context, n Notification) error {
+	var notifier Notifier
+	switch n.Platform {
+	case models.PlatformAndroid:
+		notifier = p.Android
+	case models.PlatformIOS:
+		notifier = p.IOS
+	}
+	if notifier == nil {
+		return fmt.Errorf("push to %s devices is not configured", n.Platform)
+	}
+	return notifier.Send(ctx, n)
+}
+
+// FromEnv builds the notifier selected by NOTIFIER: "log", the default, or
+// "push". Push reads FCM_CREDENTIALS_FILE for Android and
+// APNS_KEY_FILE, APNS_KEY_ID, APNS_TEAM_ID, APNS_TOPIC and APNS_SANDBOX for
+// iOS; at least one of them must be configured.
+func FromEnv() (Notifier, error) {
+	switch kind := os.Getenv("NOTIFIER"); kind {
+	case "", "log":
+		return LogNotifier{}, nil
+	case "push":
+		notifier := &PlatformNotifier{}
+		if path := os.Getenv("FCM_CREDENTIALS_FILE"); path != "" {
+			credentials, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("reading FCM_CREDENTIALS_FILE: %w", err)
+			}
+			if notifier.Android, err = NewFCMNotifier(credentials); err != nil {
+				return nil, err
+			}
+		}
+		if path := os.Getenv("APNS_KEY_FILE"); path != "" {
+			key, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("reading APNS_KEY_FILE: %w", err)
+			}
+			notifier.IOS, err = NewAPNsNotifier(key, os.Getenv("APNS_KEY_ID"), os.Getenv("APNS_TEAM_ID"),
+				os.Getenv("APNS_TOPIC"), os.Getenv("APNS_SANDBOX") == "true")
+			if err != nil {
+				return nil, err
+			}
+		}
+		if notifier.Android == nil && notifier.IOS == nil {
+			return nil, errors.New("NOTIFIER=push needs FCM_CREDENTIALS_FILE or APNS_KEY_FILE")
+		}
+		return notifier, nil
+	default:
+		return nil, fmt.Errorf("unsupported NOTIFIER %q", kind)
+	}
+}
+
+// Preview shortens text for a notification body.
+func Preview(text string) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= previewRunes {
+		return string(runes)
+	}
+	return string(runes[:previewRunes-1]) + "…"
+}
@@ -0,0 +1,168 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mineracail/guardApi/models"
+)
+
+const (
+	testAPNsToken = "a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	testFCMToken  = "fcm-token_0123456789:abcdefghijklmnopqrstuvwxyz"
+)
+
+// failTimes returns a Fail func that fails the first n sends with err.
+func failTimes(n int, err error) func(Notification) error {
+	calls := 0
+	return func(Notification) error {
+		calls++
+		if calls <= n {
+			return err
+		}
+		return nil
+	}
+}
+
+func TestSendWithRetry(t *testing.T) {
+	transient := errors.New("push service unavailable")
+	tests := []struct {
+		name     string
+		fail     func(Notification) error
+		attempts int
+		sent     int
+		err      error
+	}{
+		{"succeeds first time", nil, 3, 1, nil},
+		{"retries transient errors", failTimes(2, transient), 3, 1, nil},
+		{"gives up after all attempts", failTimes(5, transient), 3, 0, transient},
+		{"does not retry invalid tokens", failTimes(1, fmt.Errorf("apns: %w", ErrInvalidToken)), 3, 0, ErrInvalidToken},
+		{"does not retry rejected requests", failTimes(1, fmt.Errorf("fcm: %w", ErrRejected)), 3, 0, ErrRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			fake := &FakeNotifier{}
+			if tt.fail != nil {
+				fail := tt.fail
+				fake.Fail = func(n Notification) error {
+					calls++
+					return fail(n)
+				}
+			}
+			n := Notification{Token: testFCMToken, Platform: models.PlatformAndroid, Title: "Hello"}
+			err := SendWithRetry(context.Background(), fake, n, tt.attempts, time.Millisecond)
+			if !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+				t.Fatalf("SendWithRetry error = %v, want %v", err, tt.err)
+			}
+			if got := len(fake.Sent()); got != tt.sent {
+				t.Errorf("sent %d notifications, want %d", got, tt.sent)
+			}
+			if tt.err != nil && !errors.Is(tt.err, transient) && calls != 1 {
+				t.Errorf("permanent error was tried %d times, want once", calls)
+			}
+			if errors.Is(tt.err, transient) && calls != tt.attempts {
+				t.Errorf("transient error was tried %d times, want %d", calls, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestSendWithRetryStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fake := &FakeNotifier{Fail: func(Notification) error {
+		cancel()
+		return errors.New("push service unavailable")
+	}}
+	err := SendWithRetry(ctx, fake, Notification{Token: testFCMToken}, 5, time.Hour)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SendWithRetry error = %v, want context.Canceled", err)
+	}
+}
+
+func TestPlatformNotifier(t *testing.T) {
+	android, ios := &FakeNotifier{}, &FakeNotifier{}
+	notifier := &PlatformNotifier{Android: android, IOS: ios}
+	ctx := context.Background()
+
+	if err := notifier.Send(ctx, Notification{Token: testFCMToken, Platform: models.PlatformAndroid}); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Send(ctx, Notification{Token: testAPNsToken, Platform: models.PlatformIOS}); err != nil {
+		t.Fatal(err)
+	}
+	if len(android.Sent()) != 1 || len(ios.Sent()) != 1 {
+		t.Errorf("android got %d, ios got %d notifications, want 1 each", len(android.Sent()), len(ios.Sent()))
+	}
+
+	if err := (&PlatformNotifier{Android: android}).Send(ctx, Notification{Platform: models.PlatformIOS}); err == nil {
+		t.Error("sending to an unconfigured platform succeeded")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("NOTIFIER", "")
+	if notifier, err := FromEnv(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := notifier.(LogNotifier); !ok {
+		t.Errorf("default notifier is %T, want LogNotifier", notifier)
+	}
+
+	for _, kind := range []string{"fake", "unknown"} {
+		t.Setenv("NOTIFIER", kind)
+		if _, err := FromEnv(); err == nil {
+			t.Errorf("NOTIFIER=%s was accepted", kind)
+		}
+	}
+
+	t.Setenv("NOTIFIER", "push")
+	t.Setenv("FCM_CREDENTIALS_FILE", "")
+	t.Setenv("APNS_KEY_FILE", "")
+	if _, err := FromEnv(); err == nil {
+		t.Error("NOTIFIER=push without credentials was accepted")
+	}
+}
+
+func TestValidToken(t *testing.T) {
+	tests := []struct {
+		platform, token string
+		ok              bool
+	}{
+		{models.PlatformIOS, testAPNsToken, true},
+		{models.PlatformIOS, testAPNsToken[:63], false},
+		{models.PlatformIOS, strings.Repeat("z", 64), false},
+		{models.PlatformAndroid, testFCMToken, true},
+		{models.PlatformAndroid, "short", false},
+		{models.PlatformAndroid, "has spaces in it and is long enough to pass", false},
+		{"windows", testFCMToken, false},
+	}
+	for _, tt := range tests {
+		if got := ValidToken(tt.platform, tt.token); got != tt.ok {
+			t.Errorf("ValidToken(%s, %q) = %v, want %v", tt.platform, tt.token, got, tt.ok)
+		}
+	}
+}
+
+func TestMaskToken(t *testing.T) {
+	if got := MaskToken(testAPNsToken); got != "…7e8f90" {
+		t.Errorf("MaskToken = %q", got)
+	}
+	if got := MaskToken("abc"); got != "…" {
+		t.Errorf("MaskToken of a short token = %q", got)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	if got := Preview("  short  "); got != "short" {
+		t.Errorf("Preview = %q", got)
+	}
+	long := strings.Repeat("é", previewRunes+10)
+	got := []rune(Preview(long))
+	if len(got) != previewRunes || got[len(got)-1] != '…' {
+		t.Errorf("Preview of a long text has %d runes ending in %q", len(got), got[len(got)-1])
+	}
+}
@@ -0,0 +1,141 @@
+// Package pdf writes simple text-and-line PDF documents, enough for printable
+// registers, using the standard Helvetica fonts so nothing is embedded.
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page size in points.
+const (
+	PageWidth  = 595
+	PageHeight = 842
+)
+
+// Document is a PDF document built page by page in memory.
+type Document struct {
+	pages []*Page
+}
+
+// Page is one A4 page. Coordinates are in points from the bottom-left corner.
+type Page struct {
+	content bytes.Buffer
+}
+
+// New returns an empty document.
+func New() *Document {
+	return &Document{}
+}
+
+// AddPage appends a blank page to the document and returns it.
+func (d *Document) AddPage() *Page {
+	page := &Page{}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// PageCount returns the number of pages in the document.
+func (d *Document) PageCount() int {
+	return len(d.pages)
+}
+
+// Text draws s at (x, y) in Helvetica, or Helvetica-Bold when bold is set.
+// Characters outside the WinAnsi encoding are printed as '?'.
+func (p *Page) Text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(s))
+}
+
+// Line draws a line of the given width from (x1, y1) to (x2, y2).
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, y1, x2, y2)
+}
+
+// WriteTo writes the document to w.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	out := &countingWriter{w: bufio.NewWriter(w)}
+	offsets := []int64{}
+	object := func(body string) {
+		offsets = append(offsets, out.n)
+		fmt.Fprintf(out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1-4 are the catalog, page tree and fonts; each page then takes
+	// two objects, the page and its content stream.
+	const firstPage = 5
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	fmt.Fprint(out, "%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.content.Len(), page.content.String()))
+	}
+
+	xref := out.n
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	if out.err != nil {
+		return out.n, out.err
+	}
+	return out.n, out.w.Flush()
+}
+
+// escape encodes s as the body of a PDF literal string in WinAnsi.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r < 0x80:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			// Latin-1 matches WinAnsi outside 0x80-0x9f
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// countingWriter tracks the byte offsets needed by the cross-reference table
+// and keeps the first write error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
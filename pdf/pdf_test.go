@@ -0,0 +1,131 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// buildRegister lays out a small two-page register like the daily register
+// export, with names that need escaping.
+func buildRegister() *Document {
+	doc := New()
+	names := []string{"Ama (Junior) Mensah", "Kofi Boateng", `Back\slash Student`, "Zoë Ünal"}
+	for p := 0; p < 2; p++ {
+		page := doc.AddPage()
+		page.Text(40, PageHeight-40, 14, true, fmt.Sprintf("Daily register - Grade 3 (page %d)", p+1))
+		page.Line(40, PageHeight-50, PageWidth-40, PageHeight-50, 0.8)
+		for i, name := range names {
+			y := float64(PageHeight - 70 - 16*i)
+			page.Text(40, y, 9, false, strconv.Itoa(i+1))
+			page.Text(68, y, 9, false, name)
+			page.Line(40, y-5, PageWidth-40, y-5, 0.2)
+		}
+	}
+	return doc
+}
+
+func TestWriteToXrefOffsets(t *testing.T) {
+	doc := buildRegister()
+	var buf bytes.Buffer
+	n, err := doc.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	if n != int64(len(out)) {
+		t.Errorf("WriteTo returned %d bytes, wrote %d", n, len(out))
+	}
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatal("output is not framed as a PDF file")
+	}
+
+	// startxref must point at the xref table
+	match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(out)
+	if match == nil {
+		t.Fatal("no startxref found")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+
+	// Every xref entry must point at the start of its object
+	lines := strings.Split(string(out[xref:]), "\n")
+	var size int
+	if _, err := fmt.Sscanf(lines[1], "0 %d", &size); err != nil {
+		t.Fatalf("malformed xref subsection header %q", lines[1])
+	}
+	wantObjects := 4 + 2*doc.PageCount()
+	if size != wantObjects+1 {
+		t.Errorf("xref lists %d entries, want %d", size, wantObjects+1)
+	}
+	if lines[2] != "0000000000 65535 f " {
+		t.Errorf("xref entry 0 = %q", lines[2])
+	}
+	for i := 1; i < size; i++ {
+		entry := lines[2+i]
+		if len(entry) != 19 || !strings.HasSuffix(entry, " 00000 n ") {
+			t.Fatalf("malformed xref entry %d: %q", i, entry)
+		}
+		offset, _ := strconv.Atoi(entry[:10])
+		if want := fmt.Sprintf("%d 0 obj\n", i); !bytes.HasPrefix(out[offset:], []byte(want)) {
+			t.Errorf("xref entry %d points at %q, want %q", i, out[offset:offset+len(want)], want)
+		}
+	}
+	if !strings.Contains(string(out), fmt.Sprintf("trailer\n<< /Size %d /Root 1 0 R >>", size)) {
+		t.Error("trailer size does not match the xref table")
+	}
+
+	// Each content stream's /Length must match its bytes
+	streams := regexp.MustCompile(`(?s)<< /Length (\d+) >>\nstream\n(.*?)endstream`).FindAllSubmatch(out, -1)
+	if len(streams) != doc.PageCount() {
+		t.Fatalf("found %d content streams, want %d", len(streams), doc.PageCount())
+	}
+	for _, stream := range streams {
+		if length, _ := strconv.Atoi(string(stream[1])); length != len(stream[2]) {
+			t.Errorf("stream /Length %d, stream holds %d bytes", length, len(stream[2]))
+		}
+	}
+}
+
+func TestTextEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := buildRegister().WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`(Ama \(Junior\) Mensah) Tj`,
+		`(Back\\slash Student) Tj`,
+		`(Daily register - Grade 3 \(page 1\)) Tj`,
+		`(Zo\353 \334nal) Tj`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %s", want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"(a)", `\(a\)`},
+		{`a\b`, `a\\b`},
+		{`\(`, `\\\(`},
+		{"tab\there\nnewline", "tab here newline"},
+		{"café", `caf\351`},
+		{"€ 5", "? 5"},
+		{"日本", "??"},
+	}
+	for _, tt := range tests {
+		if got := escape(tt.in); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
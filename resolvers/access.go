@@ -0,0 +1,61 @@
+package resolvers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// errForbidden is returned from transactions that find the caller may not
+// perform the change.
+var errForbidden = errors.New("forbidden")
+
+// callerID returns the authenticated caller's UUID from the request context.
+func callerID(r *http.Request) uuid.UUID {
+	id, err := middleware.GetIDFromContext(r.Context())
+	if err != nil {
+		return uuid.Nil
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil
+	}
+	return parsed
+}
+
+// ParentSupervisesStudent reports whether the parent is a guardian of the student.
+func ParentSupervisesStudent(db *gorm.DB, parentID uuid.UUID, studentID string) (bool, error) {
+	var count int64
+	err := db.Model(&models.Guardianship{}).
+		Where("parent_id = ? AND student_id::text = ?", parentID, studentID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// guardianshipOf returns the parent's guardianship of the student, or nil when
+// the parent is not one of the student's guardians.
+func guardianshipOf(db *gorm.DB, parentID, studentID uuid.UUID) (*models.Guardianship, error) {
+	var guardianship models.Guardianship
+	err := db.Where("parent_id = ? AND student_id = ?", parentID, studentID).First(&guardianship).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &guardianship, nil
+}
+
+// StaffSupervisesStudent reports whether the student is in the grade the staff supervises.
+func StaffSupervisesStudent(db *gorm.DB, staffID uuid.UUID, studentID string) (bool, error) {
+	var count int64
+	err := db.Model(&models.Student{}).
+		Joins("JOIN staffs ON staffs.supervise_grade = students.grade").
+		Where("staffs.id = ? AND students.id = ? AND staffs.supervise_grade <> ''", staffID, studentID).
+		Count(&count).Error
+	return count > 0, err
+}
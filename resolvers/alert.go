@@ -0,0 +1,183 @@
+package resolvers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/alerts"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GradeCutoffInput is the payload for setting a grade's alert cutoff.
+type GradeCutoffInput struct {
+	Cutoff               string `json:"cutoff"` // HH:MM
+	EscalateAfterMinutes int    `json:"escalateAfterMinutes"`
+}
+
+// GetAlerts lists missing-child alerts, newest first, filtered by ?status=
+// and ?date=YYYY-MM-DD. Admins see every alert, guardians the alerts of their
+// students and staff those of the grade they supervise.
+func GetAlerts(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	query := db.Preload("Student").Preload("Recipients").Order("created_at DESC")
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if date := r.URL.Query().Get("date"); date != "" {
+		query = query.Where("date = ?", date)
+	}
+	query = visibleAlerts(db, r, query)
+
+	var alertList []models.MissingChildAlert
+	if err := query.Find(&alertList).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, alertList)
+}
+
+// AcknowledgeAlert records that the caller has seen the alert in {id} and is
+// following up. Admins and everyone who can see the alert may acknowledge it.
+func AcknowledgeAlert(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid alert UUID")
+		return
+	}
+
+	var alert models.MissingChildAlert
+	if err := db.Where("id = ?", id).First(&alert).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, http.StatusNotFound, "Alert not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	now := time.Now()
+	actor := requestActor(r)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.AlertRecipient{}).
+			Where("alert_id = ? AND recipient_id = ? AND acknowledged_at IS NULL", alert.ID, callerID(r)).
+			Update("acknowledged_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 && !middleware.IsAdmin(r.Context()) {
+			var count int64
+			if err := visibleAlerts(tx, r, tx.Model(&models.MissingChildAlert{}).Where("id = ?", alert.ID)).
+				Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return errForbidden
+			}
+			// Guardians and staff who were not sent the alert join its recipients
+			recipient := models.AlertRecipient{AlertID: alert.ID, RecipientID: callerID(r), RecipientType: recipientType(r), AcknowledgedAt: &now}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&recipient).Error; err != nil {
+				return err
+			}
+		}
+
+		// The first acknowledgment stops the escalation
+		return tx.Model(&models.MissingChildAlert{}).
+			Where("id = ? AND status IN ?", alert.ID, []string{models.AlertOpen, models.AlertEscalated}).
+			Updates(map[string]interface{}{
+				"status":               models.AlertAcknowledged,
+				"acknowledged_at":      now,
+				"acknowledged_by_id":   actor.ID,
+				"acknowledged_by_type": actor.Type,
+			}).Error
+	})
+	if err != nil {
+		if errors.Is(err, errForbidden) {
+			middleware.WriteForbidden(w)
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	if err := db.Preload("Student").Preload("Recipients").Where("id = ?", alert.ID).First(&alert).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, alert)
+}
+
+// visibleAlerts limits query to the alerts the caller may see, going by
+// current guardianships and grade supervision rather than by who the alert
+// was sent to when it was raised.
+func visibleAlerts(db *gorm.DB, r *http.Request, query *gorm.DB) *gorm.DB {
+	if middleware.IsAdmin(r.Context()) {
+		return query
+	}
+	if recipientType(r) == middleware.UserTypeParent {
+		return query.Where("student_id IN (?)", db.Model(&models.Guardianship{}).
+			Select("student_id").Where("parent_id = ?", callerID(r)))
+	}
+	return query.Where("student_id IN (?)", db.Model(&models.Student{}).
+		Select("students.id").
+		Joins("JOIN staffs ON staffs.supervise_grade = students.grade").
+		Where("staffs.id = ? AND staffs.supervise_grade <> ''", callerID(r)))
+}
+
+// recipientType returns the alert recipient type of the caller.
+func recipientType(r *http.Request) string {
+	userType, _ := middleware.GetUserTypeFromContext(r.Context())
+	if userType == middleware.UserTypeParent {
+		return middleware.UserTypeParent
+	}
+	return middleware.UserTypeStaff
+}
+
+// GetGradeCutoffs lists the configured alert cutoffs. Other grades use the
+// scheduler's default cutoff.
+func GetGradeCutoffs(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var cutoffs []models.GradeCutoff
+	if err := db.Order("grade").Find(&cutoffs).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, cutoffs)
+}
+
+// PutGradeCutoff sets the alert cutoff of the grade in {grade}.
+func PutGradeCutoff(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var input GradeCutoffInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if _, err := alerts.ParseCutoff(input.Cutoff); err != nil {
+		handleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if input.EscalateAfterMinutes < 0 {
+		handleError(w, http.StatusBadRequest, "escalateAfterMinutes must not be negative")
+		return
+	}
+	if input.EscalateAfterMinutes == 0 {
+		input.EscalateAfterMinutes = alerts.DefaultEscalateAfterMinutes
+	}
+
+	cutoff := models.GradeCutoff{
+		Grade:                chi.URLParam(r, "grade"),
+		Cutoff:               input.Cutoff,
+		EscalateAfterMinutes: input.EscalateAfterMinutes,
+	}
+	if err := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&cutoff).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, cutoff)
+}
@@ -0,0 +1,357 @@
+package resolvers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/models"
+	"github.com/mineracail/guardApi/storage"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxAttachmentBytes bounds the size of one attachment.
+	maxAttachmentBytes = 10 << 20
+	// maxAttachmentsPerMessage bounds how many files one message carries.
+	maxAttachmentsPerMessage = 10
+	// downloadLinkTTL is how long a download link works.
+	downloadLinkTTL = 15 * time.Minute
+)
+
+// attachmentTypes are the content types accepted, detected from the file's
+// first bytes rather than trusted from the client: photos and documents
+// such as permission slips.
+var attachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// AttachmentLink is an attachment with a link that downloads it without a
+// token until ExpiresAt.
+type AttachmentLink struct {
+	models.Attachment
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UploadAttachments attaches the multipart "file" fields to the message in
+// {id}. Only the message's sender may attach files.
+func UploadAttachments(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	message, ok := fetchAttachmentMessage(db, w, r)
+	if !ok {
+		return
+	}
+	if message.SenderID != callerID(r) {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentsPerMessage*maxAttachmentBytes+1<<20)
+	if err := r.ParseMultipartForm(maxAttachmentBytes); err != nil {
+		handleError(w, http.StatusBadRequest, "Expected a multipart form with file fields")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		handleError(w, http.StatusBadRequest, "file is required")
+		return
+	}
+
+	var existing int64
+	if err := db.Model(&models.Attachment{}).Where("message_id = ?", message.ID).Count(&existing).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if int(existing)+len(files) > maxAttachmentsPerMessage {
+		handleError(w, http.StatusBadRequest, fmt.Sprintf("A message can have at most %d attachments", maxAttachmentsPerMessage))
+		return
+	}
+
+	// Store every file before recording any, removing the stored ones if one fails
+	attachments := make([]models.Attachment, 0, len(files))
+	removeStored := func() {
+		for _, attachment := range attachments {
+			if err := storage.Blobs.Delete(context.Background(), attachment.StorageKey); err != nil {
+				log.Printf("Error removing attachment blob %s: %v", attachment.StorageKey, err)
+			}
+		}
+	}
+	for _, header := range files {
+		attachment, status, err := storeAttachment(r.Context(), message, header, callerID(r))
+		if err != nil {
+			removeStored()
+			handleError(w, status, err.Error())
+			return
+		}
+		attachments = append(attachments, *attachment)
+	}
+	if err := db.Create(&attachments).Error; err != nil {
+		removeStored()
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, attachmentLinks(attachments))
+}
+
+// GetMessageAttachments lists the attachments of the message in {id} with
+// download links, for its sender and receiver.
+func GetMessageAttachments(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	message, ok := fetchAttachmentMessage(db, w, r)
+	if !ok {
+		return
+	}
+	if !isMessageParticipant(r, message) {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	attachments := []models.Attachment{}
+	if err := db.Where("message_id = ?", message.ID).Order("created_at, id").Find(&attachments).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, attachmentLinks(attachments))
+}
+
+// GetAttachmentLink returns a fresh download link for the attachment in {id}.
+func GetAttachmentLink(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	attachment, ok := fetchAttachment(db, w, r)
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, attachmentLinks([]models.Attachment{*attachment})[0])
+}
+
+// DeleteAttachment removes the attachment in {id}. Only its uploader may.
+func DeleteAttachment(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	attachment, ok := fetchAttachment(db, w, r)
+	if !ok {
+		return
+	}
+	if attachment.UploaderID != callerID(r) {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	if err := db.Delete(attachment).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// The record is gone, so a blob left behind is only wasted space
+	if err := storage.Blobs.Delete(r.Context(), attachment.StorageKey); err != nil {
+		log.Printf("Error removing attachment blob %s: %v", attachment.StorageKey, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DownloadAttachment serves the attachment in {id} to anyone holding a
+// download link that has not expired. It is mounted without authentication
+// so links work in browsers and image tags.
+func DownloadAttachment(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid Attachment UUID")
+		return
+	}
+	query := r.URL.Query()
+	err = storage.Links.Verify(attachmentResource(id), query.Get("expires"), query.Get("signature"), time.Now())
+	if errors.Is(err, storage.ErrLinkExpired) {
+		handleError(w, http.StatusGone, err.Error())
+		return
+	}
+	if err != nil {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	var attachment models.Attachment
+	if err := db.Where("id = ?", id).First(&attachment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, http.StatusNotFound, "Attachment not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	blob, err := storage.Blobs.Open(r.Context(), attachment.StorageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		handleError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=0")
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Printf("Error sending attachment %s: %v", attachment.ID, err)
+	}
+}
+
+// storeAttachment validates an uploaded file and puts it in the blob store,
+// returning its unsaved record or the status to answer with.
+func storeAttachment(ctx context.Context, message *models.Message, header *multipart.FileHeader, uploaderID uuid.UUID) (*models.Attachment, int, error) {
+	if header.Size > maxAttachmentBytes {
+		return nil, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("%s is larger than %d MB", header.Filename, maxAttachmentBytes>>20)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, http.StatusBadRequest, fmt.Errorf("reading %s: %w", header.Filename, err)
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if !attachmentTypes[contentType] {
+		return nil, http.StatusUnsupportedMediaType,
+			fmt.Errorf("%s is not an accepted file type; send a JPEG, PNG, GIF or WebP image or a PDF", header.Filename)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	attachment := models.Attachment{
+		ID:          uuid.New(),
+		MessageID:   message.ID,
+		UploaderID:  uploaderID,
+		FileName:    attachmentFileName(header.Filename),
+		ContentType: contentType,
+		Size:        header.Size,
+	}
+	attachment.StorageKey = "attachments/" + message.ID.String() + "/" + attachment.ID.String()
+	hash := sha256.New()
+	if err := storage.Blobs.Put(ctx, attachment.StorageKey, io.TeeReader(file, hash), header.Size, contentType); err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("storing %s: %w", header.Filename, err)
+	}
+	attachment.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return &attachment, 0, nil
+}
+
+// fetchAttachmentMessage loads the message in {id}, writing an error when it
+// does not exist.
+func fetchAttachmentMessage(db *gorm.DB, w http.ResponseWriter, r *http.Request) (*models.Message, bool) {
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid Message UUID")
+		return nil, false
+	}
+	message, err := FetchMessageByUUID(db, id)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, http.StatusNotFound, "Message not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return message, true
+}
+
+// fetchAttachment loads the attachment in {id}, writing an error unless the
+// caller sent or received its message.
+func fetchAttachment(db *gorm.DB, w http.ResponseWriter, r *http.Request) (*models.Attachment, bool) {
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid Attachment UUID")
+		return nil, false
+	}
+
+	var attachment models.Attachment
+	if err := db.Where("id = ?", id).First(&attachment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, http.StatusNotFound, "Attachment not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	message, err := FetchMessageByUUID(db, attachment.MessageID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, http.StatusNotFound, "Attachment not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	if !isMessageParticipant(r, message) {
+		middleware.WriteForbidden(w)
+		return nil, false
+	}
+	return &attachment, true
+}
+
+// attachmentLinks signs a download link for each attachment.
+func attachmentLinks(attachments []models.Attachment) []AttachmentLink {
+	expires := time.Now().Add(downloadLinkTTL).Truncate(time.Second)
+	// Links point at the configured address, never at request headers
+	base := strings.TrimSuffix(middleware.AppBaseURL(), "/")
+	links := make([]AttachmentLink, len(attachments))
+	for i, attachment := range attachments {
+		query := url.Values{
+			"expires":   {strconv.FormatInt(expires.Unix(), 10)},
+			"signature": {storage.Links.Sign(attachmentResource(attachment.ID), expires)},
+		}
+		links[i] = AttachmentLink{
+			Attachment: attachment,
+			URL:        base + "/attachments/" + attachment.ID.String() + "/download?" + query.Encode(),
+			ExpiresAt:  expires,
+		}
+	}
+	return links
+}
+
+// attachmentResource is what a download link for the attachment signs.
+func attachmentResource(id uuid.UUID) string {
+	return "attachment:" + id.String()
+}
+
+// attachmentFileName keeps the base name of an uploaded file, without
+// characters that would break a Content-Disposition header.
+func attachmentFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
@@ -0,0 +1,293 @@
+package resolvers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// transitionError is returned when a student cannot move to the requested
+// attendance state from the current one.
+type transitionError struct {
+	From, To string
+}
+
+func (e *transitionError) Error() string {
+	return fmt.Sprintf("cannot move from %s to %s", e.From, e.To)
+}
+
+// attendanceActor identifies who records an attendance transition.
+type attendanceActor struct {
+	ID   string
+	Type string
+}
+
+// AttendanceRequest is the payload for recording an attendance transition.
+type AttendanceRequest struct {
+	State string `json:"state"`
+	Note  string `json:"note"`
+}
+
+// StudentAttendance is a student with their attendance state on one day.
+type StudentAttendance struct {
+	Student    models.Student        `json:"student"`
+	Attendance *models.AttendanceDay `json:"attendance"`
+}
+
+// RecordAttendance moves the student in {id} to the state in the payload for
+// today. Parents record leaving and arriving home, staff record everything
+// that happens at school. A student who left home can be recorded back home,
+// which resolves a missing-child alert.
+func RecordAttendance(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	studentID, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid student UUID")
+		return
+	}
+
+	var req AttendanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if !models.ValidAttendanceState(req.State) {
+		handleError(w, http.StatusBadRequest, "Invalid attendance state")
+		return
+	}
+
+	if _, err := FetchStudentByUUID(db, studentID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, http.StatusNotFound, "Student not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	ok, err := canRecordAttendance(db, r, studentID, req.State)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Error checking student access")
+		return
+	}
+	if !ok {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	var day *models.AttendanceDay
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		day, err = recordAttendance(tx, studentID, helpers.Today(), req.State, requestActor(r), req.Note, true)
+		return err
+	})
+	if err != nil {
+		var invalid *transitionError
+		if errors.As(err, &invalid) {
+			handleError(w, http.StatusConflict, invalid.Error())
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusOK, day)
+}
+
+// GetStudentAttendance returns the attendance state and transitions of the
+// student in {id} for ?date=YYYY-MM-DD, today by default.
+func GetStudentAttendance(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	studentID, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid student UUID")
+		return
+	}
+	date, ok := attendanceDate(w, r)
+	if !ok {
+		return
+	}
+
+	day := models.AttendanceDay{StudentID: studentID, Date: date, State: models.AttendanceNone}
+	events := []models.AttendanceEvent{}
+	err = db.Where("student_id = ? AND date = ?", studentID, date).First(&day).Error
+	if err == nil {
+		err = db.Where("attendance_day_id = ?", day.ID).Order("created_at").Find(&events).Error
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"attendance": day,
+		"events":     events,
+	})
+}
+
+// GetAttendance lists every student with their attendance state for
+// ?date=YYYY-MM-DD, optionally only those in ?grade=.
+func GetAttendance(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	date, ok := attendanceDate(w, r)
+	if !ok {
+		return
+	}
+
+	query := db.Order("last_name, first_name")
+	if grade := r.URL.Query().Get("grade"); grade != "" {
+		query = query.Where("grade = ?", grade)
+	}
+	var students []models.Student
+	if err := query.Find(&students).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ids := make([]uuid.UUID, len(students))
+	for i, student := range students {
+		ids[i] = student.ID
+	}
+	var days []models.AttendanceDay
+	if err := db.Where("date = ? AND student_id IN ?", date, ids).Find(&days).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	byStudent := make(map[uuid.UUID]*models.AttendanceDay, len(days))
+	for i := range days {
+		byStudent[days[i].StudentID] = &days[i]
+	}
+
+	// Students with nothing recorded yet are reported in the none state
+	result := make([]StudentAttendance, len(students))
+	for i, student := range students {
+		day, ok := byStudent[student.ID]
+		if !ok {
+			day = &models.AttendanceDay{StudentID: student.ID, Date: date, State: models.AttendanceNone}
+		}
+		result[i] = StudentAttendance{Student: student, Attendance: day}
+	}
+
+	respondJSON(w, http.StatusOK, result)
+}
+
+// recordAttendance moves the student's attendance on date to state and logs
+// the transition. Transitions recorded by hand may also turn a student back
+// home. It must run in a transaction: the day row is locked so concurrent
+// transitions are applied one after the other.
+func recordAttendance(tx *gorm.DB, studentID uuid.UUID, date, state string, actor attendanceActor, note string, byHand bool) (*models.AttendanceDay, error) {
+	day := models.AttendanceDay{StudentID: studentID, Date: date, State: models.AttendanceNone}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&day).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("student_id = ? AND date = ?", studentID, date).First(&day).Error; err != nil {
+		return nil, err
+	}
+
+	if !models.CanTransition(day.State, state) && !(byHand && models.CanTurnBack(day.State, state)) {
+		return nil, &transitionError{From: day.State, To: state}
+	}
+	from, now := day.State, time.Now()
+	day.State = state
+	day.SetStateTime(state, now)
+	if err := tx.Save(&day).Error; err != nil {
+		return nil, err
+	}
+
+	event := models.AttendanceEvent{
+		AttendanceDayID: day.ID,
+		StudentID:       studentID,
+		FromState:       from,
+		ToState:         state,
+		ActorID:         actor.ID,
+		ActorType:       actor.Type,
+		Note:            note,
+		CreatedAt:       now,
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return nil, err
+	}
+	return &day, nil
+}
+
+// advanceAttendance applies, in order, each of the states that is a valid
+// transition at that point and skips the others. It lets the arrival and
+// pickup endpoints keep the attendance state in step without failing when
+// the student is already past a state.
+func advanceAttendance(tx *gorm.DB, studentID uuid.UUID, date string, actor attendanceActor, note string, states ...string) error {
+	for _, state := range states {
+		_, err := recordAttendance(tx, studentID, date, state, actor, note, false)
+		var invalid *transitionError
+		if errors.As(err, &invalid) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncAttendance runs advanceAttendance for today in its own transaction and
+// logs failures, for endpoints whose own record is already saved.
+func syncAttendance(db *gorm.DB, r *http.Request, studentID string, note string, states ...string) {
+	id, err := uuid.Parse(studentID)
+	if err != nil {
+		return
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return advanceAttendance(tx, id, helpers.Today(), requestActor(r), note, states...)
+	})
+	if err != nil {
+		log.Printf("Error updating attendance of student %s: %v", studentID, err)
+	}
+}
+
+// canRecordAttendance reports whether the caller may move the student to
+// state. Admins may record any state.
+func canRecordAttendance(db *gorm.DB, r *http.Request, studentID uuid.UUID, state string) (bool, error) {
+	if middleware.IsAdmin(r.Context()) {
+		return true, nil
+	}
+	switch state {
+	case models.AttendanceLeftHome, models.AttendanceArrivedHome:
+		guardianship, err := guardianshipOf(db, callerID(r), studentID)
+		return guardianship != nil, err
+	default:
+		// Teachers record school transitions for the grade they supervise
+		if !middleware.HasRole(r.Context(), middleware.PositionTeacher) {
+			return false, nil
+		}
+		return StaffSupervisesStudent(db, callerID(r), studentID.String())
+	}
+}
+
+// requestActor returns the caller of the request as an attendance actor.
+func requestActor(r *http.Request) attendanceActor {
+	id, _ := middleware.GetIDFromContext(r.Context())
+	userType, _ := middleware.GetUserTypeFromContext(r.Context())
+	return attendanceActor{ID: id, Type: userType}
+}
+
+// attendanceDate reads ?date=YYYY-MM-DD, defaulting to today, writing a 400 if
+// it is malformed.
+func attendanceDate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		return helpers.Today(), true
+	}
+	if _, err := helpers.ParseDate(date); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid date, expected YYYY-MM-DD")
+		return "", false
+	}
+	return date, true
+}
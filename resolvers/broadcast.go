@@ -0,0 +1,183 @@
+package resolvers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/messaging"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// BroadcastInput is the payload for sending a message to an audience. The
+// text is Content or the body of the template in TemplateID.
+type BroadcastInput struct {
+	Content    string      `json:"content"`
+	TemplateID *uuid.UUID  `json:"template_id"`
+	Date       string      `json:"date"`        // YYYY-MM-DD for {{date}}, today when empty
+	Audience   string      `json:"audience"`    // grade_parents, staff_position or student_guardians
+	Grade      string      `json:"grade"`       // For grade_parents
+	Position   string      `json:"position"`    // For staff_position
+	StudentIDs []uuid.UUID `json:"student_ids"` // For student_guardians
+}
+
+// BroadcastDetail is a broadcast with how many recipients are in each
+// delivery status.
+type BroadcastDetail struct {
+	models.Broadcast
+	Deliveries map[string]int64 `json:"deliveries"`
+}
+
+// CreateBroadcast resolves the audience, stores the broadcast with one row
+// per recipient, its placeholders rendered for them, and answers 202 right
+// away; the messages are delivered in batches in the background. Teachers
+// may only address the parents and students of the grade they supervise;
+// staff positions need an admin.
+func CreateBroadcast(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var input BroadcastInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	content, status, err := messageContent(db, input.Content, input.TemplateID)
+	if err != nil {
+		handleError(w, status, err.Error())
+		return
+	}
+	date := helpers.DayRange(helpers.SchoolNow()).From
+	if input.Date != "" {
+		if date, err = helpers.ParseDate(input.Date); err != nil {
+			handleError(w, http.StatusBadRequest, "date must be YYYY-MM-DD")
+			return
+		}
+	}
+
+	broadcast := models.Broadcast{
+		SenderID:   callerID(r),
+		Content:    content,
+		TemplateID: input.TemplateID,
+		Audience:   input.Audience,
+		Grade:      input.Grade,
+		Position:   input.Position,
+		StudentIDs: input.StudentIDs,
+		Status:     models.DeliveryPending,
+	}
+	if ok, err := canBroadcastTo(db, r, &broadcast); err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if !ok {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return messaging.CreateBroadcast(tx, &broadcast, date)
+	})
+	if errors.Is(err, messaging.ErrInvalidAudience) {
+		handleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, messaging.ErrNoRecipients) {
+		handleError(w, http.StatusUnprocessableEntity, "The audience has no recipients")
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	messaging.Default.Wake()
+
+	respondJSON(w, http.StatusAccepted, broadcast)
+}
+
+// GetBroadcasts lists the broadcasts the caller sent, newest first. Admins
+// see every broadcast.
+func GetBroadcasts(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	query := db.Order("created_at DESC")
+	if !middleware.IsAdmin(r.Context()) {
+		query = query.Where("sender_id = ?", callerID(r))
+	}
+	broadcasts := []models.Broadcast{}
+	if err := query.Find(&broadcasts).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, broadcasts)
+}
+
+// GetBroadcastByID returns the broadcast in {id} and its delivery progress to
+// its sender or an admin.
+func GetBroadcastByID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid Broadcast UUID")
+		return
+	}
+
+	var detail BroadcastDetail
+	if err := db.Where("id = ?", id).First(&detail.Broadcast).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, http.StatusNotFound, "Broadcast not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if detail.SenderID != callerID(r) && !middleware.IsAdmin(r.Context()) {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	var counts []struct {
+		Status string
+		Count  int64
+	}
+	err = db.Model(&models.BroadcastRecipient{}).Select("status, count(*) AS count").
+		Where("broadcast_id = ?", id).Group("status").Scan(&counts).Error
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	detail.Deliveries = map[string]int64{models.DeliveryPending: 0, models.DeliverySent: 0, models.DeliveryFailed: 0}
+	for _, c := range counts {
+		detail.Deliveries[c.Status] = c.Count
+	}
+
+	respondJSON(w, http.StatusOK, detail)
+}
+
+// canBroadcastTo reports whether the caller may address the broadcast's
+// audience.
+func canBroadcastTo(db *gorm.DB, r *http.Request, broadcast *models.Broadcast) (bool, error) {
+	if middleware.IsAdmin(r.Context()) {
+		return true, nil
+	}
+	staff, err := FetchStaffByUUID(db, callerID(r))
+	if err != nil {
+		return false, nil
+	}
+
+	switch broadcast.Audience {
+	case models.AudienceGradeParents:
+		return broadcast.Grade == "" || broadcast.Grade == staff.SuperviseGrade, nil
+	case models.AudienceStudentGuardians:
+		// Every student must be in the supervised grade
+		ids := map[uuid.UUID]bool{}
+		for _, id := range broadcast.StudentIDs {
+			ids[id] = true
+		}
+		var supervised int64
+		err := db.Model(&models.Student{}).Where("id IN ? AND grade = ?", broadcast.StudentIDs, staff.SuperviseGrade).
+			Count(&supervised).Error
+		return int(supervised) == len(ids), err
+	case models.AudienceStaffPosition:
+		return false, nil
+	}
+	// Unknown audiences are rejected when they are resolved
+	return true, nil
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"github.com/google/uuid"
 	"github.com/mineracail/guardApi/models"
+	"github.com/mineracail/guardApi/notify"
 	"gorm.io/gorm"
 )
 
@@ -31,6 +32,8 @@ func CreateCalendar(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		handleError(w, http.StatusInternalServerError, result.Error.Error())
 		return
 	}
+	notify.Default.NotifyEveryone(notify.CategoryCalendar, "New calendar event", Calendar.Name,
+		map[string]string{"type": "calendar", "calendarId": Calendar.ID.String()})
 
 	respondJSON(w, http.StatusCreated, Calendar)
 }
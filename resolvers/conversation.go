@@ -0,0 +1,130 @@
+package resolvers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// ConversationSummary is a conversation as listed in the caller's inbox.
+type ConversationSummary struct {
+	ID              uuid.UUID  `json:"id"`
+	ParticipantID   uuid.UUID  `json:"participant_id"` // The other participant
+	ParticipantName string     `json:"participant_name"`
+	LastMessageAt   time.Time  `json:"last_message_at"`
+	LastMessage     string     `json:"last_message"`
+	LastSenderID    *uuid.UUID `json:"last_sender_id"`
+	UnreadCount     int64      `json:"unread_count"` // Messages to the caller not read yet
+}
+
+// conversationInboxQuery lists @me's conversations with the other
+// participant's name, the latest message and how many messages @me has not
+// read, most recently active first.
+const conversationInboxQuery = `
+SELECT c.id, other.id AS participant_id,
+	COALESCE(s.first_name || ' ' || s.last_name, p.first_name || ' ' || p.last_name, '') AS participant_name,
+	c.last_message_at, COALESCE(last.content, '') AS last_message, last.sender_id AS last_sender_id,
+	(SELECT count(*) FROM messages m
+		WHERE m.conversation_id = c.id AND m.receiver_id = @me
+			AND m.read_at IS NULL AND m.deleted_at IS NULL) AS unread_count
+FROM conversations c
+CROSS JOIN LATERAL (SELECT CASE WHEN c.participant_a = @me THEN c.participant_b ELSE c.participant_a END AS id) other
+LEFT JOIN staffs s ON s.id = other.id
+LEFT JOIN parents p ON p.id = other.id
+LEFT JOIN LATERAL (
+	SELECT m.content, m.sender_id FROM messages m
+	WHERE m.conversation_id = c.id AND m.deleted_at IS NULL
+	ORDER BY m.created_at DESC LIMIT 1
+) last ON true
+WHERE @me IN (c.participant_a, c.participant_b)
+ORDER BY c.last_message_at DESC, c.id`
+
+// GetConversations lists the caller's conversations, most recently active
+// first, with their unread counts. ?unread=true keeps only conversations
+// with unread messages.
+func GetConversations(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	conversations := []ConversationSummary{}
+	err := db.Raw(conversationInboxQuery, map[string]interface{}{"me": callerID(r)}).Scan(&conversations).Error
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if r.URL.Query().Get("unread") == "true" {
+		unread := conversations[:0]
+		for _, conversation := range conversations {
+			if conversation.UnreadCount > 0 {
+				unread = append(unread, conversation)
+			}
+		}
+		conversations = unread
+	}
+
+	respondJSON(w, http.StatusOK, conversations)
+}
+
+// GetConversationMessages lists the messages of the conversation in {id},
+// oldest first, with their read times.
+func GetConversationMessages(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	conversation, ok := fetchConversation(db, w, r)
+	if !ok {
+		return
+	}
+
+	messages := []models.Message{}
+	if err := db.Where("conversation_id = ?", conversation.ID).Order("created_at, id").Find(&messages).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, messages)
+}
+
+// MarkConversationRead marks every message the caller received in the
+// conversation in {id} read.
+func MarkConversationRead(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	conversation, ok := fetchConversation(db, w, r)
+	if !ok {
+		return
+	}
+
+	result := db.Model(&models.Message{}).
+		Where("conversation_id = ? AND receiver_id = ? AND read_at IS NULL", conversation.ID, callerID(r)).
+		Updates(map[string]interface{}{"status": models.MessageRead, "read_at": time.Now()})
+	if result.Error != nil {
+		handleError(w, http.StatusInternalServerError, result.Error.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]int64{"read": result.RowsAffected})
+}
+
+// fetchConversation loads the conversation in {id}, writing an error unless
+// the caller takes part in it.
+func fetchConversation(db *gorm.DB, w http.ResponseWriter, r *http.Request) (*models.Conversation, bool) {
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid Conversation UUID")
+		return nil, false
+	}
+
+	var conversation models.Conversation
+	if err := db.Where("id = ?", id).First(&conversation).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, http.StatusNotFound, "Conversation not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+
+	if !conversation.HasParticipant(callerID(r)) {
+		middleware.WriteForbidden(w)
+		return nil, false
+	}
+	return &conversation, true
+}
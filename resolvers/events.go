@@ -0,0 +1,208 @@
+package resolvers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/events"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// eventHeartbeat keeps idle streams open through proxies.
+	eventHeartbeat = 25 * time.Second
+	// maxStreamDuration ends streams periodically so reconnecting clients are
+	// authorized again with a current token. Streams also end when the token
+	// they were opened with expires.
+	maxStreamDuration = middleware.AccessTokenTTL
+	// eventRetryMillis is the reconnect delay suggested to clients.
+	eventRetryMillis = 3000
+)
+
+// StreamEvents streams arrival events as server-sent events. Parents receive
+// the events of the students they are a guardian of, or of the ones listed in
+// ?students=id,id; teachers the events of the grade they supervise and admins
+// those of ?grade=, or of every grade. Clients resume after a disconnect by
+// sending the last event ID in the Last-Event-ID header or ?lastEventId=; a
+// "reset" event tells them events were missed and they should reload. The
+// stream ends when the caller's token expires or is revoked.
+func StreamEvents(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handleError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+	filter, ok := eventFilter(db, w, r)
+	if !ok {
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	sub, replay, resumed := events.Default.Subscribe(filter, lastEventID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetryMillis)
+	if !resumed {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range replay {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	claims := middleware.GetClaimsFromContext(r.Context())
+	lifetime := maxStreamDuration
+	if claims != nil {
+		if untilExpiry := time.Until(time.Unix(claims.ExpiresAt, 0)); untilExpiry < lifetime {
+			lifetime = untilExpiry
+		}
+	}
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	deadline := time.NewTimer(lifetime)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-deadline.C:
+			return
+		case <-heartbeat.C:
+			// Logging out or a revoked refresh token family ends the stream too
+			if claims != nil && middleware.Revocations != nil && middleware.Revocations.IsRevoked(claims.Id, claims.Family) {
+				return
+			}
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.Events():
+			if !ok {
+				// Dropped for falling behind; the client resumes on reconnect
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes e in the server-sent events format.
+func writeEvent(w http.ResponseWriter, e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// eventFilter builds the filter of the caller's subscription, writing a 403
+// when they ask for events they may not see.
+func eventFilter(db *gorm.DB, w http.ResponseWriter, r *http.Request) (func(events.Event) bool, bool) {
+	grade := r.URL.Query().Get("grade")
+	userType, _ := middleware.GetUserTypeFromContext(r.Context())
+
+	switch {
+	case middleware.IsAdmin(r.Context()):
+		return func(e events.Event) bool { return grade == "" || e.Grade == grade }, true
+
+	case strings.EqualFold(userType, middleware.UserTypeParent):
+		var studentIDs []uuid.UUID
+		if err := db.Model(&models.Guardianship{}).Where("parent_id = ?", callerID(r)).
+			Pluck("student_id", &studentIDs).Error; err != nil {
+			handleError(w, http.StatusInternalServerError, "Error checking student access")
+			return nil, false
+		}
+		allowed := make(map[string]bool, len(studentIDs))
+		for _, id := range studentIDs {
+			allowed[id.String()] = true
+		}
+		if requested := r.URL.Query().Get("students"); requested != "" {
+			subscribed := make(map[string]bool)
+			for _, id := range strings.Split(requested, ",") {
+				id = strings.TrimSpace(id)
+				if !allowed[id] {
+					middleware.WriteForbidden(w)
+					return nil, false
+				}
+				subscribed[id] = true
+			}
+			allowed = subscribed
+		}
+		return func(e events.Event) bool { return allowed[e.StudentID] }, true
+
+	case middleware.HasRole(r.Context(), middleware.PositionTeacher):
+		staff, err := FetchStaffByUUID(db, callerID(r))
+		if err != nil {
+			middleware.WriteForbidden(w)
+			return nil, false
+		}
+		if grade != "" && grade != staff.SuperviseGrade {
+			middleware.WriteForbidden(w)
+			return nil, false
+		}
+		supervised := staff.SuperviseGrade
+		return func(e events.Event) bool { return e.Grade == supervised }, true
+	}
+
+	middleware.WriteForbidden(w)
+	return nil, false
+}
+
+// arrivalEvent is the data of an arrival event. Events reach every guardian
+// of the student and the staff of the grade, so device locations are left out.
+type arrivalEvent struct {
+	ID             uuid.UUID `json:"id"`
+	StudentID      string    `json:"studentId"`
+	Type           string    `json:"type"` // home or school
+	Confirmed      bool      `json:"confirmed"`
+	Time           time.Time `json:"time"`
+	GeofenceStatus string    `json:"geofenceStatus,omitempty"`
+}
+
+// homeArrivalEvent returns the event data of a home arrival.
+func homeArrivalEvent(arrival models.HomeArrival) arrivalEvent {
+	return arrivalEvent{ID: arrival.ID, StudentID: arrival.StudentID, Type: "home", Confirmed: arrival.Confirmed,
+		Time: arrival.CreatedAt, GeofenceStatus: arrival.GeofenceStatus}
+}
+
+// schoolArrivalEvent returns the event data of a school arrival.
+func schoolArrivalEvent(arrival models.SchoolArrival) arrivalEvent {
+	return arrivalEvent{ID: arrival.ID, StudentID: arrival.StudentID, Type: "school", Confirmed: arrival.Confirmed,
+		Time: arrival.CreatedAt, GeofenceStatus: arrival.GeofenceStatus}
+}
+
+// publishArrival publishes an arrival event for the student. The student's
+// grade is looked up so staff subscriptions can match it.
+func publishArrival(db *gorm.DB, eventType string, arrival arrivalEvent) {
+	studentID := arrival.StudentID
+	e := events.Event{Type: eventType, StudentID: studentID, Data: arrival}
+	if id, err := uuid.Parse(studentID); err == nil {
+		if student, err := FetchStudentByUUID(db, id); err == nil {
+			e.Grade = student.Grade
+		} else {
+			log.Printf("Error loading student %s for arrival event: %v", studentID, err)
+		}
+	}
+	events.Default.Publish(e)
+}
@@ -0,0 +1,383 @@
+package resolvers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"github.com/mineracail/guardApi/pdf"
+	"gorm.io/gorm"
+)
+
+// maxRegisterDays bounds the PDF register, which is built in memory.
+const maxRegisterDays = 31
+
+// csvFlushRows is how many rows are written between flushes of a CSV export.
+const csvFlushRows = 500
+
+// ArrivalExportRow is one home or school arrival with its student.
+type ArrivalExportRow struct {
+	Kind         string // "home" or "school"
+	ID           string
+	CreatedAt    time.Time
+	Confirmed    bool
+	StudentID    string
+	FirstName    string
+	LastName     string
+	Grade        string
+	RecordedByID string
+	RecordedBy   string
+}
+
+// exportFilters are the query parameters shared by the exports.
+type exportFilters struct {
+	Dates     helpers.DateRange
+	Grade     string
+	Confirmed string // "", "true" or "false"
+	Kind      string // "", "home" or "school"
+}
+
+// arrivalExportQuery selects home and school arrivals with the names of the
+// student and of the parent or staff member who recorded them.
+const arrivalExportQuery = `
+SELECT * FROM (
+	SELECT 'home' AS kind, a.id::text AS id, a.created_at, a.confirmed,
+		s.id::text AS student_id, s.first_name, s.last_name, s.grade,
+		a.parent_id AS recorded_by_id, COALESCE(p.first_name || ' ' || p.last_name, '') AS recorded_by
+	FROM home_arrivals a
+	JOIN students s ON s.id::text = a.student_id
+	LEFT JOIN parents p ON p.id::text = a.parent_id
+	WHERE @kind IN ('', 'home')
+	UNION ALL
+	SELECT 'school', a.id::text, a.created_at, a.confirmed,
+		s.id::text, s.first_name, s.last_name, s.grade,
+		a.staff_id, COALESCE(st.first_name || ' ' || st.last_name, '')
+	FROM school_arrivals a
+	JOIN students s ON s.id::text = a.student_id
+	LEFT JOIN staffs st ON st.id::text = a.staff_id
+	WHERE @kind IN ('', 'school')
+) arrivals
+WHERE created_at >= @from AND created_at < @to
+	AND (@grade = '' OR grade = @grade)
+	AND (@confirmed = '' OR confirmed::text = @confirmed)
+ORDER BY created_at, kind, last_name, first_name
+`
+
+// ExportArrivalsCSV streams home and school arrivals as CSV for ?from=&to=
+// (or ?date=), today by default, filtered by ?grade=, ?confirmed=true|false
+// and ?kind=home|school.
+func ExportArrivalsCSV(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	filters, ok := parseExportFilters(w, r, helpers.DayRange(helpers.SchoolNow()))
+	if !ok {
+		return
+	}
+
+	rows, err := db.WithContext(r.Context()).Raw(arrivalExportQuery, map[string]interface{}{
+		"kind":      filters.Kind,
+		"from":      filters.Dates.From,
+		"to":        filters.Dates.To,
+		"grade":     filters.Grade,
+		"confirmed": filters.Confirmed,
+	}).Rows()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="arrivals-%s.csv"`, filters.fileSuffix()))
+	w.WriteHeader(http.StatusOK)
+
+	// Rows are written as they are read; once the header is sent errors can
+	// only be logged
+	out := csv.NewWriter(w)
+	flusher, _ := w.(http.Flusher)
+	if err := out.Write([]string{"date", "time", "kind", "confirmed", "student_id", "first_name", "last_name", "grade", "recorded_by_id", "recorded_by", "arrival_id"}); err != nil {
+		log.Printf("Error writing arrivals export: %v", err)
+		return
+	}
+	count := 0
+	for rows.Next() {
+		var row ArrivalExportRow
+		if err := db.ScanRows(rows, &row); err != nil {
+			log.Printf("Error exporting arrivals: %v", err)
+			return
+		}
+		local := row.CreatedAt.In(helpers.SchoolLocation)
+		err := out.Write(csvRecord(
+			local.Format(helpers.DateLayout),
+			local.Format("15:04:05"),
+			row.Kind,
+			strconv.FormatBool(row.Confirmed),
+			row.StudentID,
+			row.FirstName,
+			row.LastName,
+			row.Grade,
+			row.RecordedByID,
+			row.RecordedBy,
+			row.ID,
+		))
+		if err != nil {
+			log.Printf("Error writing arrivals export: %v", err)
+			return
+		}
+		if count++; count%csvFlushRows == 0 {
+			out.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+			// Stop scanning once the client is gone or the connection broke
+			if err := r.Context().Err(); err != nil {
+				log.Printf("Arrivals export canceled: %v", err)
+				return
+			}
+			if err := out.Error(); err != nil {
+				log.Printf("Error writing arrivals export: %v", err)
+				return
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error exporting arrivals: %v", err)
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		log.Printf("Error writing arrivals export: %v", err)
+	}
+}
+
+// csvRecord returns the cells of a CSV row, prefixing those a spreadsheet
+// would read as a formula with a quote so they are shown as text.
+func csvRecord(cells ...string) []string {
+	for i, cell := range cells {
+		if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			cells[i] = "'" + cell
+		}
+	}
+	return cells
+}
+
+// ExportRegisterPDF renders a printable daily register with one section per
+// grade and school day in ?from=&to= (or ?date=), today by default, showing
+// each student's first school and home arrival. ?grade= limits it to one
+// grade, ?confirmed=true|false to arrivals with that status. Weekends are
+// only included when an arrival was recorded on them.
+func ExportRegisterPDF(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	filters, ok := parseExportFilters(w, r, helpers.DayRange(helpers.SchoolNow()))
+	if !ok {
+		return
+	}
+	if filters.Dates.To.Sub(filters.Dates.From) > maxRegisterDays*24*time.Hour+time.Hour {
+		handleError(w, http.StatusBadRequest, fmt.Sprintf("registers are limited to %d days", maxRegisterDays))
+		return
+	}
+
+	students := []models.Student{}
+	query := db.Order("grade, last_name, first_name")
+	if filters.Grade != "" {
+		query = query.Where("grade = ?", filters.Grade)
+	}
+	if err := query.Find(&students).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var arrivals []ArrivalExportRow
+	if err := db.Raw(arrivalExportQuery, map[string]interface{}{
+		"kind":      "",
+		"from":      filters.Dates.From,
+		"to":        filters.Dates.To,
+		"grade":     filters.Grade,
+		"confirmed": filters.Confirmed,
+	}).Scan(&arrivals).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The first arrival of each kind per student and day; rows come ordered
+	// by time
+	type arrivalKey struct{ kind, studentID, date string }
+	first := make(map[arrivalKey]ArrivalExportRow)
+	daysWithArrivals := make(map[string]bool)
+	for _, arrival := range arrivals {
+		date := arrival.CreatedAt.In(helpers.SchoolLocation).Format(helpers.DateLayout)
+		daysWithArrivals[date] = true
+		key := arrivalKey{arrival.Kind, arrival.StudentID, date}
+		if _, ok := first[key]; !ok {
+			first[key] = arrival
+		}
+	}
+
+	doc := pdf.New()
+	for day := filters.Dates.From.In(helpers.SchoolLocation); day.Before(filters.Dates.To); day = day.AddDate(0, 0, 1) {
+		date := day.Format(helpers.DateLayout)
+		if weekend := day.Weekday() == time.Saturday || day.Weekday() == time.Sunday; weekend && !daysWithArrivals[date] {
+			continue
+		}
+		for start := 0; start < len(students); {
+			end := start
+			for end < len(students) && students[end].Grade == students[start].Grade {
+				end++
+			}
+			register := registerSection{Grade: students[start].Grade, Day: day}
+			for _, student := range students[start:end] {
+				id := student.ID.String()
+				school, atSchool := first[arrivalKey{"school", id, date}]
+				home, atHome := first[arrivalKey{"home", id, date}]
+				register.Rows = append(register.Rows, registerRow{
+					Name:   student.LastName + ", " + student.FirstName,
+					School: registerCell(school, atSchool),
+					Home:   registerCell(home, atHome),
+				})
+				if atSchool && school.Confirmed {
+					register.Present++
+				}
+			}
+			register.render(doc)
+			start = end
+		}
+	}
+	if doc.PageCount() == 0 {
+		page := doc.AddPage()
+		page.Text(registerMargin, pdf.PageHeight-registerMargin, 12, false, "No students or school days match the selected filters.")
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="register-%s.pdf"`, filters.fileSuffix()))
+	w.WriteHeader(http.StatusOK)
+	if _, err := doc.WriteTo(w); err != nil {
+		log.Printf("Error writing register: %v", err)
+	}
+}
+
+// parseExportFilters reads the export query parameters, writing a 400 when
+// one is malformed.
+func parseExportFilters(w http.ResponseWriter, r *http.Request, def helpers.DateRange) (exportFilters, bool) {
+	dates, ok := dateRangeParam(w, r, def)
+	if !ok {
+		return exportFilters{}, false
+	}
+	filters := exportFilters{
+		Dates:     dates,
+		Grade:     r.URL.Query().Get("grade"),
+		Confirmed: r.URL.Query().Get("confirmed"),
+		Kind:      r.URL.Query().Get("kind"),
+	}
+	if filters.Confirmed != "" && filters.Confirmed != "true" && filters.Confirmed != "false" {
+		handleError(w, http.StatusBadRequest, "confirmed must be true or false")
+		return exportFilters{}, false
+	}
+	if filters.Kind != "" && filters.Kind != "home" && filters.Kind != "school" {
+		handleError(w, http.StatusBadRequest, "kind must be home or school")
+		return exportFilters{}, false
+	}
+	return filters, true
+}
+
+// fileSuffix names an export after its first and last day.
+func (f exportFilters) fileSuffix() string {
+	from := f.Dates.From.In(helpers.SchoolLocation).Format(helpers.DateLayout)
+	to := f.Dates.To.In(helpers.SchoolLocation).AddDate(0, 0, -1).Format(helpers.DateLayout)
+	if from == to {
+		return from
+	}
+	return from + "_" + to
+}
+
+// Register layout, in points.
+const (
+	registerMargin    = 40
+	registerRowHeight = 16
+	registerFontSize  = 9
+	registerNameRunes = 42
+)
+
+// registerColumns are the x positions and titles of the register columns.
+var registerColumns = []struct {
+	X     float64
+	Title string
+}{
+	{registerMargin, "No."},
+	{registerMargin + 28, "Student"},
+	{registerMargin + 270, "School arrival"},
+	{registerMargin + 345, "Confirmed"},
+	{registerMargin + 410, "Home arrival"},
+	{registerMargin + 475, "Confirmed"},
+}
+
+// registerSection is the register of one grade on one day.
+type registerSection struct {
+	Grade   string
+	Day     time.Time
+	Rows    []registerRow
+	Present int
+}
+
+type registerRow struct {
+	Name         string
+	School, Home [2]string // Time and confirmation
+}
+
+// registerCell formats an arrival as its time and confirmation.
+func registerCell(arrival ArrivalExportRow, ok bool) [2]string {
+	if !ok {
+		return [2]string{"-", "-"}
+	}
+	confirmed := "no"
+	if arrival.Confirmed {
+		confirmed = "yes"
+	}
+	return [2]string{arrival.CreatedAt.In(helpers.SchoolLocation).Format("15:04"), confirmed}
+}
+
+// render draws the section on as many pages as its rows need.
+func (s registerSection) render(doc *pdf.Document) {
+	var page *pdf.Page
+	y := 0.0
+	newPage := func(continued bool) {
+		page = doc.AddPage()
+		title := "Daily register - Grade " + s.Grade
+		if continued {
+			title += " (continued)"
+		}
+		top := float64(pdf.PageHeight - registerMargin)
+		page.Text(registerMargin, top, 14, true, title)
+		page.Text(registerMargin, top-18, 11, false, s.Day.Format("Monday 2 January 2006"))
+		y = top - 50
+		for _, column := range registerColumns {
+			page.Text(column.X, y, registerFontSize, true, column.Title)
+		}
+		page.Line(registerMargin, y-5, pdf.PageWidth-registerMargin, y-5, 0.8)
+		y -= registerRowHeight + 4
+	}
+
+	newPage(false)
+	for i, row := range s.Rows {
+		if y < registerMargin+registerRowHeight {
+			newPage(true)
+		}
+		name := row.Name
+		if utf8.RuneCountInString(name) > registerNameRunes {
+			name = string([]rune(name)[:registerNameRunes-3]) + "..."
+		}
+		cells := []string{strconv.Itoa(i + 1), name, row.School[0], row.School[1], row.Home[0], row.Home[1]}
+		for j, cell := range cells {
+			page.Text(registerColumns[j].X, y, registerFontSize, false, cell)
+		}
+		page.Line(registerMargin, y-5, pdf.PageWidth-registerMargin, y-5, 0.2)
+		y -= registerRowHeight
+	}
+	if y < registerMargin {
+		newPage(true)
+	}
+	page.Text(registerMargin, y-6, registerFontSize+1, true,
+		fmt.Sprintf("Confirmed at school: %d of %d", s.Present, len(s.Rows)))
+}
@@ -0,0 +1,14 @@
+package resolvers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCSVRecord(t *testing.T) {
+	got := csvRecord("Ama", "=SUM(A1:A2)", "+233", "-1", "@cmd", "\tTAB", "\rCR", "", "a=b")
+	want := []string{"Ama", "'=SUM(A1:A2)", "'+233", "'-1", "'@cmd", "'\tTAB", "'\rCR", "", "a=b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("csvRecord = %q, want %q", got, want)
+	}
+}
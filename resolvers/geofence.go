@@ -0,0 +1,338 @@
+package resolvers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Geofence modes, set with GEOFENCE_MODE.
+const (
+	GeofenceModeOff    = "off"    // Locations are stored but not checked
+	GeofenceModeFlag   = "flag"   // Arrivals outside the fence are saved and flagged
+	GeofenceModeReject = "reject" // Confirmations outside the fence or without a location are refused
+)
+
+const (
+	// maxGeofenceRadius bounds configured fences, in meters.
+	maxGeofenceRadius = 5000
+	// maxCountedAccuracy caps how much of a location's reported accuracy is
+	// credited, so a vague fix cannot confirm an arrival from far away.
+	maxCountedAccuracy = 100
+)
+
+// GeofenceInput is the payload for creating or updating a geofence.
+type GeofenceInput struct {
+	Name         string  `json:"name"`
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	RadiusMeters float64 `json:"radiusMeters"`
+}
+
+// geofenceMode returns GEOFENCE_MODE, flag when it is unset or unknown.
+func geofenceMode() string {
+	switch mode := strings.ToLower(os.Getenv("GEOFENCE_MODE")); mode {
+	case GeofenceModeOff, GeofenceModeReject:
+		return mode
+	}
+	return GeofenceModeFlag
+}
+
+// GetGeofences lists the geofences, optionally only those of ?kind=. Home
+// fences reveal where parents live, so other staff only get school fences.
+func GetGeofences(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	query := db.Order("kind, name")
+	kind := r.URL.Query().Get("kind")
+	if !middleware.IsAdmin(r.Context()) {
+		if kind != "" && kind != models.GeofenceSchool {
+			middleware.WriteForbidden(w)
+			return
+		}
+		kind = models.GeofenceSchool
+	}
+	if kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	var fences []models.Geofence
+	if err := query.Find(&fences).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, fences)
+}
+
+// CreateSchoolGeofence adds a fence around the school. Arrivals at school are
+// inside when they are within any of the school's fences.
+func CreateSchoolGeofence(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	input, ok := decodeGeofenceInput(w, r)
+	if !ok {
+		return
+	}
+
+	fence := models.Geofence{Kind: models.GeofenceSchool}
+	applyGeofenceInput(&fence, input)
+	if err := db.Create(&fence).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, fence)
+}
+
+// UpdateGeofence replaces the geofence in {id}.
+func UpdateGeofence(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid geofence UUID")
+		return
+	}
+	input, ok := decodeGeofenceInput(w, r)
+	if !ok {
+		return
+	}
+
+	var fence models.Geofence
+	if err := db.Where("id = ?", id).First(&fence).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, http.StatusNotFound, "Geofence not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	applyGeofenceInput(&fence, input)
+	if err := db.Save(&fence).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, fence)
+}
+
+// DeleteGeofence removes the geofence in {id}.
+func DeleteGeofence(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid geofence UUID")
+		return
+	}
+
+	result := db.Where("id = ?", id).Delete(&models.Geofence{})
+	if result.Error != nil {
+		handleError(w, http.StatusInternalServerError, result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		handleError(w, http.StatusNotFound, "Geofence not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetHomeGeofence returns the home fence of the parent in {id}.
+func GetHomeGeofence(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	parentID, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid parent UUID")
+		return
+	}
+
+	var fence models.Geofence
+	if err := db.Where("parent_id = ?", parentID).First(&fence).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, http.StatusNotFound, "Home geofence not set")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusOK, fence)
+}
+
+// PutHomeGeofence sets the home fence of the parent in {id}.
+func PutHomeGeofence(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	parentID, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid parent UUID")
+		return
+	}
+	input, ok := decodeGeofenceInput(w, r)
+	if !ok {
+		return
+	}
+	if _, err := FetchParentByUUID(db, parentID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, http.StatusNotFound, "Parent not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	fence := models.Geofence{Kind: models.GeofenceHome, ParentID: &parentID}
+	applyGeofenceInput(&fence, input)
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "parent_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "latitude", "longitude", "radius_meters", "updated_at"}),
+	}).Create(&fence).Error
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := db.Where("parent_id = ?", parentID).First(&fence).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, fence)
+}
+
+// DeleteHomeGeofence removes the home fence of the parent in {id}.
+func DeleteHomeGeofence(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	parentID, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid parent UUID")
+		return
+	}
+
+	if err := db.Where("parent_id = ?", parentID).Delete(&models.Geofence{}).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeGeofenceInput reads and validates a geofence payload, writing a 400
+// when it is invalid.
+func decodeGeofenceInput(w http.ResponseWriter, r *http.Request) (GeofenceInput, bool) {
+	var input GeofenceInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return input, false
+	}
+	if !helpers.ValidCoordinates(input.Latitude, input.Longitude) {
+		handleError(w, http.StatusBadRequest, "Invalid latitude or longitude")
+		return input, false
+	}
+	if input.RadiusMeters <= 0 || input.RadiusMeters > maxGeofenceRadius {
+		handleError(w, http.StatusBadRequest, "radiusMeters must be between 0 and 5000")
+		return input, false
+	}
+	return input, true
+}
+
+func applyGeofenceInput(fence *models.Geofence, input GeofenceInput) {
+	fence.Name = input.Name
+	fence.Latitude = input.Latitude
+	fence.Longitude = input.Longitude
+	fence.RadiusMeters = input.RadiusMeters
+}
+
+// arrivalLocation is the device location submitted with an arrival and the
+// outcome of checking it against the fences.
+type arrivalLocation struct {
+	Latitude, Longitude, Accuracy *float64
+	Status                        string
+	Distance                      *float64
+}
+
+// checkArrivalLocation validates the location submitted with an arrival and
+// compares it with fences. It writes a 400 for malformed locations and, in
+// reject mode, a 422 for confirmations made without a location or outside
+// every fence.
+func checkArrivalLocation(w http.ResponseWriter, loc *arrivalLocation, fences []models.Geofence, confirmed bool) bool {
+	loc.Status, loc.Distance = "", nil
+	if (loc.Latitude == nil) != (loc.Longitude == nil) {
+		handleError(w, http.StatusBadRequest, "latitude and longitude must be given together")
+		return false
+	}
+	if loc.Latitude != nil && !helpers.ValidCoordinates(*loc.Latitude, *loc.Longitude) {
+		handleError(w, http.StatusBadRequest, "Invalid latitude or longitude")
+		return false
+	}
+	if loc.Accuracy != nil && *loc.Accuracy < 0 {
+		handleError(w, http.StatusBadRequest, "accuracy must not be negative")
+		return false
+	}
+
+	mode := geofenceMode()
+	switch {
+	case mode == GeofenceModeOff:
+		return true
+	case len(fences) == 0:
+		loc.Status = models.GeofenceNotConfigured
+		return true
+	case loc.Latitude == nil:
+		// Leaving the location out must not skip the fence check
+		if mode == GeofenceModeReject && confirmed {
+			handleError(w, http.StatusUnprocessableEntity, "A location is required to confirm an arrival")
+			return false
+		}
+		loc.Status = models.GeofenceNoLocation
+		return true
+	}
+
+	// Inside when the accuracy circle reaches any fence
+	accuracy := 0.0
+	if loc.Accuracy != nil {
+		accuracy = min(*loc.Accuracy, maxCountedAccuracy)
+	}
+	loc.Status = models.GeofenceOutside
+	for _, fence := range fences {
+		distance := helpers.DistanceMeters(*loc.Latitude, *loc.Longitude, fence.Latitude, fence.Longitude)
+		if loc.Distance == nil || distance < *loc.Distance {
+			loc.Distance = &distance
+		}
+		if distance-accuracy <= fence.RadiusMeters {
+			loc.Status = models.GeofenceInside
+		}
+	}
+
+	if mode == GeofenceModeReject && confirmed && loc.Status == models.GeofenceOutside {
+		handleError(w, http.StatusUnprocessableEntity, "Arrival confirmed outside the geofence")
+		return false
+	}
+	return true
+}
+
+// schoolGeofences returns the school's fences.
+func schoolGeofences(db *gorm.DB) ([]models.Geofence, error) {
+	var fences []models.Geofence
+	err := db.Where("kind = ?", models.GeofenceSchool).Find(&fences).Error
+	return fences, err
+}
+
+// homeGeofences returns the home fences of the student's guardians, so a
+// student living in two homes can arrive at either.
+func homeGeofences(db *gorm.DB, studentID string) ([]models.Geofence, error) {
+	id, err := uuid.Parse(studentID)
+	if err != nil {
+		return nil, nil
+	}
+	var fences []models.Geofence
+	err = db.Where("kind = ? AND parent_id IN (?)", models.GeofenceHome,
+		db.Model(&models.Guardianship{}).Select("parent_id").Where("student_id = ?", id)).Find(&fences).Error
+	return fences, err
+}
+
+// geofenceFilter limits an arrival query to ?geofence=inside, outside,
+// no_location or no_geofence, for reviewing flagged arrivals.
+func geofenceFilter(query *gorm.DB, r *http.Request) *gorm.DB {
+	if status := r.URL.Query().Get("geofence"); status != "" {
+		return query.Where("geofence_status = ?", status)
+	}
+	return query
+}
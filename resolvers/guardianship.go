@@ -0,0 +1,228 @@
+package resolvers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// GuardianshipInput is the payload for adding or updating a guardianship.
+// Omitted flags keep their current value, or default to custody and pickup
+// permission for a new guardian and neither for an emergency contact.
+type GuardianshipInput struct {
+	StudentID  uuid.UUID `json:"studentId"`
+	Relation   string    `json:"relation"`
+	HasCustody *bool     `json:"hasCustody"`
+	CanPickup  *bool     `json:"canPickup"`
+}
+
+// GuardianSummary is what a guardian sees of the student's other guardians.
+type GuardianSummary struct {
+	ParentID  uuid.UUID `json:"parentId"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	Relation  string    `json:"relation"`
+}
+
+// AddGuardianship links a student to the parent in {id}.
+func AddGuardianship(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	parentID, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid parent UUID")
+		return
+	}
+
+	var input GuardianshipInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if input.Relation == "" {
+		input.Relation = models.RelationGuardian
+	}
+	if !models.ValidRelation(input.Relation) {
+		handleError(w, http.StatusBadRequest, "Invalid relation")
+		return
+	}
+
+	// Report missing rows as 404 rather than as foreign key violations
+	if _, err := FetchParentByUUID(db, parentID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, http.StatusNotFound, "Parent not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if _, err := FetchStudentByUUID(db, input.StudentID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, http.StatusNotFound, "Student not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	defaultFlag := input.Relation != models.RelationEmergencyContact
+	guardianship := models.Guardianship{
+		ParentID:   parentID,
+		StudentID:  input.StudentID,
+		Relation:   input.Relation,
+		HasCustody: defaultFlag,
+		CanPickup:  defaultFlag,
+	}
+	applyGuardianshipFlags(&guardianship, input)
+
+	var existing int64
+	if err := db.Model(&models.Guardianship{}).
+		Where("parent_id = ? AND student_id = ?", parentID, input.StudentID).
+		Count(&existing).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if existing > 0 {
+		handleError(w, http.StatusConflict, "Student is already linked to this parent")
+		return
+	}
+
+	if err := db.Create(&guardianship).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, guardianship)
+}
+
+// UpdateGuardianship changes the relation or flags of the link between the
+// parent in {id} and the student in {studentId}.
+func UpdateGuardianship(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	guardianship, ok := fetchGuardianship(db, w, r)
+	if !ok {
+		return
+	}
+
+	var input GuardianshipInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if input.Relation != "" {
+		if !models.ValidRelation(input.Relation) {
+			handleError(w, http.StatusBadRequest, "Invalid relation")
+			return
+		}
+		guardianship.Relation = input.Relation
+	}
+	applyGuardianshipFlags(guardianship, input)
+
+	if err := db.Save(guardianship).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, guardianship)
+}
+
+// RemoveGuardianship unlinks the student in {studentId} from the parent in {id}.
+func RemoveGuardianship(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	guardianship, ok := fetchGuardianship(db, w, r)
+	if !ok {
+		return
+	}
+
+	if err := db.Delete(guardianship).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetGuardianshipsByParent lists the students of the parent in {id}.
+func GetGuardianshipsByParent(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	parentID, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid parent UUID")
+		return
+	}
+
+	var guardianships []models.Guardianship
+	if err := db.Preload("Student").Where("parent_id = ?", parentID).Order("created_at").Find(&guardianships).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, guardianships)
+}
+
+// GetGuardiansByStudent lists the guardians of the student in {id}. Staff get
+// the full parent records; a guardian only gets the names and relations of
+// the others, since their contact details can matter in custody disputes.
+func GetGuardiansByStudent(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	studentID, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid student UUID")
+		return
+	}
+
+	var guardianships []models.Guardianship
+	if err := db.Preload("Parent").Where("student_id = ?", studentID).Order("created_at").Find(&guardianships).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if middleware.HasRole(r.Context(), middleware.UserTypeStaff) {
+		respondJSON(w, http.StatusOK, guardianships)
+		return
+	}
+
+	guardians := make([]GuardianSummary, 0, len(guardianships))
+	for _, guardianship := range guardianships {
+		guardian := GuardianSummary{ParentID: guardianship.ParentID, Relation: guardianship.Relation}
+		if guardianship.Parent != nil {
+			guardian.FirstName, guardian.LastName = guardianship.Parent.FirstName, guardianship.Parent.LastName
+		}
+		guardians = append(guardians, guardian)
+	}
+	respondJSON(w, http.StatusOK, guardians)
+}
+
+// fetchGuardianship loads the guardianship named by {id} and {studentId},
+// writing the error response itself.
+func fetchGuardianship(db *gorm.DB, w http.ResponseWriter, r *http.Request) (*models.Guardianship, bool) {
+	parentID, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid parent UUID")
+		return nil, false
+	}
+	studentID, err := uuid.Parse(chi.URLParam(r, "studentId"))
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid student UUID")
+		return nil, false
+	}
+
+	var guardianship models.Guardianship
+	if err := db.Where("parent_id = ? AND student_id = ?", parentID, studentID).First(&guardianship).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, http.StatusNotFound, "Guardianship not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return &guardianship, true
+}
+
+func applyGuardianshipFlags(guardianship *models.Guardianship, input GuardianshipInput) {
+	if input.HasCustody != nil {
+		guardianship.HasCustody = *input.HasCustody
+	}
+	if input.CanPickup != nil {
+		guardianship.CanPickup = *input.CanPickup
+	}
+}
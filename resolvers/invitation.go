@@ -0,0 +1,118 @@
+package resolvers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	netmail "net/mail"
+
+	"github.com/mineracail/guardApi/mailer"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+var errInvalidAccountType = errors.New("invalid account type")
+
+// InvitationRequest is the payload for inviting a new staff member or parent.
+type InvitationRequest struct {
+	Type           string `json:"type"` // staff or parent
+	FirstName      string `json:"firstName"`
+	LastName       string `json:"lastName"`
+	Email          string `json:"email"`
+	PhoneNumber    string `json:"phoneNumber"`
+	Position       string `json:"position"`       // Staff only
+	SuperviseGrade string `json:"superviseGrade"` // Staff only
+}
+
+// InviteUser creates a staff or parent profile and mails a one-time
+// invitation link for choosing a password. When the email already has a user
+// with a password (a teacher who is also a parent), the new profile is linked
+// to it and no invitation is needed.
+func InviteUser(db *gorm.DB, mail mailer.Mailer, w http.ResponseWriter, r *http.Request) {
+	var req InvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	req.Email = models.NormalizeEmail(req.Email)
+	if req.Email == "" {
+		handleError(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+	if address, err := netmail.ParseAddress(req.Email); err != nil || address.Address != req.Email {
+		handleError(w, http.StatusBadRequest, "Email is invalid")
+		return
+	}
+
+	var account interface{}
+	var user *models.User
+	var token string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		switch req.Type {
+		case middleware.UserTypeStaff:
+			staff := models.Staff{
+				FirstName:      req.FirstName,
+				LastName:       req.LastName,
+				Email:          req.Email,
+				PhoneNumber:    req.PhoneNumber,
+				Position:       req.Position,
+				SuperviseGrade: req.SuperviseGrade,
+			}
+			if err := tx.Create(&staff).Error; err != nil {
+				return err
+			}
+			account = staff
+			user, err = linkUser(tx, staff.Email, "", &staff.ID, nil)
+		case middleware.UserTypeParent:
+			parent := models.Parent{
+				FirstName:   req.FirstName,
+				LastName:    req.LastName,
+				Email:       req.Email,
+				PhoneNumber: req.PhoneNumber,
+			}
+			if err := tx.Create(&parent).Error; err != nil {
+				return err
+			}
+			account = parent
+			user, err = linkUser(tx, parent.Email, "", nil, &parent.ID)
+		default:
+			return errInvalidAccountType
+		}
+		if err != nil || user.Password != "" {
+			return err
+		}
+
+		// The user cannot sign in until the invitation is accepted
+		token, err = middleware.IssueOneTimeToken(tx, req.Type, user.ID.String(), models.TokenPurposeInvitation, middleware.InvitationTTL)
+		return err
+	})
+	if errors.Is(err, errInvalidAccountType) {
+		handleError(w, http.StatusBadRequest, "Type must be staff or parent")
+		return
+	}
+	if err != nil {
+		handleCredentialError(w, err)
+		return
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "You have been invited to guardApi",
+		Body: fmt.Sprintf("Hello %s,\n\nYou have been invited to guardApi. Use the link below to choose your password. It expires in %s.\n\n%s/accept-invitation?token=%s",
+			req.FirstName, middleware.InvitationTTL, middleware.AppBaseURL(), token),
+	}
+	if token == "" {
+		msg.Subject = "A new profile was added to your guardApi account"
+		msg.Body = fmt.Sprintf("Hello %s,\n\nA %s profile was added to your guardApi account. Sign in with your existing password and switch to it from the app.",
+			req.FirstName, req.Type)
+	}
+	if err := mail.Send(r.Context(), msg); err != nil {
+		log.Printf("Error sending invitation mail: %v", err)
+	}
+
+	respondJSON(w, http.StatusCreated, account)
+}
@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"time"
 
 	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/events"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/middleware/helpers"
 	"github.com/mineracail/guardApi/models"
 	"gorm.io/gorm"
 )
@@ -19,18 +21,51 @@ func CreateHomeArrival(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the current date
-	currentDate := time.Now().Format("2006-01-02") // Format to YYYY-MM-DD
+	// Parents can only log arrivals for the children they supervise
+	if !middleware.IsAdmin(r.Context()) {
+		parentID := callerID(r)
+		ok, err := ParentSupervisesStudent(db, parentID, homeArrival.StudentID)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, "Error checking student access")
+			return
+		}
+		if !ok {
+			middleware.WriteForbidden(w)
+			return
+		}
+		homeArrival.ParentID = parentID.String()
+	}
+
+	// Check the device location against the homes of the student's guardians
+	fences, err := homeGeofences(db, homeArrival.StudentID)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Error loading geofences")
+		return
+	}
+	location := arrivalLocation{Latitude: homeArrival.Latitude, Longitude: homeArrival.Longitude, Accuracy: homeArrival.Accuracy}
+	if !checkArrivalLocation(w, &location, fences, homeArrival.Confirmed) {
+		return
+	}
+	homeArrival.GeofenceStatus, homeArrival.GeofenceDistance = location.Status, location.Distance
+
+	// Get the current school day
+	today := helpers.DayRange(helpers.SchoolNow())
 
 	// Check if a HomeArrival already exists for the same ParentID, StudentID, and current date
 	var existingArrival models.HomeArrival
-	if err := db.Where("parent_id = ? AND student_id = ? AND DATE(created_at) = ?", homeArrival.ParentID, homeArrival.StudentID, currentDate).First(&existingArrival).Error; err == nil {
+	if err := db.Where("parent_id = ? AND student_id = ? AND created_at >= ? AND created_at < ?", homeArrival.ParentID, homeArrival.StudentID, today.From, today.To).First(&existingArrival).Error; err == nil {
 		// Record exists, update the existing one
 		existingArrival.Confirmed = homeArrival.Confirmed
+		existingArrival.Latitude, existingArrival.Longitude, existingArrival.Accuracy = homeArrival.Latitude, homeArrival.Longitude, homeArrival.Accuracy
+		existingArrival.GeofenceStatus, existingArrival.GeofenceDistance = homeArrival.GeofenceStatus, homeArrival.GeofenceDistance
 		if result := db.Save(&existingArrival); result.Error != nil {
 			handleError(w, http.StatusInternalServerError, result.Error.Error())
 			return
 		}
+		if existingArrival.Confirmed {
+			syncHomeAttendance(db, r, existingArrival.StudentID)
+			publishArrival(db, events.HomeArrivalConfirmed, homeArrivalEvent(existingArrival))
+		}
 		respondJSON(w, http.StatusOK, existingArrival)
 		return
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
@@ -45,10 +80,21 @@ func CreateHomeArrival(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		handleError(w, http.StatusInternalServerError, result.Error.Error())
 		return
 	}
+	publishArrival(db, events.HomeArrivalCreated, homeArrivalEvent(homeArrival))
+	if homeArrival.Confirmed {
+		syncHomeAttendance(db, r, homeArrival.StudentID)
+		publishArrival(db, events.HomeArrivalConfirmed, homeArrivalEvent(homeArrival))
+	}
 
 	respondJSON(w, http.StatusCreated, homeArrival)
 }
 
+// syncHomeAttendance records a confirmed home arrival in the attendance
+// state: arriving home after pickup, or leaving home in the morning.
+func syncHomeAttendance(db *gorm.DB, r *http.Request, studentID string) {
+	syncAttendance(db, r, studentID, "home arrival", models.AttendanceArrivedHome, models.AttendanceLeftHome)
+}
+
 func GetConfirmedArrivalsByParent(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	// Parse UUID from the URL path parameters
 	parentID, err := parseUUID(r)
@@ -57,12 +103,15 @@ func GetConfirmedArrivalsByParent(db *gorm.DB, w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	// Get the current date
-	currentDate := time.Now().Format("2006-01-02") // Format to YYYY-MM-DD
+	// Today unless ?date= or ?from=&to= is given
+	dates, ok := dateRangeParam(w, r, helpers.DayRange(helpers.SchoolNow()))
+	if !ok {
+		return
+	}
 
-	// Query the database to retrieve all confirmed HomeArrival records for the given parent and today's date
+	// Query the database to retrieve all confirmed HomeArrival records for the given parent and date range
 	var confirmedArrivals []models.HomeArrival
-	if err := db.Where("parent_id = ?  AND DATE(created_at) = ?", parentID.String(), currentDate).Find(&confirmedArrivals).Error; err != nil {
+	if err := db.Where("parent_id = ? AND created_at >= ? AND created_at < ?", parentID.String(), dates.From, dates.To).Find(&confirmedArrivals).Error; err != nil {
 		handleError(w, http.StatusInternalServerError, "Error retrieving confirmed arrivals: "+err.Error())
 		return
 	}
@@ -73,12 +122,15 @@ func GetConfirmedArrivalsByParent(db *gorm.DB, w http.ResponseWriter, r *http.Re
 	respondJSON(w, http.StatusOK, confirmedArrivals)
 }
 func GetAllConfirmedArrivals(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	// Get the current date
-	currentDate := time.Now().Format("2006-01-02") // Format to YYYY-MM-DD
+	// Today unless ?date= or ?from=&to= is given
+	dates, ok := dateRangeParam(w, r, helpers.DayRange(helpers.SchoolNow()))
+	if !ok {
+		return
+	}
 
-	// Query the database to retrieve all confirmed HomeArrival records for today's date
+	// Query the database to retrieve all confirmed HomeArrival records for the date range
 	var confirmedArrivals []models.HomeArrival
-	if err := db.Where("DATE(created_at) = ?", currentDate).Find(&confirmedArrivals).Error; err != nil {
+	if err := geofenceFilter(db, r).Where("created_at >= ? AND created_at < ?", dates.From, dates.To).Find(&confirmedArrivals).Error; err != nil {
 		handleError(w, http.StatusInternalServerError, "Error retrieving confirmed arrivals: "+err.Error())
 		return
 	}
@@ -89,12 +141,15 @@ func GetAllConfirmedArrivals(db *gorm.DB, w http.ResponseWriter, r *http.Request
 }
 
 func GetAllConfirmedArrivalsStaff(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	// Get the current date
-	currentDate := time.Now().Format("2006-01-02") // Format to YYYY-MM-DD
+	// Today unless ?date= or ?from=&to= is given
+	dates, ok := dateRangeParam(w, r, helpers.DayRange(helpers.SchoolNow()))
+	if !ok {
+		return
+	}
 
-	// Query the database to retrieve all confirmed HomeArrival records for today's date
+	// Query the database to retrieve all confirmed SchoolArrival records for the date range
 	var confirmedArrivals []models.SchoolArrival
-	if err := db.Where("DATE(created_at) = ?", currentDate).Find(&confirmedArrivals).Error; err != nil {
+	if err := geofenceFilter(db, r).Where("created_at >= ? AND created_at < ?", dates.From, dates.To).Find(&confirmedArrivals).Error; err != nil {
 		handleError(w, http.StatusInternalServerError, "Error retrieving confirmed arrivals: "+err.Error())
 		return
 	}
@@ -104,15 +159,17 @@ func GetAllConfirmedArrivalsStaff(db *gorm.DB, w http.ResponseWriter, r *http.Re
 	// Respond with the list of confirmed arrivals
 	respondJSON(w, http.StatusOK, confirmedArrivals)
 }
-// GetAllHomeArrivalsForThatWeek retrieves all home arrivals for the current week.
+// GetAllHomeArrivalsForThatWeek retrieves all home arrivals for the current
+// Monday-to-Sunday week, or for ?date= or ?from=&to=.
 func GetAllHomeArrivalsForThatWeek(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	// Get the current time and calculate the start of the week (e.g., Monday)
-	now := time.Now()
-	startOfWeek := now.Truncate(24 * time.Hour).AddDate(0, 0, -int(now.Weekday()-1)) // Monday
+	dates, ok := dateRangeParam(w, r, helpers.WeekRange(helpers.SchoolNow()))
+	if !ok {
+		return
+	}
 
-	// Fetch all home arrivals for the current week
+	// Fetch all home arrivals for the date range
 	var homeArrivals []models.HomeArrival
-	if err := db.Where("created_at >= ?", startOfWeek).Find(&homeArrivals).Error; err != nil {
+	if err := geofenceFilter(db, r).Where("created_at >= ? AND created_at < ?", dates.From, dates.To).Find(&homeArrivals).Error; err != nil {
 		handleError(w, http.StatusInternalServerError, "Error fetching home arrivals: "+err.Error())
 		return
 	}
@@ -123,7 +180,8 @@ func GetAllHomeArrivalsForThatWeek(db *gorm.DB, w http.ResponseWriter, r *http.R
 
 
 
-// GetAllHomeArrivalsForThatWeekByParentId retrieves all home arrivals for a specified parent ID for the current week.
+// GetAllHomeArrivalsForThatWeekByParentId retrieves all home arrivals for a specified parent ID for the current
+// Monday-to-Sunday week, or for ?date= or ?from=&to=.
 func GetAllHomeArrivalsForThatWeekByParentId(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	// Parse UUID from the request
 	parentID, err := parseUUID(r)
@@ -133,12 +191,14 @@ func GetAllHomeArrivalsForThatWeekByParentId(db *gorm.DB, w http.ResponseWriter,
 	}
 
 	// Get the start and end of the current week
-	startOfWeek := time.Now().Truncate(24 * time.Hour).AddDate(0, 0, -int(time.Now().Weekday()))
-	endOfWeek := startOfWeek.AddDate(0, 0, 7)
+	dates, ok := dateRangeParam(w, r, helpers.WeekRange(helpers.SchoolNow()))
+	if !ok {
+		return
+	}
 
-	// Fetch home arrivals for the specified parent within the current week
+	// Fetch home arrivals for the specified parent within the date range
 	var homeArrivals []models.HomeArrival
-	if err := db.Where("parent_id = ? AND created_at >= ? AND created_at < ?", parentID.String(), startOfWeek, endOfWeek).Find(&homeArrivals).Error; err != nil {
+	if err := db.Where("parent_id = ? AND created_at >= ? AND created_at < ?", parentID.String(), dates.From, dates.To).Find(&homeArrivals).Error; err != nil {
 		handleError(w, http.StatusInternalServerError, "Error fetching home arrivals: "+err.Error())
 		return
 	}
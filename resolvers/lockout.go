@@ -0,0 +1,41 @@
+package resolvers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// GetActiveLockouts lists the emails and IP addresses currently locked out of login.
+func GetActiveLockouts(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var throttles []models.LoginThrottle
+	if err := db.Where("locked_until > ?", time.Now()).Find(&throttles).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, throttles)
+}
+
+// UnlockAccount clears the failed login count and any lockout of an email.
+func UnlockAccount(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Email == "" {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := middleware.ResetLoginThrottle(db, middleware.EmailThrottleKey(input.Email)); err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	middleware.RecordAudit(db, models.AuditAccountUnlocked, input.Email, callerID(r).String(), "", "unlocked by admin")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,154 @@
+package resolvers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultMessagePageSize = 50
+	maxMessagePageSize     = 100
+)
+
+// MessagePage is one page of the caller's messages, newest first. NextCursor
+// is empty on the last page.
+type MessagePage struct {
+	Messages   []models.Message `json:"messages"`
+	NextCursor string           `json:"next_cursor"`
+}
+
+// messageCursor is the position after the last message of a page.
+type messageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// GetInbox lists the messages the caller received, newest first.
+// ?status=unread|read, ?sender= and ?date= or ?from=&to= filter them; ?limit=
+// and ?cursor= page through them.
+func GetInbox(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	listMailbox(db, w, r, "receiver_id", "sender")
+}
+
+// GetSent lists the messages the caller sent, newest first, with the same
+// filters as GetInbox except ?receiver= instead of ?sender=.
+func GetSent(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	listMailbox(db, w, r, "sender_id", "receiver")
+}
+
+// listMailbox lists the messages whose owner column is the caller, filtered
+// by the other party in ?counterpart=.
+func listMailbox(db *gorm.DB, w http.ResponseWriter, r *http.Request, owner, counterpart string) {
+	me := callerID(r)
+	if me == uuid.Nil {
+		middleware.WriteForbidden(w)
+		return
+	}
+	query := r.URL.Query()
+
+	limit := defaultMessagePageSize
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxMessagePageSize {
+			handleError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxMessagePageSize))
+			return
+		}
+		limit = parsed
+	}
+
+	scope := db.Where(owner+" = ?", me)
+
+	switch status := query.Get("status"); status {
+	case "":
+	case models.MessageUnread:
+		scope = scope.Where("read_at IS NULL")
+	case models.MessageRead:
+		scope = scope.Where("read_at IS NOT NULL")
+	default:
+		handleError(w, http.StatusBadRequest, "status must be unread or read")
+		return
+	}
+
+	if value := query.Get(counterpart); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			handleError(w, http.StatusBadRequest, fmt.Sprintf("Invalid %s UUID", counterpart))
+			return
+		}
+		other := "sender_id"
+		if owner == "sender_id" {
+			other = "receiver_id"
+		}
+		scope = scope.Where(other+" = ?", id)
+	}
+
+	// Without ?date= or ?from=&to= every message is listed
+	dates, ok := dateRangeParam(w, r, helpers.DateRange{})
+	if !ok {
+		return
+	}
+	if !dates.From.IsZero() {
+		scope = scope.Where("created_at >= ? AND created_at < ?", dates.From, dates.To)
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeMessageCursor(value)
+		if err != nil {
+			handleError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		scope = scope.Where("(created_at, id) < (?, ?)", cursor.CreatedAt, cursor.ID)
+	}
+
+	// One extra row tells whether another page follows
+	messages := []models.Message{}
+	if err := scope.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&messages).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	page := MessagePage{Messages: messages}
+	if len(messages) > limit {
+		page.Messages = messages[:limit]
+		last := page.Messages[limit-1]
+		page.NextCursor = encodeMessageCursor(messageCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	respondJSON(w, http.StatusOK, page)
+}
+
+// encodeMessageCursor returns an opaque cursor for the position after c.
+func encodeMessageCursor(c messageCursor) string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeMessageCursor(value string) (messageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return messageCursor{}, err
+	}
+	createdAt, id, found := strings.Cut(string(raw), "|")
+	if !found {
+		return messageCursor{}, errors.New("malformed cursor")
+	}
+	var c messageCursor
+	if c.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return messageCursor{}, err
+	}
+	if c.ID, err = uuid.Parse(id); err != nil {
+		return messageCursor{}, err
+	}
+	return c, nil
+}
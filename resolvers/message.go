@@ -7,7 +7,10 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/messaging"
+	"github.com/mineracail/guardApi/middleware"
 	"github.com/mineracail/guardApi/models"
+	"github.com/mineracail/guardApi/notify"
 	"gorm.io/gorm"
 )
 
@@ -24,6 +27,13 @@ func FetchMessageByUUID(db *gorm.DB, id uuid.UUID) (*models.Message, error) {
 	return &message, nil
 }
 
+// isMessageParticipant reports whether the caller sent or received the message.
+// Nobody else, admins included, may read it.
+func isMessageParticipant(r *http.Request, message *models.Message) bool {
+	id := callerID(r)
+	return id != uuid.Nil && (message.SenderID == id || message.ReceiverID == id)
+}
+
 // CreateMessage handles the creation of a new Message.
 func CreateMessag(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	var message models.Message
@@ -50,7 +60,6 @@ func CreateMessage(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		SenderID uuid.UUID `json:"sender_id"`
 
 		ReceiverID string `json:"receiver_id"` // Keep as string for JSON parsing
-		Status     string `json:"status"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&messageRequest); err != nil {
@@ -80,17 +89,21 @@ func CreateMessage(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create the message with the parsed UUID
-	newMessage := &models.Message{
-		Content:    messageRequest.Content,
-		ReceiverID: receiverID,
-		SenderID:   messageRequest.SenderID,
-		Status:     messageRequest.Status,
-		CreatedAt:  time.Now(),
+	if receiverID == callerID(r) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "You cannot send a message to yourself",
+		})
+		return
 	}
 
-	result := db.Create(newMessage)
-	if result.Error != nil {
+	// The sender is always the caller and the message starts unread
+	var newMessage *models.Message
+	err = db.Transaction(func(tx *gorm.DB) error {
+		newMessage, err = messaging.Send(tx, callerID(r), receiverID, messageRequest.Content)
+		return err
+	})
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": "Failed to create message",
@@ -98,6 +111,9 @@ func CreateMessage(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	notify.Default.NotifyProfiles([]uuid.UUID{receiverID}, notify.CategoryMessages, "New message",
+		notify.Preview(newMessage.Content), map[string]string{"type": "message", "messageId": newMessage.ID.String()})
+
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newMessage)
 }
@@ -115,34 +131,35 @@ func CreateMessageToMultiple(db *gorm.DB, w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	var messages []models.Message
-	for _, recipientID := range messageRequest.Recipients {
-		message := models.Message{
-			Content:    messageRequest.Content,
-			SenderID:   messageRequest.SenderID,
-			ReceiverID: recipientID,
-		}
-		messages = append(messages, message)
-	}
-
-	if result := db.Create(&messages); result.Error != nil {
-		handleError(w, http.StatusInternalServerError, result.Error.Error())
+	if messageRequest.Content == "" || len(messageRequest.Recipients) == 0 {
+		handleError(w, http.StatusBadRequest, "content and recipients are required")
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, messages)
-}
-
-// GetAllMessages handles the retrieval of all Messages.
-func GetAllMessages(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	var messages []models.Message
-	result := db.Find(&messages)
-	if result.Error != nil {
-		handleError(w, http.StatusInternalServerError, result.Error.Error())
+	// Each recipient gets the message in their own conversation with the sender
+	sender := callerID(r)
+	messages := []models.Message{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, recipientID := range messageRequest.Recipients {
+			if recipientID == sender {
+				continue
+			}
+			message, err := messaging.Send(tx, sender, recipientID, messageRequest.Content)
+			if err != nil {
+				return err
+			}
+			messages = append(messages, *message)
+		}
+		return nil
+	})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	notify.Default.NotifyProfiles(messageRequest.Recipients, notify.CategoryMessages, "New message",
+		notify.Preview(messageRequest.Content), map[string]string{"type": "message"})
 
-	respondJSON(w, http.StatusOK, messages)
+	respondJSON(w, http.StatusCreated, messages)
 }
 
 // GetMessageByID retrieves a Message by their UUID.
@@ -168,6 +185,11 @@ func GetMessageByID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !isMessageParticipant(r, message) {
+		middleware.WriteForbidden(w)
+		return
+	}
+
 	respondJSON(w, http.StatusOK, message)
 }
 
@@ -189,12 +211,26 @@ func UpdateMessageByID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+	// Only the sender may edit a message
+	if message.SenderID != callerID(r) {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	// Only the content can change; status and read time follow the receiver
+	var input struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		handleError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	if input.Content == "" {
+		handleError(w, http.StatusBadRequest, "content is required")
+		return
+	}
 
-	if result := db.Save(&message); result.Error != nil {
+	if result := db.Model(message).Update("content", input.Content); result.Error != nil {
 		handleError(w, http.StatusInternalServerError, result.Error.Error())
 		return
 	}
@@ -202,6 +238,45 @@ func UpdateMessageByID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, message)
 }
 
+// MarkMessageRead marks the message in {id} read by its receiver. The first
+// read time is kept when the message is read again.
+func MarkMessageRead(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid Message UUID")
+		return
+	}
+
+	message, err := FetchMessageByUUID(db, id)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, http.StatusNotFound, "Message not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	// Only the receiver reads a message; not even admins read on their behalf
+	if message.ReceiverID != callerID(r) {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	err = db.Model(&models.Message{}).Where("id = ? AND read_at IS NULL", message.ID).
+		Updates(map[string]interface{}{"status": models.MessageRead, "read_at": time.Now()}).Error
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if message, err = FetchMessageByUUID(db, id); err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, message)
+}
+
 // DeleteMessageByID handles the deletion of a Message by their UUID.
 func DeleteMessageByID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	id, err := parseUUID(r)
@@ -220,6 +295,12 @@ func DeleteMessageByID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Admins may remove messages they cannot read
+	if !isMessageParticipant(r, message) && !middleware.IsAdmin(r.Context()) {
+		middleware.WriteForbidden(w)
+		return
+	}
+
 	if result := db.Delete(&message); result.Error != nil {
 		handleError(w, http.StatusInternalServerError, result.Error.Error())
 		return
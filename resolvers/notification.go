@@ -0,0 +1,193 @@
+package resolvers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/models"
+	"github.com/mineracail/guardApi/notify"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeviceInput is the payload for registering a device for push notifications.
+type DeviceInput struct {
+	Platform string `json:"platform"` // android or ios
+	Token    string `json:"token"`
+}
+
+// NotificationPreferenceInput updates the given notification preferences.
+type NotificationPreferenceInput struct {
+	Arrivals *bool `json:"arrivals"`
+	Messages *bool `json:"messages"`
+	Calendar *bool `json:"calendar"`
+}
+
+// RegisterDevice registers the caller's device for push notifications. A
+// token registered by another user, for instance on a shared phone, is
+// refused until that user signs out and unregisters it.
+func RegisterDevice(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerUserID(w, r)
+	if !ok {
+		return
+	}
+	var input DeviceInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	input.Token = strings.TrimSpace(input.Token)
+	if input.Platform != models.PlatformAndroid && input.Platform != models.PlatformIOS {
+		handleError(w, http.StatusBadRequest, "platform must be android or ios")
+		return
+	}
+	if input.Token == "" {
+		handleError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+	if !notify.ValidToken(input.Platform, input.Token) {
+		handleError(w, http.StatusBadRequest, "token is not a valid "+input.Platform+" device token")
+		return
+	}
+
+	// Re-registering refreshes the caller's own token; a token of another user is left alone
+	device := models.DeviceToken{UserID: userID, Platform: input.Platform, Token: input.Token, LastSeenAt: time.Now()}
+	result := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "token"}},
+		DoUpdates: clause.AssignmentColumns([]string{"platform", "last_seen_at"}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "device_tokens.user_id = excluded.user_id"}}},
+	}).Create(&device)
+	if result.Error != nil {
+		handleError(w, http.StatusInternalServerError, result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		handleError(w, http.StatusConflict, "This device is registered to another account, sign out there first")
+		return
+	}
+	if err := db.Where("token = ?", input.Token).First(&device).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, device)
+}
+
+// GetDevices lists the caller's registered devices.
+func GetDevices(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerUserID(w, r)
+	if !ok {
+		return
+	}
+	var devices []models.DeviceToken
+	if err := db.Where("user_id = ?", userID).Order("last_seen_at DESC").Find(&devices).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, devices)
+}
+
+// DeleteDevice unregisters the caller's device in {id}, for instance on
+// sign-out.
+func DeleteDevice(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerUserID(w, r)
+	if !ok {
+		return
+	}
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid device UUID")
+		return
+	}
+
+	result := db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.DeviceToken{})
+	if result.Error != nil {
+		handleError(w, http.StatusInternalServerError, result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		handleError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetNotificationPreferences returns the caller's notification preferences.
+func GetNotificationPreferences(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerUserID(w, r)
+	if !ok {
+		return
+	}
+	preference, err := notificationPreference(db, userID)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, preference)
+}
+
+// UpdateNotificationPreferences changes the caller's notification
+// preferences; categories left out of the payload keep their setting.
+func UpdateNotificationPreferences(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerUserID(w, r)
+	if !ok {
+		return
+	}
+	var input NotificationPreferenceInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	preference, err := notificationPreference(db, userID)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if input.Arrivals != nil {
+		preference.Arrivals = *input.Arrivals
+	}
+	if input.Messages != nil {
+		preference.Messages = *input.Messages
+	}
+	if input.Calendar != nil {
+		preference.Calendar = *input.Calendar
+	}
+	// Select every column so false is written instead of the column default
+	if err := db.Select("*").Clauses(clause.OnConflict{UpdateAll: true}).Create(preference).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, preference)
+}
+
+// notificationPreference returns the user's preferences, every category
+// enabled when they never set any.
+func notificationPreference(db *gorm.DB, userID uuid.UUID) (*models.NotificationPreference, error) {
+	preference := models.NotificationPreference{UserID: userID, Arrivals: true, Messages: true, Calendar: true}
+	err := db.Where("user_id = ?", userID).First(&preference).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return &preference, nil
+}
+
+// callerUserID returns the caller's user ID, writing a 401 for tokens issued
+// before users were introduced.
+func callerUserID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(r.Context()))
+	if err != nil {
+		middleware.WriteJSONError(w, http.StatusUnauthorized, "token has no user, try logging in again")
+		return uuid.Nil, false
+	}
+	return userID, true
+}
@@ -6,7 +6,6 @@ import (
 	"strings"
 
 	"github.com/google/uuid"
-	"github.com/lib/pq"
 	"github.com/mineracail/guardApi/models"
 	"gorm.io/gorm"
 )
@@ -37,132 +36,34 @@ func CreateParet(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusCreated, parent)
 }
 
-// AddSupervise adds a student to a parent's supervision list.
-func AddSupervise(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	parentID, err := parseUUID(r)
-	if err != nil {
-		handleError(w, http.StatusBadRequest, "Invalid parent UUID")
-		return
-	}
-
+func CreateParent(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	// The password is stored on the linked user, not on the parent row
 	var input struct {
-		StudentID uuid.UUID `json:"studentId"`
+		models.Parent
+		Password string `json:"password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		handleError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	parent := input.Parent
 
-	// Fetch the parent by UUID
-	var parent models.Parent
-	if err := db.First(&parent, "id = ?", parentID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			handleError(w, http.StatusNotFound, "Parent not found")
-		} else {
-			handleError(w, http.StatusInternalServerError, err.Error())
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.FirstOrCreate(&parent, models.Parent{Email: parent.Email}).Error; err != nil {
+			return err
 		}
-		return
-	}
-
-	// Check if the student already exists in the supervision list
-	if parent.Supervise == nil {
-		parent.Supervise = &pq.StringArray{}
-	}
-	for _, id := range *parent.Supervise {
-		if id == input.StudentID.String() {
-			handleError(w, http.StatusConflict, "Student is already supervised by this parent")
-			return
+		// Profiles without an email have no login
+		if models.NormalizeEmail(parent.Email) == "" {
+			return nil
 		}
-	}
-
-	// Add the student ID to the supervision list
-	// *parent.Supervise = append(*parent.Supervise, input.StudentID.String())
-
-	// Update the parent in the database
-	if err := db.Save(&parent).Error; err != nil {
-		handleError(w, http.StatusInternalServerError, "Failed to update parent supervision list: "+err.Error())
-		return
-	}
-
-	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"message": "Student successfully added to parent's supervision list",
-		"parent":  parent,
+		_, err := linkUser(tx, parent.Email, input.Password, nil, &parent.ID)
+		return err
 	})
-}
-// func AddSupervis(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-// 	parentID, err := parseUUID(r)
-// 	if err != nil {
-// 	  handleError(w, http.StatusBadRequest, "Invalid parent UUID")
-// 	  return
-// 	}
-  
-// 	var input []struct {
-// 	  StudentID uuid.UUID `json:"studentId"`
-// 	}
-// 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-// 	  handleError(w, http.StatusBadRequest, "Invalid request payload")
-// 	  return
-// 	}
-  
-// 	// Fetch the parent by UUID
-// 	var parent models.Parent
-// 	if err := db.First(&parent, "id = ?", parentID).Error; err != nil {
-// 	  if err == gorm.ErrRecordNotFound {
-// 		handleError(w, http.StatusNotFound, "Parent not found")
-// 	  } else {
-// 		handleError(w, http.StatusInternalServerError, err.Error())
-// 	  }
-// 	  return
-// 	}
-  
-// 	// Loop through the student IDs and add them to the supervision list
-// 	for _, student := range input {
-// 	  // Check if the student already exists in the supervision list
-// 	  if parent.Supervise == nil {
-// 		parent.Supervise = &pq.StringArray{}
-// 	  }
-  
-// 	  found := false
-// 	  for _, id := range *parent.Supervise {
-// 		if id == student.StudentID.String() {
-// 		  found = true
-// 		  break
-// 		}
-// 	  }
-  
-// 	  if found {
-// 		continue // Skip if student is already supervised
-// 	  }
-  
-// 	  // Add the student ID to the supervision list
-// 	  *parent.Supervise = append(*parent.Supervise, student.StudentID.String())
-// 	}
-  
-// 	// Update the parent in the database
-// 	if err := db.Save(&parent).Error; err != nil {
-// 	  handleError(w, http.StatusInternalServerError, "Failed to update parent supervision list: "+err.Error())
-// 	  return
-// 	}
-  
-// 	respondJSON(w, http.StatusOK, map[string]interface{}{
-// 	  "message": "Students successfully added to parent's supervision list",
-// 	  "parent":  parent,
-// 	})
-//   }
-  
-
-func CreateParent(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	var parent models.Parent
-	if err := json.NewDecoder(r.Body).Decode(&parent); err != nil {
-		handleError(w, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-
-	if err := db.FirstOrCreate(&parent, models.Parent{Email: parent.Email}).Error; err != nil {
+	if err != nil {
 		if strings.Contains(err.Error(), "duplicate key") { // Check for unique constraint errors
 			handleError(w, http.StatusConflict, "Parent already exists")
 		} else {
-			handleError(w, http.StatusInternalServerError, err.Error())
+			handleCredentialError(w, err)
 		}
 		return
 	}
@@ -222,9 +123,10 @@ func GetChildByParentID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch all students supervised by this parent
+	// Fetch all students this parent is a guardian of
 	var students []models.Student
-	if err := db.Where("id = ANY(?)", parent.Supervise).Find(&students).Error; err != nil {
+	if err := db.Joins("JOIN guardianships ON guardianships.student_id = students.id").
+		Where("guardianships.parent_id = ?", parent.ID).Find(&students).Error; err != nil {
 		handleError(w, http.StatusInternalServerError, "Error fetching students: "+err.Error())
 		return
 	}
@@ -257,13 +159,26 @@ func UpdateParentByID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&parent); err != nil {
+	// A new password in the payload is stored on the linked user
+	input := struct {
+		*models.Parent
+		Password string `json:"password"`
+	}{Parent: parent}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		handleError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	// The URL decides which profile changes, whatever id the payload carries
+	parent.ID = id
 
-	if result := db.Save(&parent); result.Error != nil {
-		handleError(w, http.StatusInternalServerError, result.Error.Error())
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&parent).Error; err != nil {
+			return err
+		}
+		return updateUserCredentials(tx, "parent_id", parent.ID, parent.Email, input.Password)
+	})
+	if err != nil {
+		handleCredentialError(w, err)
 		return
 	}
 
@@ -288,8 +203,14 @@ func DeleteParentByID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if result := db.Delete(&parent); result.Error != nil {
-		handleError(w, http.StatusInternalServerError, result.Error.Error())
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&parent).Error; err != nil {
+			return err
+		}
+		return deleteOrphanUsers(tx)
+	})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
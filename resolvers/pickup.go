@@ -0,0 +1,507 @@
+package resolvers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// maxPickupPhotoSize is the largest photo accepted for an authorized pickup.
+	maxPickupPhotoSize = 2 << 20
+	// pickupCodeLength is the number of characters in a pickup code.
+	pickupCodeLength = 8
+	// maxPickupCodeAdvance is how far ahead a pickup code can be issued.
+	maxPickupCodeAdvance = 14 * 24 * time.Hour
+	// pickupQRPrefix marks pickup codes encoded in a QR code.
+	pickupQRPrefix = "guardapi-pickup:"
+)
+
+var (
+	errPickupCodeUsed       = errors.New("pickup code has already been used")
+	errPickupCodeNotAllowed = errors.New("issuing guardian may no longer have this student collected")
+)
+
+// AuthorizedPickupInput is the payload for registering or updating an
+// authorized pickup person. The photo is base64 encoded.
+type AuthorizedPickupInput struct {
+	FirstName   string     `json:"firstName"`
+	LastName    string     `json:"lastName"`
+	Relation    string     `json:"relation"`
+	PhoneNumber string     `json:"phoneNumber"`
+	Photo       []byte     `json:"photo"`
+	ValidFrom   *time.Time `json:"validFrom"`
+	ValidUntil  *time.Time `json:"validUntil"`
+}
+
+// PickupCodeRequest is the payload for issuing a pickup code.
+type PickupCodeRequest struct {
+	StudentID          uuid.UUID  `json:"studentId"`
+	AuthorizedPickupID *uuid.UUID `json:"authorizedPickupId"` // Omit when the parent collects in person
+	Date               string     `json:"date"`               // YYYY-MM-DD, defaults to today
+}
+
+// PickupCodeVerifyRequest is the payload staff send from the gate.
+type PickupCodeVerifyRequest struct {
+	Code string `json:"code"`
+}
+
+// CreateAuthorizedPickup registers an adult who may collect the student in {id}.
+func CreateAuthorizedPickup(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	studentID, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid student UUID")
+		return
+	}
+	if _, err := FetchStudentByUUID(db, studentID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, http.StatusNotFound, "Student not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if ok, err := canManagePickups(db, r, studentID); err != nil {
+		handleError(w, http.StatusInternalServerError, "Error checking student access")
+		return
+	} else if !ok {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	var input AuthorizedPickupInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	pickup := models.AuthorizedPickup{StudentID: studentID}
+	if !middleware.IsAdmin(r.Context()) {
+		parentID := callerID(r)
+		pickup.AddedByParentID = &parentID
+	}
+	if msg := applyAuthorizedPickupInput(&pickup, input); msg != "" {
+		handleError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if pickup.FirstName == "" || pickup.LastName == "" {
+		handleError(w, http.StatusBadRequest, "First and last name are required")
+		return
+	}
+
+	if err := db.Create(&pickup).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, pickup)
+}
+
+// GetAuthorizedPickupsByStudent lists the authorized pickup persons of the
+// student in {id}, without their photos.
+func GetAuthorizedPickupsByStudent(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	studentID, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid student UUID")
+		return
+	}
+
+	var pickups []models.AuthorizedPickup
+	if err := db.Omit("photo").Where("student_id = ?", studentID).Order("created_at").Find(&pickups).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, pickups)
+}
+
+// GetAuthorizedPickupPhoto serves the photo of the authorized pickup in {id}.
+func GetAuthorizedPickupPhoto(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	pickup, ok := fetchAuthorizedPickup(db, w, r)
+	if !ok {
+		return
+	}
+	// Staff check photos at the gate; otherwise only the student's guardians may see them
+	if !middleware.HasRole(r.Context(), middleware.UserTypeStaff) {
+		guardianship, err := guardianshipOf(db, callerID(r), pickup.StudentID)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, "Error checking student access")
+			return
+		}
+		if guardianship == nil {
+			middleware.WriteForbidden(w)
+			return
+		}
+	}
+	if len(pickup.Photo) == 0 {
+		handleError(w, http.StatusNotFound, "No photo for this pickup")
+		return
+	}
+
+	w.Header().Set("Content-Type", pickup.PhotoContentType)
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(pickup.Photo)
+}
+
+// UpdateAuthorizedPickup changes the details, photo or validity window of the
+// authorized pickup in {id}.
+func UpdateAuthorizedPickup(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	pickup, ok := fetchAuthorizedPickup(db, w, r)
+	if !ok {
+		return
+	}
+	if ok, err := canManagePickups(db, r, pickup.StudentID); err != nil {
+		handleError(w, http.StatusInternalServerError, "Error checking student access")
+		return
+	} else if !ok {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	var input AuthorizedPickupInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if msg := applyAuthorizedPickupInput(pickup, input); msg != "" {
+		handleError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if err := db.Save(pickup).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, pickup)
+}
+
+// RevokeAuthorizedPickup withdraws the permission of the authorized pickup in
+// {id}. Pickup codes already issued for them stop working.
+func RevokeAuthorizedPickup(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	pickup, ok := fetchAuthorizedPickup(db, w, r)
+	if !ok {
+		return
+	}
+	if ok, err := canManagePickups(db, r, pickup.StudentID); err != nil {
+		handleError(w, http.StatusInternalServerError, "Error checking student access")
+		return
+	} else if !ok {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	if pickup.RevokedAt == nil {
+		if err := db.Model(pickup).Update("revoked_at", time.Now()).Error; err != nil {
+			handleError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// IssuePickupCode creates a one-time code for collecting a student on one day.
+// Guardians allowed to pick up may issue codes for themselves; guardians with
+// custody may also issue them for an authorized pickup person.
+func IssuePickupCode(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var req PickupCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	parentID := callerID(r)
+	guardianship, err := guardianshipOf(db, parentID, req.StudentID)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Error checking student access")
+		return
+	}
+	if guardianship == nil ||
+		(req.AuthorizedPickupID == nil && !guardianship.CanPickup) ||
+		(req.AuthorizedPickupID != nil && !guardianship.HasCustody) {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	today := helpers.Today()
+	if req.Date == "" {
+		req.Date = today
+	}
+	day, err := helpers.ParseDate(req.Date)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid date, expected YYYY-MM-DD")
+		return
+	}
+	if req.Date < today || time.Until(day) > maxPickupCodeAdvance {
+		handleError(w, http.StatusBadRequest, "Pickup codes can only be issued for today or the next two weeks")
+		return
+	}
+
+	if req.AuthorizedPickupID != nil {
+		var pickup models.AuthorizedPickup
+		if err := db.Omit("photo").Where("id = ? AND student_id = ?", *req.AuthorizedPickupID, req.StudentID).First(&pickup).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				handleError(w, http.StatusNotFound, "Authorized pickup not found")
+			} else {
+				handleError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		if !pickup.ActiveOn(day) {
+			handleError(w, http.StatusBadRequest, "Authorized pickup is not valid on that day")
+			return
+		}
+	}
+
+	code, err := helpers.GenerateShortCode(pickupCodeLength)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Error generating pickup code")
+		return
+	}
+	pickupCode := models.PickupCode{
+		StudentID:          req.StudentID,
+		IssuedByParentID:   parentID,
+		AuthorizedPickupID: req.AuthorizedPickupID,
+		CodeHash:           helpers.HashToken(code),
+		ValidOn:            req.Date,
+		ExpiresAt:          day.AddDate(0, 0, 1),
+	}
+	if err := db.Create(&pickupCode).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"code":       code,
+		"qr":         pickupQRPrefix + code,
+		"pickupCode": pickupCode,
+	})
+}
+
+// CheckPickupCode shows gate staff who a pickup code is for, with the
+// authorized pickup's photo link, without using the code up.
+func CheckPickupCode(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var req PickupCodeVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	pickupCode, ok := fetchUsablePickupCode(db, w, req.Code)
+	if !ok {
+		return
+	}
+
+	response := map[string]interface{}{"pickupCode": pickupCode}
+	if pickupCode.AuthorizedPickup != nil && pickupCode.AuthorizedPickup.PhotoContentType != "" {
+		response["photoUrl"] = "/pickups/" + pickupCode.AuthorizedPickup.ID.String() + "/photo"
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// RedeemPickupCode uses a pickup code up and records the dismissal of the
+// student by the calling teacher or admin. The issuing guardian's
+// guardianship is checked again in the same transaction.
+func RedeemPickupCode(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var req PickupCodeVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	pickupCode, ok := fetchUsablePickupCode(db, w, req.Code)
+	if !ok {
+		return
+	}
+
+	dismissal := models.Dismissal{
+		StudentID:          pickupCode.StudentID,
+		StaffID:            callerID(r),
+		PickupCodeID:       pickupCode.ID,
+		ParentID:           pickupCode.IssuedByParentID,
+		AuthorizedPickupID: pickupCode.AuthorizedPickupID,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Hold the guardianship so it cannot be withdrawn while the student is handed over
+		allowed, err := pickupCodeAllowed(tx.Clauses(clause.Locking{Strength: "SHARE"}), pickupCode)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return errPickupCodeNotAllowed
+		}
+
+		// A concurrent redemption leaves nothing to update
+		result := tx.Model(&models.PickupCode{}).
+			Where("id = ? AND used_at IS NULL", pickupCode.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errPickupCodeUsed
+		}
+		if err := tx.Create(&dismissal).Error; err != nil {
+			return err
+		}
+		// Handing the student over checks them out and completes the pickup
+		return advanceAttendance(tx, dismissal.StudentID, helpers.Today(), requestActor(r), "pickup code",
+			models.AttendanceCheckedOut, models.AttendancePickedUp)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errPickupCodeUsed):
+			handleError(w, http.StatusConflict, "Pickup code has already been used")
+		case errors.Is(err, errPickupCodeNotAllowed):
+			handleError(w, http.StatusForbidden, "The guardian who issued this code may no longer have this student collected")
+		default:
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, dismissal)
+}
+
+// fetchUsablePickupCode looks up a pickup code that can be used right now,
+// writing the error response itself.
+func fetchUsablePickupCode(db *gorm.DB, w http.ResponseWriter, code string) (*models.PickupCode, bool) {
+	code = strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(code, pickupQRPrefix)))
+	if code == "" {
+		handleError(w, http.StatusBadRequest, "Code is required")
+		return nil, false
+	}
+
+	var pickupCode models.PickupCode
+	err := db.Preload("Student").Preload("IssuedByParent").
+		Preload("AuthorizedPickup", func(tx *gorm.DB) *gorm.DB { return tx.Omit("photo") }).
+		Where("code_hash = ?", helpers.HashToken(code)).First(&pickupCode).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, http.StatusNotFound, "Pickup code not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+
+	now := helpers.SchoolNow()
+	switch {
+	case pickupCode.UsedAt != nil:
+		handleError(w, http.StatusConflict, "Pickup code has already been used")
+	case pickupCode.ValidOn != now.Format(helpers.DateLayout) || now.After(pickupCode.ExpiresAt):
+		handleError(w, http.StatusBadRequest, "Pickup code is not valid today")
+	case pickupCode.AuthorizedPickup != nil && !pickupCode.AuthorizedPickup.ActiveOn(now):
+		handleError(w, http.StatusForbidden, "Authorized pickup is no longer allowed to collect this student")
+	default:
+		allowed, err := pickupCodeAllowed(db, &pickupCode)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, "Error checking student access")
+			return nil, false
+		}
+		if !allowed {
+			handleError(w, http.StatusForbidden, "The guardian who issued this code may no longer have this student collected")
+			return nil, false
+		}
+		return &pickupCode, true
+	}
+	return nil, false
+}
+
+// pickupCodeAllowed reports whether the guardianship of the parent who issued
+// the code still allows it, with the rules IssuePickupCode applies: the parent
+// may pick up the student, or has custody when the code is for an authorized
+// pickup person.
+func pickupCodeAllowed(db *gorm.DB, pickupCode *models.PickupCode) (bool, error) {
+	guardianship, err := guardianshipOf(db, pickupCode.IssuedByParentID, pickupCode.StudentID)
+	if err != nil || guardianship == nil {
+		return false, err
+	}
+	if pickupCode.AuthorizedPickupID != nil {
+		return guardianship.HasCustody, nil
+	}
+	return guardianship.CanPickup, nil
+}
+
+// fetchAuthorizedPickup loads the authorized pickup in {id}, writing the error
+// response itself.
+func fetchAuthorizedPickup(db *gorm.DB, w http.ResponseWriter, r *http.Request) (*models.AuthorizedPickup, bool) {
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid pickup UUID")
+		return nil, false
+	}
+
+	var pickup models.AuthorizedPickup
+	if err := db.Where("id = ?", id).First(&pickup).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, http.StatusNotFound, "Authorized pickup not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return &pickup, true
+}
+
+// canManagePickups reports whether the caller may change who collects the
+// student: admins, and guardians with custody.
+func canManagePickups(db *gorm.DB, r *http.Request, studentID uuid.UUID) (bool, error) {
+	if middleware.IsAdmin(r.Context()) {
+		return true, nil
+	}
+	guardianship, err := guardianshipOf(db, callerID(r), studentID)
+	if err != nil {
+		return false, err
+	}
+	return guardianship != nil && guardianship.HasCustody, nil
+}
+
+// applyAuthorizedPickupInput copies the non-empty input fields onto the
+// pickup and returns a validation message, or "" when the input is valid.
+func applyAuthorizedPickupInput(pickup *models.AuthorizedPickup, input AuthorizedPickupInput) string {
+	if input.FirstName != "" {
+		pickup.FirstName = input.FirstName
+	}
+	if input.LastName != "" {
+		pickup.LastName = input.LastName
+	}
+	if input.Relation != "" {
+		pickup.Relation = input.Relation
+	}
+	if input.PhoneNumber != "" {
+		pickup.PhoneNumber = input.PhoneNumber
+	}
+	if input.ValidFrom != nil {
+		pickup.ValidFrom = input.ValidFrom
+	}
+	if input.ValidUntil != nil {
+		pickup.ValidUntil = input.ValidUntil
+	}
+	if pickup.ValidFrom != nil && pickup.ValidUntil != nil && pickup.ValidUntil.Before(*pickup.ValidFrom) {
+		return "validUntil must not be before validFrom"
+	}
+
+	if len(input.Photo) > 0 {
+		if len(input.Photo) > maxPickupPhotoSize {
+			return "Photo must be at most 2 MB"
+		}
+		contentType := http.DetectContentType(input.Photo)
+		if contentType != "image/jpeg" && contentType != "image/png" {
+			return "Photo must be a JPEG or PNG image"
+		}
+		pickup.Photo, pickup.PhotoContentType = input.Photo, contentType
+	}
+	return ""
+}
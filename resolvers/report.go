@@ -0,0 +1,212 @@
+package resolvers
+
+import (
+	"net/http"
+
+	"github.com/mineracail/guardApi/alerts"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"gorm.io/gorm"
+)
+
+// Attendance report groupings.
+const (
+	ReportByStudent = "student"
+	ReportByGrade   = "grade"
+	ReportByStaff   = "staff"
+)
+
+// AttendanceReportRow holds the attendance figures of one student, grade or
+// supervising staff member over the report's date range. Absence streaks
+// count consecutive school days (Monday to Friday) without a confirmed
+// school arrival; for grades and staff they are the longest among students.
+type AttendanceReportRow struct {
+	Key                  string `json:"key"`   // Student ID, grade or staff ID
+	Label                string `json:"label"` // Student or staff name, or the grade
+	Grade                string `json:"grade"`
+	Students             int64  `json:"students"`
+	HomeConfirmed        int64  `json:"homeConfirmed"`
+	HomeUnconfirmed      int64  `json:"homeUnconfirmed"`
+	SchoolConfirmed      int64  `json:"schoolConfirmed"`
+	SchoolUnconfirmed    int64  `json:"schoolUnconfirmed"`
+	LateArrivals         int64  `json:"lateArrivals"`
+	AbsentDays           int64  `json:"absentDays"`
+	LongestAbsenceStreak int64  `json:"longestAbsenceStreak"`
+	CurrentAbsenceStreak int64  `json:"currentAbsenceStreak"` // Streak running up to the end of the range
+}
+
+// perStudentReport computes the figures of every student in the roster. A
+// confirmed school arrival is late when it was recorded after the cutoff of
+// the student's grade, or the default cutoff.
+const perStudentReport = `
+WITH roster AS (
+	SELECT id, first_name, last_name, grade FROM students
+	WHERE @grade = '' OR grade = @grade
+),
+home AS (
+	SELECT student_id,
+		count(*) FILTER (WHERE confirmed) AS confirmed,
+		count(*) FILTER (WHERE NOT confirmed) AS unconfirmed
+	FROM home_arrivals
+	WHERE created_at >= @from AND created_at < @to
+	GROUP BY student_id
+),
+school AS (
+	SELECT a.student_id,
+		count(*) FILTER (WHERE a.confirmed) AS confirmed,
+		count(*) FILTER (WHERE NOT a.confirmed) AS unconfirmed,
+		count(*) FILTER (WHERE a.confirmed AND
+			(a.created_at AT TIME ZONE @tz)::time > COALESCE(c.cutoff, @cutoff)::time) AS late
+	FROM school_arrivals a
+	JOIN roster s ON s.id::text = a.student_id
+	LEFT JOIN grade_cutoffs c ON c.grade = s.grade
+	WHERE a.created_at >= @from AND a.created_at < @to
+	GROUP BY a.student_id
+),
+school_days AS (
+	SELECT d::date AS day, row_number() OVER (ORDER BY d) AS n
+	FROM generate_series(@first::date, @last::date, interval '1 day') AS d
+	WHERE extract(isodow FROM d) < 6
+),
+present AS (
+	SELECT DISTINCT student_id, (created_at AT TIME ZONE @tz)::date AS day
+	FROM school_arrivals
+	WHERE confirmed AND created_at >= @from AND created_at < @to
+),
+absences AS (
+	-- Consecutive absent days share the same n - row_number()
+	SELECT s.id AS student_id, d.n,
+		d.n - row_number() OVER (PARTITION BY s.id ORDER BY d.n) AS streak
+	FROM roster s
+	CROSS JOIN school_days d
+	LEFT JOIN present p ON p.student_id = s.id::text AND p.day = d.day
+	WHERE p.student_id IS NULL
+),
+streaks AS (
+	SELECT student_id, count(*) AS days, max(n) AS last_n
+	FROM absences
+	GROUP BY student_id, streak
+),
+absence_totals AS (
+	SELECT student_id,
+		sum(days)::bigint AS absent_days,
+		max(days) AS longest_absence_streak,
+		max(days) FILTER (WHERE last_n = (SELECT count(*) FROM school_days)) AS current_absence_streak
+	FROM streaks
+	GROUP BY student_id
+),
+per_student AS (
+	SELECT s.id, s.first_name, s.last_name, s.grade,
+		COALESCE(h.confirmed, 0) AS home_confirmed,
+		COALESCE(h.unconfirmed, 0) AS home_unconfirmed,
+		COALESCE(sc.confirmed, 0) AS school_confirmed,
+		COALESCE(sc.unconfirmed, 0) AS school_unconfirmed,
+		COALESCE(sc.late, 0) AS late_arrivals,
+		COALESCE(ab.absent_days, 0) AS absent_days,
+		COALESCE(ab.longest_absence_streak, 0) AS longest_absence_streak,
+		COALESCE(ab.current_absence_streak, 0) AS current_absence_streak
+	FROM roster s
+	LEFT JOIN home h ON h.student_id = s.id::text
+	LEFT JOIN school sc ON sc.student_id = s.id::text
+	LEFT JOIN absence_totals ab ON ab.student_id = s.id
+)
+`
+
+// reportTotals sums the per-student figures of a group.
+const reportTotals = `
+	count(*) AS students,
+	sum(home_confirmed)::bigint AS home_confirmed,
+	sum(home_unconfirmed)::bigint AS home_unconfirmed,
+	sum(school_confirmed)::bigint AS school_confirmed,
+	sum(school_unconfirmed)::bigint AS school_unconfirmed,
+	sum(late_arrivals)::bigint AS late_arrivals,
+	sum(absent_days)::bigint AS absent_days,
+	max(longest_absence_streak) AS longest_absence_streak,
+	max(current_absence_streak) AS current_absence_streak
+`
+
+// reportQueries select the report rows for each grouping.
+var reportQueries = map[string]string{
+	ReportByStudent: perStudentReport + `
+SELECT id::text AS key, first_name || ' ' || last_name AS label, grade,
+	1 AS students, home_confirmed, home_unconfirmed, school_confirmed, school_unconfirmed,
+	late_arrivals, absent_days, longest_absence_streak, current_absence_streak
+FROM per_student
+ORDER BY grade, last_name, first_name`,
+	ReportByGrade: perStudentReport + `
+SELECT grade AS key, grade AS label, grade,` + reportTotals + `
+FROM per_student
+GROUP BY grade
+ORDER BY grade`,
+	ReportByStaff: perStudentReport + `
+SELECT st.id::text AS key, st.first_name || ' ' || st.last_name AS label, st.supervise_grade AS grade,` + reportTotals + `
+FROM per_student p
+JOIN staffs st ON st.supervise_grade = p.grade
+GROUP BY st.id, st.first_name, st.last_name, st.supervise_grade
+ORDER BY st.supervise_grade, st.last_name, st.first_name`,
+}
+
+// GetAttendanceReport aggregates arrivals and absences over ?from=&to= (or
+// ?date=), the current week by default, grouped by ?groupBy=student, grade or
+// staff. ?grade= limits the report to one grade; teachers only get the grade
+// they supervise.
+func GetAttendanceReport(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	dates, ok := dateRangeParam(w, r, helpers.WeekRange(helpers.SchoolNow()))
+	if !ok {
+		return
+	}
+	groupBy := r.URL.Query().Get("groupBy")
+	if groupBy == "" {
+		groupBy = ReportByStudent
+	}
+	query, ok := reportQueries[groupBy]
+	if !ok {
+		handleError(w, http.StatusBadRequest, "groupBy must be student, grade or staff")
+		return
+	}
+
+	grade := r.URL.Query().Get("grade")
+	if !middleware.IsAdmin(r.Context()) {
+		// An empty grade means the whole school, which only admins may see
+		staff, err := FetchStaffByUUID(db, callerID(r))
+		if err != nil || staff.SuperviseGrade == "" {
+			middleware.WriteForbidden(w)
+			return
+		}
+		if grade != "" && grade != staff.SuperviseGrade {
+			middleware.WriteForbidden(w)
+			return
+		}
+		grade = staff.SuperviseGrade
+	}
+
+	// Absences are only counted for school days that have already started
+	first := dates.From.In(helpers.SchoolLocation)
+	last := dates.To.In(helpers.SchoolLocation).AddDate(0, 0, -1)
+	if today := helpers.DayRange(helpers.SchoolNow()).From; last.After(today) {
+		last = today
+	}
+
+	rows := []AttendanceReportRow{}
+	err := db.Raw(query, map[string]interface{}{
+		"grade":  grade,
+		"from":   dates.From,
+		"to":     dates.To,
+		"first":  first.Format(helpers.DateLayout),
+		"last":   last.Format(helpers.DateLayout),
+		"tz":     helpers.SchoolTimeZoneName(),
+		"cutoff": alerts.ConfiguredDefaultCutoff(),
+	}).Scan(&rows).Error
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"from":    first.Format(helpers.DateLayout),
+		"to":      dates.To.In(helpers.SchoolLocation).AddDate(0, 0, -1).Format(helpers.DateLayout),
+		"groupBy": groupBy,
+		"grade":   grade,
+		"rows":    rows,
+	})
+}
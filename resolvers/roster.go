@@ -0,0 +1,555 @@
+package resolvers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// maxRosterBytes bounds uploaded roster files.
+const maxRosterBytes = 5 << 20
+
+// Roster change actions.
+const (
+	RosterCreate    = "create"
+	RosterUpdate    = "update"
+	RosterUnchanged = "unchanged"
+)
+
+// rosterColumns lists the roster CSV columns; the required ones must be in
+// the header and filled in on every row. A student is identified by name and
+// date of birth, a parent by email, so a student with two parents takes two
+// rows.
+var rosterColumns = []struct {
+	Name     string
+	Required bool
+}{
+	{"student_first_name", true},
+	{"student_last_name", true},
+	{"student_date_of_birth", true},
+	{"grade", true},
+	{"student_email", false},
+	{"student_phone", false},
+	{"student_address", false},
+	{"student_gender", false},
+	{"parent_first_name", true},
+	{"parent_last_name", true},
+	{"parent_email", true},
+	{"parent_phone", false},
+	{"parent_address", false},
+	{"relation", false},    // guardian by default
+	{"has_custody", false}, // true unless the relation is emergency_contact
+	{"can_pickup", false},  // true unless the relation is emergency_contact
+}
+
+// errRosterRollback rolls back dry runs and imports with invalid rows.
+var errRosterRollback = errors.New("roster import rolled back")
+
+// RosterRow is one validated line of a roster.
+type RosterRow struct {
+	Line         int
+	Student      models.Student
+	Parent       models.Parent
+	Guardianship models.Guardianship
+}
+
+// RosterError reports why a roster line cannot be imported.
+type RosterError struct {
+	Line    int    `json:"line"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// RosterChange is a record the import creates or updates.
+type RosterChange struct {
+	Line   int      `json:"line"`
+	Entity string   `json:"entity"` // student, parent or guardianship
+	Action string   `json:"action"` // create or update
+	Key    string   `json:"key"`
+	Fields []string `json:"fields,omitempty"` // Updated fields
+}
+
+// RosterCounts counts the records of one kind by action.
+type RosterCounts struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+}
+
+func (c *RosterCounts) add(action string) {
+	switch action {
+	case RosterCreate:
+		c.Created++
+	case RosterUpdate:
+		c.Updated++
+	default:
+		c.Unchanged++
+	}
+}
+
+// RosterReport is the outcome of a roster import. Nothing is applied unless
+// every row is valid; the diff then shows what the valid rows would change.
+type RosterReport struct {
+	Applied       bool           `json:"applied"`
+	Rows          int            `json:"rows"`
+	Errors        []RosterError  `json:"errors"`
+	Students      RosterCounts   `json:"students"`
+	Parents       RosterCounts   `json:"parents"`
+	Guardianships RosterCounts   `json:"guardianships"`
+	Changes       []RosterChange `json:"changes"`
+}
+
+// ImportRosterCSV imports the roster CSV in the request body or in the
+// multipart "file" field. It is a dry run unless ?apply=true; either way the
+// response lists every change and every invalid row.
+func ImportRosterCSV(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	apply, err := strconv.ParseBool(r.URL.Query().Get("apply"))
+	if err != nil && r.URL.Query().Get("apply") != "" {
+		handleError(w, http.StatusBadRequest, "apply must be true or false")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRosterBytes)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			handleError(w, http.StatusBadRequest, "Missing roster file")
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	rows, rowErrors, err := ParseRoster(body)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	report, err := ImportRoster(db, rows, rowErrors, apply)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	status := http.StatusOK
+	if len(report.Errors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	respondJSON(w, status, report)
+}
+
+// ParseRoster reads and validates a roster CSV. Invalid lines are returned as
+// row errors; the error is only set when the file itself cannot be read.
+func ParseRoster(r io.Reader) ([]RosterRow, []RosterError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading roster header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", "_"))
+		columns[name] = i
+	}
+	known := make(map[string]bool, len(rosterColumns))
+	for _, column := range rosterColumns {
+		known[column.Name] = true
+		if _, ok := columns[column.Name]; column.Required && !ok {
+			return nil, nil, fmt.Errorf("missing roster column %q", column.Name)
+		}
+	}
+	for name := range columns {
+		if !known[name] {
+			return nil, nil, fmt.Errorf("unknown roster column %q", name)
+		}
+	}
+
+	var rows []RosterRow
+	var rowErrors []RosterError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rowErrors = append(rowErrors, RosterError{Line: parseErr.Line, Message: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading roster: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		row, errs := parseRosterRow(line, field)
+		if len(errs) > 0 {
+			rowErrors = append(rowErrors, errs...)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, nil
+}
+
+// parseRosterRow validates one roster line.
+func parseRosterRow(line int, field func(string) string) (RosterRow, []RosterError) {
+	var errs []RosterError
+	fail := func(column, message string) {
+		errs = append(errs, RosterError{Line: line, Column: column, Message: message})
+	}
+	for _, column := range rosterColumns {
+		if column.Required && field(column.Name) == "" {
+			fail(column.Name, "is required")
+		}
+	}
+
+	row := RosterRow{
+		Line: line,
+		Student: models.Student{
+			FirstName:   field("student_first_name"),
+			LastName:    field("student_last_name"),
+			DateOfBirth: field("student_date_of_birth"),
+			Grade:       field("grade"),
+			Email:       field("student_email"),
+			PhoneNumber: field("student_phone"),
+			Address:     field("student_address"),
+		},
+		Parent: models.Parent{
+			FirstName:   field("parent_first_name"),
+			LastName:    field("parent_last_name"),
+			Email:       models.NormalizeEmail(field("parent_email")),
+			PhoneNumber: field("parent_phone"),
+			Address:     field("parent_address"),
+		},
+		Guardianship: models.Guardianship{Relation: strings.ToLower(field("relation"))},
+	}
+	if gender := field("student_gender"); gender != "" {
+		row.Student.Gender = &gender
+	}
+
+	if dob := row.Student.DateOfBirth; dob != "" {
+		if _, err := helpers.ParseDate(dob); err != nil {
+			fail("student_date_of_birth", "expected YYYY-MM-DD")
+		}
+	}
+	if row.Parent.Email != "" {
+		if _, err := mail.ParseAddress(row.Parent.Email); err != nil {
+			fail("parent_email", "is not a valid email address")
+		}
+	}
+	if row.Student.Email != "" {
+		if _, err := mail.ParseAddress(row.Student.Email); err != nil {
+			fail("student_email", "is not a valid email address")
+		}
+	}
+
+	if row.Guardianship.Relation == "" {
+		row.Guardianship.Relation = models.RelationGuardian
+	}
+	if !models.ValidRelation(row.Guardianship.Relation) {
+		fail("relation", "must be mother, father, guardian, grandparent, emergency_contact or other")
+	}
+	defaultFlag := row.Guardianship.Relation != models.RelationEmergencyContact
+	for _, flag := range []struct {
+		column string
+		value  *bool
+	}{
+		{"has_custody", &row.Guardianship.HasCustody},
+		{"can_pickup", &row.Guardianship.CanPickup},
+	} {
+		value, ok := parseRosterBool(field(flag.column), defaultFlag)
+		if !ok {
+			fail(flag.column, "must be true or false")
+		}
+		*flag.value = value
+	}
+
+	return row, errs
+}
+
+// parseRosterBool reads a yes/no roster cell, def when it is empty.
+func parseRosterBool(value string, def bool) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "":
+		return def, true
+	case "yes", "y":
+		return true, true
+	case "no", "n":
+		return false, true
+	}
+	b, err := strconv.ParseBool(value)
+	return b, err == nil
+}
+
+// rosterStudentKey identifies a student within a roster.
+func rosterStudentKey(student models.Student) string {
+	return fmt.Sprintf("%s, %s (%s)", strings.ToLower(student.LastName), strings.ToLower(student.FirstName), student.DateOfBirth)
+}
+
+// ImportRoster upserts the students, parents and guardianships of rows in a
+// single transaction, adding to rowErrors any row that conflicts with an
+// earlier one or with existing records. The transaction is rolled back unless
+// apply is set and every row is valid, so a dry run reports exactly what an
+// import would do.
+func ImportRoster(db *gorm.DB, rows []RosterRow, rowErrors []RosterError, apply bool) (*RosterReport, error) {
+	invalidLines := make(map[int]bool)
+	for _, rowError := range rowErrors {
+		invalidLines[rowError.Line] = true
+	}
+	report := &RosterReport{
+		Rows:    len(rows) + len(invalidLines),
+		Errors:  append([]RosterError{}, rowErrors...),
+		Changes: []RosterChange{},
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		students := make(map[string]RosterRow)
+		parents := make(map[string]RosterRow)
+		studentIDs := make(map[string]uuid.UUID)
+		parentIDs := make(map[string]uuid.UUID)
+		links := make(map[string]int)
+
+		for _, row := range rows {
+			studentKey := rosterStudentKey(row.Student)
+			linkKey := row.Parent.Email + " -> " + studentKey
+			if first, ok := students[studentKey]; ok && !sameRosterStudent(first.Student, row.Student) {
+				report.Errors = append(report.Errors, RosterError{Line: row.Line, Message: fmt.Sprintf("student details differ from line %d", first.Line)})
+				continue
+			}
+			if first, ok := parents[row.Parent.Email]; ok && !sameRosterParent(first.Parent, row.Parent) {
+				report.Errors = append(report.Errors, RosterError{Line: row.Line, Column: "parent_email", Message: fmt.Sprintf("parent details differ from line %d", first.Line)})
+				continue
+			}
+			if line, ok := links[linkKey]; ok {
+				report.Errors = append(report.Errors, RosterError{Line: row.Line, Message: fmt.Sprintf("duplicates line %d", line)})
+				continue
+			}
+
+			// A failing row is undone on its own so the others can still be
+			// checked
+			if err := tx.SavePoint("roster_row").Error; err != nil {
+				return err
+			}
+			changes, studentID, parentID, err := importRosterRow(tx, row, studentIDs, parentIDs)
+			if errors.Is(err, errEmailTaken) {
+				if err := tx.RollbackTo("roster_row").Error; err != nil {
+					return err
+				}
+				report.Errors = append(report.Errors, RosterError{Line: row.Line, Column: "parent_email", Message: err.Error()})
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("line %d: %w", row.Line, err)
+			}
+
+			// Only rows that were kept may be reused; a rolled back row's
+			// student or parent no longer exists
+			studentIDs[studentKey], parentIDs[row.Parent.Email] = studentID, parentID
+			students[studentKey] = row
+			parents[row.Parent.Email] = row
+			links[linkKey] = row.Line
+			for _, change := range changes {
+				switch change.Entity {
+				case "student":
+					report.Students.add(change.Action)
+				case "parent":
+					report.Parents.add(change.Action)
+				case "guardianship":
+					report.Guardianships.add(change.Action)
+				}
+				if change.Action != RosterUnchanged {
+					report.Changes = append(report.Changes, change)
+				}
+			}
+		}
+
+		if !apply || len(report.Errors) > 0 {
+			return errRosterRollback
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errRosterRollback) {
+		return nil, err
+	}
+	report.Applied = err == nil
+	return report, nil
+}
+
+// importRosterRow upserts the student, parent and guardianship of one row and
+// returns the IDs of its student and parent. Students and parents already
+// imported from earlier rows are only linked.
+func importRosterRow(tx *gorm.DB, row RosterRow, studentIDs, parentIDs map[string]uuid.UUID) ([]RosterChange, uuid.UUID, uuid.UUID, error) {
+	var changes []RosterChange
+	studentKey := rosterStudentKey(row.Student)
+
+	studentID, ok := studentIDs[studentKey]
+	if !ok {
+		var existing models.Student
+		err := tx.Where("lower(first_name) = lower(?) AND lower(last_name) = lower(?) AND date_of_birth = ?",
+			row.Student.FirstName, row.Student.LastName, row.Student.DateOfBirth).First(&existing).Error
+		change := RosterChange{Line: row.Line, Entity: "student", Key: studentKey}
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			student := row.Student
+			if err := tx.Create(&student).Error; err != nil {
+				return nil, uuid.Nil, uuid.Nil, err
+			}
+			studentID, change.Action = student.ID, RosterCreate
+		case err != nil:
+			return nil, uuid.Nil, uuid.Nil, err
+		default:
+			change.Fields = mergeRosterStudent(&existing, row.Student)
+			change.Action = RosterUnchanged
+			if len(change.Fields) > 0 {
+				if err := tx.Save(&existing).Error; err != nil {
+					return nil, uuid.Nil, uuid.Nil, err
+				}
+				change.Action = RosterUpdate
+			}
+			studentID = existing.ID
+		}
+		changes = append(changes, change)
+	}
+
+	parentID, ok := parentIDs[row.Parent.Email]
+	if !ok {
+		var existing models.Parent
+		err := tx.Where("lower(email) = ?", row.Parent.Email).First(&existing).Error
+		change := RosterChange{Line: row.Line, Entity: "parent", Key: row.Parent.Email}
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			parent := row.Parent
+			if err := tx.Create(&parent).Error; err != nil {
+				return nil, uuid.Nil, uuid.Nil, err
+			}
+			parentID, change.Action = parent.ID, RosterCreate
+		case err != nil:
+			return nil, uuid.Nil, uuid.Nil, err
+		default:
+			change.Fields = mergeRosterParent(&existing, row.Parent)
+			change.Action = RosterUnchanged
+			if len(change.Fields) > 0 {
+				if err := tx.Save(&existing).Error; err != nil {
+					return nil, uuid.Nil, uuid.Nil, err
+				}
+				change.Action = RosterUpdate
+			}
+			parentID = existing.ID
+		}
+		// Imported parents get a user without a password; they sign in
+		// after being invited
+		if _, err := linkUser(tx, row.Parent.Email, "", nil, &parentID); err != nil {
+			return nil, uuid.Nil, uuid.Nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	var guardianship models.Guardianship
+	err := tx.Where("parent_id = ? AND student_id = ?", parentID, studentID).First(&guardianship).Error
+	change := RosterChange{Line: row.Line, Entity: "guardianship", Key: row.Parent.Email + " -> " + studentKey}
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		guardianship = row.Guardianship
+		guardianship.ParentID, guardianship.StudentID = parentID, studentID
+		if err := tx.Create(&guardianship).Error; err != nil {
+			return nil, uuid.Nil, uuid.Nil, err
+		}
+		change.Action = RosterCreate
+	case err != nil:
+		return nil, uuid.Nil, uuid.Nil, err
+	default:
+		if guardianship.Relation != row.Guardianship.Relation {
+			guardianship.Relation = row.Guardianship.Relation
+			change.Fields = append(change.Fields, "relation")
+		}
+		if guardianship.HasCustody != row.Guardianship.HasCustody {
+			guardianship.HasCustody = row.Guardianship.HasCustody
+			change.Fields = append(change.Fields, "hasCustody")
+		}
+		if guardianship.CanPickup != row.Guardianship.CanPickup {
+			guardianship.CanPickup = row.Guardianship.CanPickup
+			change.Fields = append(change.Fields, "canPickup")
+		}
+		change.Action = RosterUnchanged
+		if len(change.Fields) > 0 {
+			if err := tx.Save(&guardianship).Error; err != nil {
+				return nil, uuid.Nil, uuid.Nil, err
+			}
+			change.Action = RosterUpdate
+		}
+	}
+	return append(changes, change), studentID, parentID, nil
+}
+
+// mergeRosterStudent copies the non-empty roster fields that differ onto
+// student and returns their names. Blank roster cells never clear a field.
+func mergeRosterStudent(student *models.Student, imported models.Student) []string {
+	var fields []string
+	merge := func(name string, dst *string, src string) {
+		if src != "" && *dst != src {
+			*dst = src
+			fields = append(fields, name)
+		}
+	}
+	merge("firstName", &student.FirstName, imported.FirstName)
+	merge("lastName", &student.LastName, imported.LastName)
+	merge("grade", &student.Grade, imported.Grade)
+	merge("email", &student.Email, imported.Email)
+	merge("phoneNumber", &student.PhoneNumber, imported.PhoneNumber)
+	merge("address", &student.Address, imported.Address)
+	if imported.Gender != nil && (student.Gender == nil || *student.Gender != *imported.Gender) {
+		student.Gender = imported.Gender
+		fields = append(fields, "gender")
+	}
+	return fields
+}
+
+// mergeRosterParent is mergeRosterStudent for parents.
+func mergeRosterParent(parent *models.Parent, imported models.Parent) []string {
+	var fields []string
+	merge := func(name string, dst *string, src string) {
+		if src != "" && *dst != src {
+			*dst = src
+			fields = append(fields, name)
+		}
+	}
+	merge("firstName", &parent.FirstName, imported.FirstName)
+	merge("lastName", &parent.LastName, imported.LastName)
+	merge("phoneNumber", &parent.PhoneNumber, imported.PhoneNumber)
+	merge("address", &parent.Address, imported.Address)
+	return fields
+}
+
+// sameRosterStudent reports whether two rows describe the same student the
+// same way.
+func sameRosterStudent(a, b models.Student) bool {
+	return a.FirstName == b.FirstName && a.LastName == b.LastName && a.Grade == b.Grade &&
+		a.Email == b.Email && a.PhoneNumber == b.PhoneNumber && a.Address == b.Address &&
+		(a.Gender == nil) == (b.Gender == nil) && (a.Gender == nil || *a.Gender == *b.Gender)
+}
+
+// sameRosterParent reports whether two rows describe the same parent the same
+// way.
+func sameRosterParent(a, b models.Parent) bool {
+	return a.FirstName == b.FirstName && a.LastName == b.LastName &&
+		a.PhoneNumber == b.PhoneNumber && a.Address == b.Address
+}
@@ -0,0 +1,297 @@
+package resolvers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/messaging"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// maxScheduleAhead bounds how far in the future a message can be scheduled.
+const maxScheduleAhead = 366 * 24 * time.Hour
+
+// scheduledColumns are the columns an edit of a scheduled message replaces.
+// The retry state is reset so an edited message gets every attempt again.
+var scheduledColumns = []string{
+	"kind", "content", "template_id", "receiver_id", "student_id", "audience",
+	"grade", "position", "student_ids", "date", "send_at", "attempts", "next_attempt_at",
+}
+
+// errNoStudentContext is returned for content using student placeholders
+// that no student fills when it is sent.
+var errNoStudentContext = errors.New("{{student_first_name}} and {{grade}} need a student: give student_id, or send to guardians")
+
+// ScheduledMessageInput is the payload for scheduling a message to
+// ReceiverID, or a broadcast to Audience as with BroadcastInput. The text is
+// Content or the body of the template in TemplateID, rendered when it is
+// sent.
+type ScheduledMessageInput struct {
+	SendAt     time.Time   `json:"send_at"`
+	Content    string      `json:"content"`
+	TemplateID *uuid.UUID  `json:"template_id"`
+	ReceiverID *uuid.UUID  `json:"receiver_id"`
+	StudentID  *uuid.UUID  `json:"student_id"` // Fills the placeholders of a message
+	Audience   string      `json:"audience"`
+	Grade      string      `json:"grade"`
+	Position   string      `json:"position"`
+	StudentIDs []uuid.UUID `json:"student_ids"`
+	Date       string      `json:"date"` // YYYY-MM-DD for {{date}}, the sending day when empty
+}
+
+// CreateScheduledMessage schedules a message or broadcast for the caller.
+func CreateScheduledMessage(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var input ScheduledMessageInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	scheduled, status, err := buildScheduledMessage(db, r, &input)
+	if err != nil {
+		writeScheduleError(w, status, err)
+		return
+	}
+
+	if err := db.Create(scheduled).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, scheduled)
+}
+
+// GetScheduledMessages lists the caller's scheduled messages by sending time,
+// optionally only those in ?status=. Admins see everyone's.
+func GetScheduledMessages(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	query := db.Order("send_at, id")
+	if !middleware.IsAdmin(r.Context()) {
+		query = query.Where("sender_id = ?", callerID(r))
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	scheduled := []models.ScheduledMessage{}
+	if err := query.Find(&scheduled).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, scheduled)
+}
+
+// GetScheduledMessageByID returns the scheduled message in {id} to its
+// sender or an admin.
+func GetScheduledMessageByID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	scheduled, ok := fetchScheduledMessage(db, w, r)
+	if !ok {
+		return
+	}
+	if scheduled.SenderID != callerID(r) && !middleware.IsAdmin(r.Context()) {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, scheduled)
+}
+
+// UpdateScheduledMessage replaces the scheduled message in {id} while it is
+// still pending. Only its sender may change it.
+func UpdateScheduledMessage(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	existing, ok := fetchScheduledMessage(db, w, r)
+	if !ok {
+		return
+	}
+	if existing.SenderID != callerID(r) {
+		middleware.WriteForbidden(w)
+		return
+	}
+	var input ScheduledMessageInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	scheduled, status, err := buildScheduledMessage(db, r, &input)
+	if err != nil {
+		writeScheduleError(w, status, err)
+		return
+	}
+
+	// The dispatcher may be sending it right now; it only changes while pending
+	result := db.Model(&models.ScheduledMessage{}).Select(scheduledColumns).
+		Where("id = ? AND status = ?", existing.ID, models.ScheduledPending).
+		Updates(scheduled)
+	if result.Error != nil {
+		handleError(w, http.StatusInternalServerError, result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		handleError(w, http.StatusConflict, "Only pending scheduled messages can be changed")
+		return
+	}
+	if err := db.Where("id = ?", existing.ID).First(existing).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, existing)
+}
+
+// CancelScheduledMessage cancels the scheduled message in {id} while it is
+// still pending. The record is kept with the canceled status.
+func CancelScheduledMessage(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	scheduled, ok := fetchScheduledMessage(db, w, r)
+	if !ok {
+		return
+	}
+	if scheduled.SenderID != callerID(r) && !middleware.IsAdmin(r.Context()) {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	result := db.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND status = ?", scheduled.ID, models.ScheduledPending).
+		Update("status", models.ScheduledCanceled)
+	if result.Error != nil {
+		handleError(w, http.StatusInternalServerError, result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		handleError(w, http.StatusConflict, "Only pending scheduled messages can be canceled")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// buildScheduledMessage checks a scheduling payload and returns the pending
+// scheduled message it describes, or the status to answer with.
+func buildScheduledMessage(db *gorm.DB, r *http.Request, input *ScheduledMessageInput) (*models.ScheduledMessage, int, error) {
+	now := time.Now()
+	switch {
+	case input.SendAt.IsZero():
+		return nil, http.StatusBadRequest, errors.New("send_at is required")
+	case !input.SendAt.After(now):
+		return nil, http.StatusBadRequest, errors.New("send_at must be in the future")
+	case input.SendAt.After(now.Add(maxScheduleAhead)):
+		return nil, http.StatusBadRequest, errors.New("send_at must be within a year")
+	}
+	if input.Date != "" {
+		if _, err := helpers.ParseDate(input.Date); err != nil {
+			return nil, http.StatusBadRequest, errors.New("date must be YYYY-MM-DD")
+		}
+	}
+	content, status, err := messageContent(db, input.Content, input.TemplateID)
+	if err != nil {
+		return nil, status, err
+	}
+
+	scheduled := &models.ScheduledMessage{
+		SenderID:   callerID(r),
+		Content:    content,
+		TemplateID: input.TemplateID,
+		Date:       input.Date,
+		SendAt:     input.SendAt,
+		Status:     models.ScheduledPending,
+	}
+	switch {
+	case input.ReceiverID != nil && input.Audience == "":
+		if *input.ReceiverID == scheduled.SenderID {
+			return nil, http.StatusBadRequest, errors.New("You cannot send a message to yourself")
+		}
+		if exists, err := profileExists(db, *input.ReceiverID); err != nil {
+			return nil, http.StatusInternalServerError, err
+		} else if !exists {
+			return nil, http.StatusBadRequest, errors.New("receiver not found")
+		}
+		if input.StudentID != nil {
+			if _, err := FetchStudentByUUID(db, *input.StudentID); err != nil {
+				return nil, http.StatusBadRequest, errors.New("student not found")
+			}
+		} else if messaging.UsesStudentPlaceholders(content) {
+			return nil, http.StatusBadRequest, errNoStudentContext
+		}
+		scheduled.Kind = models.ScheduledDirect
+		scheduled.ReceiverID = input.ReceiverID
+		scheduled.StudentID = input.StudentID
+
+	case input.Audience != "" && input.ReceiverID == nil:
+		// Staff are not sent a student's values, only the broadcast's grade
+		if input.Audience == models.AudienceStaffPosition && messaging.UsesStudentPlaceholders(content) {
+			return nil, http.StatusBadRequest, errNoStudentContext
+		}
+		scheduled.Kind = models.ScheduledBroadcast
+		scheduled.Audience = input.Audience
+		scheduled.Grade = input.Grade
+		scheduled.Position = input.Position
+		scheduled.StudentIDs = input.StudentIDs
+		broadcast := models.Broadcast{
+			SenderID:   scheduled.SenderID,
+			Audience:   input.Audience,
+			Grade:      input.Grade,
+			Position:   input.Position,
+			StudentIDs: input.StudentIDs,
+		}
+		if ok, err := canBroadcastTo(db, r, &broadcast); err != nil {
+			return nil, http.StatusInternalServerError, err
+		} else if !ok {
+			return nil, http.StatusForbidden, errForbidden
+		}
+		// The audience is resolved again when it is sent; this only checks it
+		if _, err := messaging.ResolveAudience(db, &broadcast); errors.Is(err, messaging.ErrInvalidAudience) {
+			return nil, http.StatusBadRequest, err
+		} else if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+	default:
+		return nil, http.StatusBadRequest, errors.New("give either receiver_id or audience")
+	}
+	return scheduled, http.StatusOK, nil
+}
+
+// profileExists reports whether id is a staff or parent profile messages can
+// be sent to.
+func profileExists(db *gorm.DB, id uuid.UUID) (bool, error) {
+	for _, model := range []interface{}{&models.Staff{}, &models.Parent{}} {
+		var count int64
+		if err := db.Model(model).Where("id = ?", id).Count(&count).Error; err != nil || count > 0 {
+			return count > 0, err
+		}
+	}
+	return false, nil
+}
+
+// writeScheduleError writes an error from buildScheduledMessage.
+func writeScheduleError(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusForbidden {
+		middleware.WriteForbidden(w)
+		return
+	}
+	handleError(w, status, err.Error())
+}
+
+// fetchScheduledMessage loads the scheduled message in {id}, writing an error
+// when it does not exist.
+func fetchScheduledMessage(db *gorm.DB, w http.ResponseWriter, r *http.Request) (*models.ScheduledMessage, bool) {
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid Scheduled Message UUID")
+		return nil, false
+	}
+	var scheduled models.ScheduledMessage
+	if err := db.Where("id = ?", id).First(&scheduled).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, http.StatusNotFound, "Scheduled message not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return &scheduled, true
+}
@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"time"
 
 	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/events"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/middleware/helpers"
 	"github.com/mineracail/guardApi/models"
 	"gorm.io/gorm"
 )
@@ -23,14 +25,30 @@ func FetchStaffByUUID(db *gorm.DB, id uuid.UUID) (*models.Staff, error) {
 
 // CreateStaff handles the creation of a new staff.
 func CreateStaff(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	var staff models.Staff
-	if err := json.NewDecoder(r.Body).Decode(&staff); err != nil {
+	// The password is stored on the linked user, not on the staff row
+	var input struct {
+		models.Staff
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		handleError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	staff := input.Staff
 
-	if result := db.Create(&staff); result.Error != nil {
-		handleError(w, http.StatusInternalServerError, result.Error.Error())
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&staff).Error; err != nil {
+			return err
+		}
+		// Profiles without an email have no login
+		if models.NormalizeEmail(staff.Email) == "" {
+			return nil
+		}
+		_, err := linkUser(tx, staff.Email, input.Password, &staff.ID, nil)
+		return err
+	})
+	if err != nil {
+		handleCredentialError(w, err)
 		return
 	}
 
@@ -88,13 +106,31 @@ func UpdateStaffByID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&staff); err != nil {
+	// A new password in the payload is stored on the linked user
+	position, superviseGrade := staff.Position, staff.SuperviseGrade
+	input := struct {
+		*models.Staff
+		Password string `json:"password"`
+	}{Staff: staff}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		handleError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	// The URL decides which profile changes, whatever id the payload carries
+	staff.ID = id
+	// Only admins may change a staff member's position or supervised grade
+	if !middleware.IsAdmin(r.Context()) {
+		staff.Position, staff.SuperviseGrade = position, superviseGrade
+	}
 
-	if result := db.Save(&staff); result.Error != nil {
-		handleError(w, http.StatusInternalServerError, result.Error.Error())
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&staff).Error; err != nil {
+			return err
+		}
+		return updateUserCredentials(tx, "staff_id", staff.ID, staff.Email, input.Password)
+	})
+	if err != nil {
+		handleCredentialError(w, err)
 		return
 	}
 
@@ -119,8 +155,14 @@ func DeleteStaffByID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if result := db.Delete(&staff); result.Error != nil {
-		handleError(w, http.StatusInternalServerError, result.Error.Error())
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&staff).Error; err != nil {
+			return err
+		}
+		return deleteOrphanUsers(tx)
+	})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -136,18 +178,51 @@ func CreateSchoolArrival(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the current date
-	currentDate := time.Now().Format("2006-01-02") // Format to YYYY-MM-DD
+	// Teachers can only manage arrivals for the grade they supervise
+	if !middleware.IsAdmin(r.Context()) {
+		staffID := callerID(r)
+		ok, err := StaffSupervisesStudent(db, staffID, SchooArrival.StudentID)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, "Error checking student access")
+			return
+		}
+		if !ok {
+			middleware.WriteForbidden(w)
+			return
+		}
+		SchooArrival.StaffID = staffID.String()
+	}
+
+	// Check the device location against the school's fences
+	fences, err := schoolGeofences(db)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Error loading geofences")
+		return
+	}
+	location := arrivalLocation{Latitude: SchooArrival.Latitude, Longitude: SchooArrival.Longitude, Accuracy: SchooArrival.Accuracy}
+	if !checkArrivalLocation(w, &location, fences, SchooArrival.Confirmed) {
+		return
+	}
+	SchooArrival.GeofenceStatus, SchooArrival.GeofenceDistance = location.Status, location.Distance
 
-	// Check if a HomeArrival already exists for the same ParentID, StudentID, and current date
+	// Get the current school day
+	today := helpers.DayRange(helpers.SchoolNow())
+
+	// Check if a SchoolArrival already exists for the same StaffID, StudentID, and current date
 	var existingArrival models.SchoolArrival
-	if err := db.Where("staff_id = ? AND student_id = ? AND DATE(created_at) = ?", SchooArrival.StaffID, SchooArrival.StudentID, currentDate).First(&existingArrival).Error; err == nil {
+	if err := db.Where("staff_id = ? AND student_id = ? AND created_at >= ? AND created_at < ?", SchooArrival.StaffID, SchooArrival.StudentID, today.From, today.To).First(&existingArrival).Error; err == nil {
 		// Record exists, update the existing one
 		existingArrival.Confirmed = SchooArrival.Confirmed
+		existingArrival.Latitude, existingArrival.Longitude, existingArrival.Accuracy = SchooArrival.Latitude, SchooArrival.Longitude, SchooArrival.Accuracy
+		existingArrival.GeofenceStatus, existingArrival.GeofenceDistance = SchooArrival.GeofenceStatus, SchooArrival.GeofenceDistance
 		if result := db.Save(&existingArrival); result.Error != nil {
 			handleError(w, http.StatusInternalServerError, result.Error.Error())
 			return
 		}
+		if existingArrival.Confirmed {
+			syncAttendance(db, r, existingArrival.StudentID, "school arrival", models.AttendanceArrivedSchool)
+			publishArrival(db, events.SchoolArrivalConfirmed, schoolArrivalEvent(existingArrival))
+		}
 		respondJSON(w, http.StatusOK, existingArrival)
 		return
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
@@ -162,6 +237,11 @@ func CreateSchoolArrival(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		handleError(w, http.StatusInternalServerError, result.Error.Error())
 		return
 	}
+	publishArrival(db, events.SchoolArrivalCreated, schoolArrivalEvent(SchooArrival))
+	if SchooArrival.Confirmed {
+		syncAttendance(db, r, SchooArrival.StudentID, "school arrival", models.AttendanceArrivedSchool)
+		publishArrival(db, events.SchoolArrivalConfirmed, schoolArrivalEvent(SchooArrival))
+	}
 
 	respondJSON(w, http.StatusCreated, SchooArrival)
 }
@@ -175,12 +255,15 @@ func GetConfirmedArrivalsByStaff(db *gorm.DB, w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Get the current date
-	currentDate := time.Now().Format("2006-01-02") // Format to YYYY-MM-DD
+	// Today unless ?date= or ?from=&to= is given
+	dates, ok := dateRangeParam(w, r, helpers.DayRange(helpers.SchoolNow()))
+	if !ok {
+		return
+	}
 
-	// Query the database to retrieve all confirmed HomeArrival records for the given parent and today's date
+	// Query the database to retrieve all SchoolArrival records for the given staff and date range
 	var confirmedArrivals []models.SchoolArrival
-	if err := db.Where("staff_id = ?  AND DATE(created_at) = ?", parentID.String(), currentDate).Find(&confirmedArrivals).Error; err != nil {
+	if err := db.Where("staff_id = ? AND created_at >= ? AND created_at < ?", parentID.String(), dates.From, dates.To).Find(&confirmedArrivals).Error; err != nil {
 		handleError(w, http.StatusInternalServerError, "Error retrieving confirmed arrivals: "+err.Error())
 		return
 	}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware/helpers"
 	"github.com/mineracail/guardApi/models"
 	"gorm.io/gorm"
 )
@@ -22,12 +23,37 @@ func handleError(w http.ResponseWriter, status int, errMessage string) {
 	http.Error(w, errMessage, status)
 }
 
+// dateRangeParam reads the ?date= or ?from=&to= query parameters, writing a
+// 400 when they are malformed.
+func dateRangeParam(w http.ResponseWriter, r *http.Request, def helpers.DateRange) (helpers.DateRange, bool) {
+	dates, err := helpers.ParseDateRange(r.URL.Query(), def)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, err.Error())
+		return dates, false
+	}
+	return dates, true
+}
+
 // parseUUID retrieves and converts the UUID parameter from the URL.
 func parseUUID(r *http.Request) (uuid.UUID, error) {
 	idParam := chi.URLParam(r, "id")
 	return uuid.Parse(idParam)
 }
 
+// hashPasswordField replaces a plaintext password with its bcrypt hash.
+// Empty passwords are left untouched.
+func hashPasswordField(password *string) error {
+	if *password == "" {
+		return nil
+	}
+	hashed, err := helpers.HashPassword(*password)
+	if err != nil {
+		return err
+	}
+	*password = hashed
+	return nil
+}
+
 // fetchStudentByUUID fetches a student by their UUID from the database.
 func FetchStudentByUUID(db *gorm.DB, id uuid.UUID) (*models.Student, error) {
 	var student models.Student
@@ -0,0 +1,212 @@
+package resolvers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/messaging"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+// MessageTemplateInput is the payload for creating or changing a template.
+type MessageTemplateInput struct {
+	Name string `json:"name"`
+	Body string `json:"body"`
+}
+
+// TemplatePreviewInput picks the values a template preview is rendered with.
+type TemplatePreviewInput struct {
+	StudentID *uuid.UUID `json:"student_id"`
+	Date      string     `json:"date"` // YYYY-MM-DD, today when empty
+}
+
+// GetMessageTemplates lists the templates every staff member can use, by
+// name.
+func GetMessageTemplates(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	templates := []models.MessageTemplate{}
+	if err := db.Order("lower(name), id").Find(&templates).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, templates)
+}
+
+// GetMessageTemplateByID returns the template in {id}.
+func GetMessageTemplateByID(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	template, ok := fetchMessageTemplate(db, w, r)
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, template)
+}
+
+// CreateMessageTemplate stores a template owned by the caller.
+func CreateMessageTemplate(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	input, ok := decodeMessageTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	template := models.MessageTemplate{OwnerID: callerID(r), Name: input.Name, Body: input.Body}
+	if err := db.Create(&template).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, template)
+}
+
+// UpdateMessageTemplate changes the name and body of the template in {id}.
+// Messages already scheduled keep the text they were scheduled with.
+func UpdateMessageTemplate(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	template, ok := fetchMessageTemplate(db, w, r)
+	if !ok {
+		return
+	}
+	if !canManageTemplate(r, template) {
+		middleware.WriteForbidden(w)
+		return
+	}
+	input, ok := decodeMessageTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := db.Model(template).Updates(map[string]interface{}{"name": input.Name, "body": input.Body}).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, template)
+}
+
+// DeleteMessageTemplate deletes the template in {id}.
+func DeleteMessageTemplate(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	template, ok := fetchMessageTemplate(db, w, r)
+	if !ok {
+		return
+	}
+	if !canManageTemplate(r, template) {
+		middleware.WriteForbidden(w)
+		return
+	}
+
+	if err := db.Delete(template).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PreviewMessageTemplate renders the template in {id} for a student and
+// date, for instance to send it as a message right away.
+func PreviewMessageTemplate(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	template, ok := fetchMessageTemplate(db, w, r)
+	if !ok {
+		return
+	}
+	var input TemplatePreviewInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	date := helpers.DayRange(helpers.SchoolNow()).From
+	if input.Date != "" {
+		var err error
+		if date, err = helpers.ParseDate(input.Date); err != nil {
+			handleError(w, http.StatusBadRequest, "date must be YYYY-MM-DD")
+			return
+		}
+	}
+
+	values, err := messaging.StudentValues(db, input.StudentID, date)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		handleError(w, http.StatusNotFound, "Student not found")
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"content": messaging.Render(template.Body, values)})
+}
+
+// messageContent returns the text to send: the body of the template when one
+// is given, else content. It checks the placeholders either way and returns
+// the status to answer with when the text cannot be used.
+func messageContent(db *gorm.DB, content string, templateID *uuid.UUID) (string, int, error) {
+	if templateID != nil {
+		var template models.MessageTemplate
+		if err := db.Where("id = ?", *templateID).First(&template).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return "", http.StatusBadRequest, errors.New("template not found")
+			}
+			return "", http.StatusInternalServerError, err
+		}
+		content = template.Body
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return "", http.StatusBadRequest, errors.New("content or template_id is required")
+	}
+	if err := messaging.ValidateTemplate(content); err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	return content, http.StatusOK, nil
+}
+
+// decodeMessageTemplate reads and checks a template payload, writing an
+// error when it is unusable.
+func decodeMessageTemplate(w http.ResponseWriter, r *http.Request) (*MessageTemplateInput, bool) {
+	var input MessageTemplateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid request payload")
+		return nil, false
+	}
+	input.Name, input.Body = strings.TrimSpace(input.Name), strings.TrimSpace(input.Body)
+	if input.Name == "" || input.Body == "" {
+		handleError(w, http.StatusBadRequest, "name and body are required")
+		return nil, false
+	}
+	if err := messaging.ValidateTemplate(input.Body); err != nil {
+		handleError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return &input, true
+}
+
+// fetchMessageTemplate loads the template in {id}, writing an error when it
+// does not exist.
+func fetchMessageTemplate(db *gorm.DB, w http.ResponseWriter, r *http.Request) (*models.MessageTemplate, bool) {
+	id, err := parseUUID(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid Template UUID")
+		return nil, false
+	}
+	var template models.MessageTemplate
+	if err := db.Where("id = ?", id).First(&template).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, http.StatusNotFound, "Template not found")
+		} else {
+			handleError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return &template, true
+}
+
+// canManageTemplate reports whether the caller may change or delete the
+// template: its owner or an admin.
+func canManageTemplate(r *http.Request, template *models.MessageTemplate) bool {
+	return template.OwnerID == callerID(r) || middleware.IsAdmin(r.Context())
+}
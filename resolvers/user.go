@@ -0,0 +1,138 @@
+package resolvers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware/helpers"
+	"github.com/mineracail/guardApi/models"
+	"gorm.io/gorm"
+)
+
+var (
+	errEmailTaken    = errors.New("email already belongs to another account")
+	errEmailRequired = errors.New("email is required")
+)
+
+// linkUser links a staff or parent profile to the user owning the email and
+// creates the user when there is none. The password only applies to users
+// without one, so a teacher who is also a parent keeps a single password.
+func linkUser(tx *gorm.DB, email, password string, staffID, parentID *uuid.UUID) (*models.User, error) {
+	email = models.NormalizeEmail(email)
+	if email == "" {
+		return nil, errEmailRequired
+	}
+
+	var user models.User
+	err := tx.Where("lower(email) = ?", email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		user = models.User{Email: email}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if staffID != nil {
+		if user.StaffID != nil && *user.StaffID != *staffID {
+			return nil, errEmailTaken
+		}
+		user.StaffID = staffID
+	}
+	if parentID != nil {
+		if user.ParentID != nil && *user.ParentID != *parentID {
+			return nil, errEmailTaken
+		}
+		user.ParentID = parentID
+	}
+
+	if user.Password == "" && password != "" {
+		if err := helpers.ValidatePassword(password); err != nil {
+			return nil, err
+		}
+		if err := hashPasswordField(&password); err != nil {
+			return nil, err
+		}
+		user.Password = password
+	}
+
+	if err := tx.Save(&user).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return nil, errEmailTaken
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// updateUserCredentials applies a profile's email change and an optional new
+// password to the linked user. Profiles without a user get one when they
+// have an email.
+func updateUserCredentials(tx *gorm.DB, profileColumn string, profileID uuid.UUID, email, password string) error {
+	var user models.User
+	err := tx.Where(profileColumn+" = ?", profileID).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if email = models.NormalizeEmail(email); email == "" {
+			return nil
+		}
+		// Editing a profile must not attach it to another person's login;
+		// linkUser only does that when an admin creates the profile
+		var taken int64
+		if err := tx.Model(&models.User{}).Where("lower(email) = ?", email).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return errEmailTaken
+		}
+		if profileColumn == "staff_id" {
+			_, err = linkUser(tx, email, password, &profileID, nil)
+		} else {
+			_, err = linkUser(tx, email, password, nil, &profileID)
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{}
+	if email = models.NormalizeEmail(email); email != "" && email != user.Email {
+		updates["email"] = email
+	}
+	if password != "" {
+		if err := helpers.ValidatePassword(password); err != nil {
+			return err
+		}
+		if err := hashPasswordField(&password); err != nil {
+			return err
+		}
+		updates["password"] = password
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	if err := tx.Model(&user).Updates(updates).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return errEmailTaken
+		}
+		return err
+	}
+	return nil
+}
+
+// deleteOrphanUsers removes users left without any profile.
+func deleteOrphanUsers(tx *gorm.DB) error {
+	return tx.Where("staff_id IS NULL AND parent_id IS NULL").Delete(&models.User{}).Error
+}
+
+// handleCredentialError sends the response for an error from linkUser or updateUserCredentials.
+func handleCredentialError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errEmailTaken):
+		handleError(w, http.StatusConflict, "An account with this email already exists")
+	case errors.Is(err, errEmailRequired), errors.Is(err, helpers.ErrPasswordTooShort):
+		handleError(w, http.StatusBadRequest, err.Error())
+	default:
+		handleError(w, http.StatusInternalServerError, err.Error())
+	}
+}
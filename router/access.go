@@ -0,0 +1,80 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/mineracail/guardApi/middleware"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+// Roles accepted by the Require* helpers.
+const (
+	RoleAdmin   = middleware.UserTypeAdmin
+	RoleStaff   = middleware.UserTypeStaff
+	RoleParent  = middleware.UserTypeParent
+	RoleTeacher = middleware.PositionTeacher
+)
+
+// RequireRole only lets callers holding one of the roles through.
+// Admins are always allowed.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !middleware.HasRole(r.Context(), roles...) {
+				middleware.WriteForbidden(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSelfOrRole lets callers through when the {id} URL parameter is their
+// own ID, or when they hold one of the roles.
+func RequireSelfOrRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, err := middleware.GetIDFromContext(r.Context())
+			if (err != nil || id != chi.URLParam(r, "id")) && !middleware.HasRole(r.Context(), roles...) {
+				middleware.WriteForbidden(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireStudentAccess lets parents through for students they are a guardian
+// of, and any caller holding one of the roles. The student is read from {id}.
+func RequireStudentAccess(db *gorm.DB, roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if middleware.HasRole(r.Context(), roles...) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userType, _ := middleware.GetUserTypeFromContext(r.Context())
+			id, _ := middleware.GetIDFromContext(r.Context())
+			parentID, err := uuid.Parse(id)
+			if userType != RoleParent || err != nil {
+				middleware.WriteForbidden(w)
+				return
+			}
+
+			ok, err := resolvers.ParentSupervisesStudent(db, parentID, chi.URLParam(r, "id"))
+			if err != nil {
+				middleware.WriteJSONError(w, http.StatusInternalServerError, "Error checking student access")
+				return
+			}
+			if !ok {
+				middleware.WriteForbidden(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func AlertRoute(db *gorm.DB, r chi.Router) {
+	// Missing-child alerts; the resolvers limit non-admins to their own alerts
+	r.Get("/alerts", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetAlerts(db, w, r)
+	})
+	r.Post("/alerts/{id}/acknowledge", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.AcknowledgeAlert(db, w, r)
+	})
+
+	// Per-grade cutoffs after which a student who left home is overdue
+	r.With(RequireRole(RoleStaff)).Get("/grade-cutoffs", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetGradeCutoffs(db, w, r)
+	})
+	r.With(RequireRole(RoleAdmin)).Put("/grade-cutoffs/{grade}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.PutGradeCutoff(db, w, r)
+	})
+}
@@ -0,0 +1,34 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func AttachmentRoute(db *gorm.DB, r chi.Router) {
+	// Senders attach files; both participants list them and get download links
+	r.Post("/messages/{id}/attachments", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.UploadAttachments(db, w, r)
+	})
+	r.Get("/messages/{id}/attachments", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetMessageAttachments(db, w, r)
+	})
+	r.Get("/attachments/{id}/link", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetAttachmentLink(db, w, r)
+	})
+	r.Delete("/attachments/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.DeleteAttachment(db, w, r)
+	})
+}
+
+// DownloadRoute registers the attachment download, authorized by the signed
+// link instead of a token. It is mounted outside the authentication
+// middleware.
+func DownloadRoute(db *gorm.DB, r chi.Router) {
+	r.Get("/attachments/{id}/download", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.DownloadAttachment(db, w, r)
+	})
+}
@@ -0,0 +1,66 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/mailer"
+	"github.com/mineracail/guardApi/middleware"
+	"gorm.io/gorm"
+)
+
+// AuthRoute registers the public authentication routes. They are mounted
+// outside the authentication middleware.
+func AuthRoute(db *gorm.DB, mail mailer.Mailer, r chi.Router) {
+	// Login route for authentication
+	r.Post("/login", func(w http.ResponseWriter, r *http.Request) {
+		middleware.Login(db, w, r)
+	})
+	r.Post("/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		middleware.Refresh(db, w, r)
+	})
+	r.Post("/auth/logout", func(w http.ResponseWriter, r *http.Request) {
+		middleware.Logout(db, w, r)
+	})
+	r.Post("/auth/forgot-password", func(w http.ResponseWriter, r *http.Request) {
+		middleware.ForgotPassword(db, mail, w, r)
+	})
+	r.Post("/auth/reset-password", func(w http.ResponseWriter, r *http.Request) {
+		middleware.ResetPassword(db, w, r)
+	})
+	r.Post("/auth/accept-invitation", func(w http.ResponseWriter, r *http.Request) {
+		middleware.AcceptInvitation(db, w, r)
+	})
+	// Second step of a login that needs a TOTP code
+	r.Post("/auth/2fa/verify", func(w http.ResponseWriter, r *http.Request) {
+		middleware.VerifyMFAChallenge(db, w, r)
+	})
+	r.Post("/auth/2fa/challenge/enroll", func(w http.ResponseWriter, r *http.Request) {
+		middleware.EnrollChallengeTOTP(db, w, r)
+	})
+	// Public keys for verifying tokens offline
+	r.Get("/.well-known/jwks.json", middleware.JWKS)
+}
+
+// SessionRoute registers the authentication routes that need a signed-in
+// caller. They are mounted behind the authentication middleware.
+func SessionRoute(db *gorm.DB, r chi.Router) {
+	// Switch between the staff and parent profiles of the same user
+	r.Post("/auth/switch-context", func(w http.ResponseWriter, r *http.Request) {
+		middleware.SwitchContext(db, w, r)
+	})
+
+	// TOTP enrollment is offered to staff and required for admins
+	r.With(RequireRole(RoleStaff)).Post("/auth/2fa/enroll", func(w http.ResponseWriter, r *http.Request) {
+		middleware.EnrollTOTP(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Post("/auth/2fa/confirm", func(w http.ResponseWriter, r *http.Request) {
+		middleware.ConfirmTOTP(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Post("/auth/2fa/disable", func(w http.ResponseWriter, r *http.Request) {
+		middleware.DisableTOTP(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Post("/auth/2fa/recovery-codes", func(w http.ResponseWriter, r *http.Request) {
+		middleware.RegenerateRecoveryCodes(db, w, r)
+	})
+}
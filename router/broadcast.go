@@ -0,0 +1,22 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func BroadcastRoute(db *gorm.DB, r chi.Router) {
+	// Staff broadcast to audiences; each sees the broadcasts they sent
+	r.With(RequireRole(RoleStaff)).Post("/broadcasts", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.CreateBroadcast(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Get("/broadcasts", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetBroadcasts(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Get("/broadcasts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetBroadcastByID(db, w, r)
+	})
+}
@@ -8,9 +8,9 @@ import (
 	"gorm.io/gorm"
 )
 
-func CalendarRoute(db *gorm.DB, r *chi.Mux) {
+func CalendarRoute(db *gorm.DB, r chi.Router) {
 	// Define routes for CRUD operations
-	r.Post("/calendars", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleAdmin)).Post("/calendars", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.CreateCalendar(db, w, r)
 	})
 	r.Get("/calendars/{id}", func(w http.ResponseWriter, r *http.Request) {
@@ -19,10 +19,10 @@ func CalendarRoute(db *gorm.DB, r *chi.Mux) {
 	r.Get("/calendars/all", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetAllCalendars(db, w, r)
 	})
-	r.Put("/calendars/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleAdmin)).Put("/calendars/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.UpdateCalendarByID(db, w, r)
 	})
-	r.Delete("/calendars/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleAdmin)).Delete("/calendars/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.DeleteCalendarByID(db, w, r)
 	})
 }
@@ -0,0 +1,16 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func EventRoute(db *gorm.DB, r chi.Router) {
+	// Server-sent arrival events; the resolver authorizes each subscription
+	r.Get("/events", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.StreamEvents(db, w, r)
+	})
+}
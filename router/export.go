@@ -0,0 +1,19 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func ExportRoute(db *gorm.DB, r chi.Router) {
+	// Arrival logs and registers for the district
+	r.With(RequireRole(RoleAdmin)).Get("/exports/arrivals.csv", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.ExportArrivalsCSV(db, w, r)
+	})
+	r.With(RequireRole(RoleAdmin)).Get("/exports/register.pdf", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.ExportRegisterPDF(db, w, r)
+	})
+}
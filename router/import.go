@@ -0,0 +1,16 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func ImportRoute(db *gorm.DB, r chi.Router) {
+	// Roster CSV import; a dry run unless ?apply=true
+	r.With(RequireRole(RoleAdmin)).Post("/import/roster", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.ImportRosterCSV(db, w, r)
+	})
+}
@@ -0,0 +1,17 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/mailer"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func InvitationRoute(db *gorm.DB, mail mailer.Mailer, r chi.Router) {
+	// Admins invite new staff members and parents
+	r.With(RequireRole(RoleAdmin)).Post("/invitations", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.InviteUser(db, mail, w, r)
+	})
+}
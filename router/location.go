@@ -9,33 +9,47 @@ import (
 	"gorm.io/gorm"
 )
 
-func LocationRoute(db *gorm.DB, r *chi.Mux) {
+func LocationRoute(db *gorm.DB, r chi.Router) {
 	// Define routes for CRUD operations
-	r.Post("/locationbyparent", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleParent)).Post("/locationbyparent", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.CreateHomeArrival(db, w, r)
 	})
-	r.Post("/locationbystaff", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleTeacher)).Post("/locationbystaff", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.CreateSchoolArrival(db, w, r)
 	})
-	r.Get("/locationbyparent/all", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleStaff)).Get("/locationbyparent/all", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetAllHomeArrivalsForThatWeek(db, w, r)
 	})
-	r.Get("/locationbyparent/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireSelfOrRole(RoleStaff)).Get("/locationbyparent/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetAllHomeArrivalsForThatWeekByParentId(db, w, r)
 	})
 
-	r.Get("/locationbyday/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireSelfOrRole(RoleStaff)).Get("/locationbyday/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetConfirmedArrivalsByParent(db, w, r)
 	})
-	r.Get("/stafflocationbyday/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireSelfOrRole(RoleAdmin)).Get("/stafflocationbyday/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetConfirmedArrivalsByStaff(db, w, r)
 	})
 
-	r.Get("/locationbyday/all", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleStaff)).Get("/locationbyday/all", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetAllConfirmedArrivals(db, w, r)
 	})
-	r.Get("/stafflocationbyday/all", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleStaff)).Get("/stafflocationbyday/all", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetAllConfirmedArrivalsStaff(db, w, r)
 	})
 
+	// School fences that arrivals at school are checked against; only admins
+	// also see home fences
+	r.With(RequireRole(RoleStaff)).Get("/geofences", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetGeofences(db, w, r)
+	})
+	r.With(RequireRole(RoleAdmin)).Post("/geofences", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.CreateSchoolGeofence(db, w, r)
+	})
+	r.With(RequireRole(RoleAdmin)).Put("/geofences/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.UpdateGeofence(db, w, r)
+	})
+	r.With(RequireRole(RoleAdmin)).Delete("/geofences/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.DeleteGeofence(db, w, r)
+	})
 }
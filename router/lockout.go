@@ -0,0 +1,19 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func LockoutRoute(db *gorm.DB, r chi.Router) {
+	// Admins review and lift login lockouts
+	r.With(RequireRole(RoleAdmin)).Get("/lockouts", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetActiveLockouts(db, w, r)
+	})
+	r.With(RequireRole(RoleAdmin)).Post("/lockouts/unlock", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.UnlockAccount(db, w, r)
+	})
+}
@@ -9,15 +9,19 @@ import (
 	"gorm.io/gorm"
 )
 
-func MessageRoute( db *gorm.DB,r *chi.Mux) {
+func MessageRoute( db *gorm.DB,r chi.Router) {
 	r.Post("/messages", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.CreateMessage(db, w, r)
 	})
-	r.Post("/messages/multiple", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleStaff)).Post("/messages/multiple", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.CreateMessageToMultiple(db, w, r)
 	})
-	r.Get("/messages/all", func(w http.ResponseWriter, r *http.Request) {
-		resolvers.GetAllMessages(db, w, r)
+	// Everyone only sees the messages they sent or received
+	r.Get("/messages/inbox", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetInbox(db, w, r)
+	})
+	r.Get("/messages/sent", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetSent(db, w, r)
 	})
 	r.Get("/messages/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetMessageByID(db, w, r)
@@ -28,4 +32,18 @@ func MessageRoute( db *gorm.DB,r *chi.Mux) {
 	r.Delete("/messages/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.DeleteMessageByID(db, w, r)
 	})
+	r.Post("/messages/{id}/read", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.MarkMessageRead(db, w, r)
+	})
+
+	// Conversations are visible to their two participants only
+	r.Get("/conversations", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetConversations(db, w, r)
+	})
+	r.Get("/conversations/{id}/messages", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetConversationMessages(db, w, r)
+	})
+	r.Post("/conversations/{id}/read", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.MarkConversationRead(db, w, r)
+	})
 }
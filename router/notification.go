@@ -0,0 +1,28 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func NotificationRoute(db *gorm.DB, r chi.Router) {
+	// Every user manages their own devices and preferences
+	r.Get("/devices", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetDevices(db, w, r)
+	})
+	r.Post("/devices", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.RegisterDevice(db, w, r)
+	})
+	r.Delete("/devices/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.DeleteDevice(db, w, r)
+	})
+	r.Get("/notification-preferences", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetNotificationPreferences(db, w, r)
+	})
+	r.Put("/notification-preferences", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.UpdateNotificationPreferences(db, w, r)
+	})
+}
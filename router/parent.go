@@ -8,27 +8,48 @@ import (
 	"gorm.io/gorm"
 )
 
-func ParentRoute(db *gorm.DB, r *chi.Mux) {
+func ParentRoute(db *gorm.DB, r chi.Router) {
 	// Define routes for CRUD operations for Parent
-	r.Post("/parents", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleAdmin)).Post("/parents", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.CreateParent(db, w, r)
 	})
-	r.Get("/parents/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireSelfOrRole(RoleStaff)).Get("/parents/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetParentByID(db, w, r)
 	})
-	r.Get("/parentschild/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireSelfOrRole(RoleStaff)).Get("/parentschild/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetChildByParentID(db, w, r)
 	})
-	r.Get("/parents/all", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleStaff)).Get("/parents/all", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetAllParents(db, w, r)
 	})
-	r.Put("/parents/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireSelfOrRole(RoleAdmin)).Put("/parents/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.UpdateParentByID(db, w, r)
 	})
-	r.Put("/addsupervise", func(w http.ResponseWriter, r *http.Request) {
-		resolvers.AddSupervise(db, w, r)
+	r.With(RequireSelfOrRole(RoleStaff)).Get("/parents/{id}/students", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetGuardianshipsByParent(db, w, r)
 	})
-	r.Delete("/parents/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleAdmin)).Post("/parents/{id}/students", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.AddGuardianship(db, w, r)
+	})
+	r.With(RequireRole(RoleAdmin)).Put("/parents/{id}/students/{studentId}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.UpdateGuardianship(db, w, r)
+	})
+	r.With(RequireRole(RoleAdmin)).Delete("/parents/{id}/students/{studentId}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.RemoveGuardianship(db, w, r)
+	})
+	// Home coordinates are only shown to the parent and admins
+	r.With(RequireSelfOrRole(RoleAdmin)).Get("/parents/{id}/geofence", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetHomeGeofence(db, w, r)
+	})
+	// Home fences are checked against the parent's own confirmations, so only
+	// admins may move them
+	r.With(RequireRole(RoleAdmin)).Put("/parents/{id}/geofence", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.PutHomeGeofence(db, w, r)
+	})
+	r.With(RequireRole(RoleAdmin)).Delete("/parents/{id}/geofence", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.DeleteHomeGeofence(db, w, r)
+	})
+	r.With(RequireRole(RoleAdmin)).Delete("/parents/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.DeleteParentByID(db, w, r)
 	})
 }
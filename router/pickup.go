@@ -0,0 +1,40 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func PickupRoute(db *gorm.DB, r chi.Router) {
+	// Authorized pickup persons; the resolvers check custody for changes
+	r.With(RequireRole(RoleParent)).Post("/students/{id}/pickups", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.CreateAuthorizedPickup(db, w, r)
+	})
+	r.With(RequireStudentAccess(db, RoleStaff)).Get("/students/{id}/pickups", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetAuthorizedPickupsByStudent(db, w, r)
+	})
+	r.Get("/pickups/{id}/photo", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetAuthorizedPickupPhoto(db, w, r)
+	})
+	r.With(RequireRole(RoleParent)).Put("/pickups/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.UpdateAuthorizedPickup(db, w, r)
+	})
+	r.With(RequireRole(RoleParent)).Delete("/pickups/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.RevokeAuthorizedPickup(db, w, r)
+	})
+
+	// One-time pickup codes, issued by parents and verified by staff at the gate
+	r.With(RequireRole(RoleParent)).Post("/pickup-codes", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.IssuePickupCode(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Post("/pickup-codes/check", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.CheckPickupCode(db, w, r)
+	})
+	// Only teachers and admins may hand a student over
+	r.With(RequireRole(RoleTeacher)).Post("/pickup-codes/redeem", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.RedeemPickupCode(db, w, r)
+	})
+}
@@ -0,0 +1,16 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func ReportRoute(db *gorm.DB, r chi.Router) {
+	// Attendance aggregates over a date range; teachers are limited to their grade
+	r.With(RequireRole(RoleStaff)).Get("/reports/attendance", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetAttendanceReport(db, w, r)
+	})
+}
@@ -0,0 +1,28 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func ScheduledMessageRoute(db *gorm.DB, r chi.Router) {
+	// Staff schedule messages and broadcasts, and edit or cancel them while pending
+	r.With(RequireRole(RoleStaff)).Get("/scheduled-messages", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetScheduledMessages(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Post("/scheduled-messages", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.CreateScheduledMessage(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Get("/scheduled-messages/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetScheduledMessageByID(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Put("/scheduled-messages/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.UpdateScheduledMessage(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Delete("/scheduled-messages/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.CancelScheduledMessage(db, w, r)
+	})
+}
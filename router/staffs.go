@@ -8,21 +8,21 @@ import (
 	"gorm.io/gorm"
 )
 
-func StaffRoute(db *gorm.DB, r *chi.Mux) {
+func StaffRoute(db *gorm.DB, r chi.Router) {
 	// Define routes for CRUD operations
-	r.Post("/staffs", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleAdmin)).Post("/staffs", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.CreateStaff(db, w, r)
 	})
-	r.Get("/staffs/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireSelfOrRole(RoleAdmin)).Get("/staffs/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetStaffByID(db, w, r)
 	})
-	r.Get("/staffs/all", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleStaff)).Get("/staffs/all", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetAllStaffs(db, w, r)
 	})
-	r.Put("/staffs/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireSelfOrRole(RoleAdmin)).Put("/staffs/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.UpdateStaffByID(db, w, r)
 	})
-	r.Delete("/staffs/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleAdmin)).Delete("/staffs/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.DeleteStaffByID(db, w, r)
 	})
 }
@@ -4,30 +4,38 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/mineracail/guardApi/middleware"
 	"github.com/mineracail/guardApi/resolvers"
 	"gorm.io/gorm"
 )
 
-func StudentRoute(db *gorm.DB, r *chi.Mux) {
+func StudentRoute(db *gorm.DB, r chi.Router) {
 	// Define routes for CRUD operations
-	r.Post("/students", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleAdmin)).Post("/students", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.CreateStudent(db, w, r)
 	})
-	r.Get("/students/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireStudentAccess(db, RoleStaff)).Get("/students/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetStudentByID(db, w, r)
 	})
-	r.Get("/students/all", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireStudentAccess(db, RoleStaff)).Get("/students/{id}/guardians", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetGuardiansByStudent(db, w, r)
+	})
+	r.With(RequireStudentAccess(db, RoleStaff)).Get("/students/{id}/attendance", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetStudentAttendance(db, w, r)
+	})
+	// The resolver checks who may record which state
+	r.Post("/students/{id}/attendance", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.RecordAttendance(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Get("/attendance", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetAttendance(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Get("/students/all", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.GetAllStudents(db, w, r)
 	})
-	r.Put("/students/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleAdmin)).Put("/students/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.UpdateStudentByID(db, w, r)
 	})
-	r.Delete("/students/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.With(RequireRole(RoleAdmin)).Delete("/students/{id}", func(w http.ResponseWriter, r *http.Request) {
 		resolvers.DeleteStudentByID(db, w, r)
 	})
-		// Login route for authentication
-		r.Post("/login", func(w http.ResponseWriter, r *http.Request) {
-			middleware.Login(db, w, r)
-		})
 }
@@ -0,0 +1,31 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mineracail/guardApi/resolvers"
+	"gorm.io/gorm"
+)
+
+func TemplateRoute(db *gorm.DB, r chi.Router) {
+	// Staff share templates; only their owner or an admin changes them
+	r.With(RequireRole(RoleStaff)).Get("/message-templates", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetMessageTemplates(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Post("/message-templates", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.CreateMessageTemplate(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Get("/message-templates/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.GetMessageTemplateByID(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Put("/message-templates/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.UpdateMessageTemplate(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Delete("/message-templates/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.DeleteMessageTemplate(db, w, r)
+	})
+	r.With(RequireRole(RoleStaff)).Post("/message-templates/{id}/preview", func(w http.ResponseWriter, r *http.Request) {
+		resolvers.PreviewMessageTemplate(db, w, r)
+	})
+}
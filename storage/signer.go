@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mineracail/guardApi/middleware/helpers"
+)
+
+// Errors returned for download links that cannot be used.
+var (
+	ErrLinkExpired = errors.New("download link has expired")
+	ErrLinkInvalid = errors.New("download link is invalid")
+)
+
+// Signer signs download links so they can be used without a token until
+// they expire.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns a signer using key.
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// SignerFromEnv returns a signer keyed with DOWNLOAD_URL_SECRET. A missing
+// or placeholder secret is an error: anyone who knows it can sign links.
+func SignerFromEnv() (*Signer, error) {
+	secret := os.Getenv("DOWNLOAD_URL_SECRET")
+	if helpers.IsPlaceholderSecret(secret) {
+		return nil, errors.New("DOWNLOAD_URL_SECRET must be set to a secret of your own")
+	}
+	return NewSigner([]byte(secret)), nil
+}
+
+// Sign returns the signature allowing resource to be downloaded until
+// expires.
+func (s *Signer) Sign(resource string, expires time.Time) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(resource + "\n" + strconv.FormatInt(expires.Unix(), 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature from Sign for resource at now. expires is the
+// Unix time the link was signed with.
+func (s *Signer) Verify(resource, expires, signature string, now time.Time) error {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrLinkInvalid
+	}
+	expected := s.Sign(resource, time.Unix(unix, 0))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrLinkInvalid
+	}
+	if now.Unix() >= unix {
+		return ErrLinkExpired
+	}
+	return nil
+}
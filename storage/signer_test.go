@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	signer := NewSigner([]byte("test-secret"))
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	unix := strconv.FormatInt(expires.Unix(), 10)
+	signature := signer.Sign("attachments/1", expires)
+
+	cases := []struct {
+		name      string
+		resource  string
+		expires   string
+		signature string
+		now       time.Time
+		want      error
+	}{
+		{"valid", "attachments/1", unix, signature, now, nil},
+		{"just before expiry", "attachments/1", unix, signature, expires.Add(-time.Second), nil},
+		{"expired", "attachments/1", unix, signature, expires, ErrLinkExpired},
+		{"other resource", "attachments/2", unix, signature, now, ErrLinkInvalid},
+		{"extended expiry", "attachments/1", strconv.FormatInt(expires.Add(time.Hour).Unix(), 10), signature, now, ErrLinkInvalid},
+		{"malformed expiry", "attachments/1", "soon", signature, now, ErrLinkInvalid},
+		{"tampered signature", "attachments/1", unix, signature[:len(signature)-1] + "A", now, ErrLinkInvalid},
+		{"empty signature", "attachments/1", unix, "", now, ErrLinkInvalid},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := signer.Verify(c.resource, c.expires, c.signature, c.now); !errors.Is(err, c.want) {
+				t.Errorf("Verify = %v, want %v", err, c.want)
+			}
+		})
+	}
+
+	other := NewSigner([]byte("other-secret"))
+	if err := other.Verify("attachments/1", unix, signature, now); !errors.Is(err, ErrLinkInvalid) {
+		t.Errorf("Verify with another key = %v, want %v", err, ErrLinkInvalid)
+	}
+}
+
+func TestSignerFromEnv(t *testing.T) {
+	for _, secret := range []string{"", "change-me", "change-me-download"} {
+		t.Setenv("DOWNLOAD_URL_SECRET", secret)
+		if _, err := SignerFromEnv(); err == nil {
+			t.Errorf("SignerFromEnv accepted %q", secret)
+		}
+	}
+
+	t.Setenv("DOWNLOAD_URL_SECRET", "a-secret-of-our-own")
+	if _, err := SignerFromEnv(); err != nil {
+		t.Errorf("SignerFromEnv: %v", err)
+	}
+}
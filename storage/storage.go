@@ -0,0 +1,60 @@
+// Package storage keeps uploaded files in a blob store, the local filesystem
+// or an S3-compatible bucket, and signs the expiring links they are
+// downloaded through.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// ErrNotFound is returned, possibly wrapped, when a blob does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores blobs under keys made of slash-separated path segments.
+// Implementations must be safe for concurrent use.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Blobs is the store uploads are kept in. It is set at startup.
+var Blobs BlobStore
+
+// Links signs and checks download links. It is set at startup.
+var Links *Signer
+
+// FromEnv builds the blob store selected by BLOB_STORE:
+//
+//	fs (default)  files under BLOB_DIR, tmp/blobs unless set
+//	s3            S3_BUCKET at S3_ENDPOINT (AWS when empty) in S3_REGION,
+//	              authenticated with S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY;
+//	              S3_PATH_STYLE=true addresses the bucket in the path, as
+//	              MinIO and most other S3-compatible servers expect
+func FromEnv() (BlobStore, error) {
+	switch kind := os.Getenv("BLOB_STORE"); kind {
+	case "", "fs":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "tmp/blobs"
+		}
+		return NewFSStore(dir)
+	case "s3":
+		pathStyle, _ := strconv.ParseBool(os.Getenv("S3_PATH_STYLE"))
+		return NewS3Store(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle:       pathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported BLOB_STORE %q", kind)
+	}
+}